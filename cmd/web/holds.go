@@ -0,0 +1,28 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sindrishtepani/bookings/internal/repository"
+)
+
+// listenForExpiredHolds removes room holds that have run out every interval, so rooms
+// left behind by guests who never finished checking out can be booked again
+func listenForExpiredHolds(repo repository.DataseRepo, interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			released, err := repo.DeleteExpiredHolds()
+			if err != nil {
+				app.ErrorLog.Println(err)
+				continue
+			}
+
+			if released > 0 {
+				app.InfoLog.Printf("Released %d expired room holds", released)
+			}
+		}
+	}()
+}
@@ -1,20 +1,25 @@
 package main
 
 import (
+	"crypto/rand"
 	"encoding/gob"
+	"encoding/hex"
 	"flag"
 	"fmt"
 	"log"
 	"net/http"
 	"os"
+	"strings"
 	"time"
 
 	"github.com/sindrishtepani/bookings/internal/config"
 	"github.com/sindrishtepani/bookings/internal/driver"
 	"github.com/sindrishtepani/bookings/internal/handlers"
 	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/media"
 	"github.com/sindrishtepani/bookings/internal/models"
 	"github.com/sindrishtepani/bookings/internal/render"
+	"github.com/sindrishtepani/bookings/internal/repository/dbrepo"
 
 	"github.com/alexedwards/scs/v2"
 )
@@ -36,6 +41,8 @@ func main() {
 	defer db.SQL.Close()
 	defer close(app.MailChan)
 	listenForMail()
+	listenForExpiredHolds(dbrepo.NewPostgresRepo(db.SQL, &app), time.Minute)
+	listenForLapsedWaitlistOffers(time.Minute)
 
 	fmt.Print("Starting application on port", portNumber)
 
@@ -55,10 +62,19 @@ func run() (*driver.DB, error) {
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
+	gob.Register([]int{})
+	gob.Register(models.ReservationGroup{})
 
 	// read flags
 	inProduction := flag.Bool("production", true, "Application is in production")
 	UseCache := flag.Bool("cache", true, "Use template cache")
+	baseURL := flag.String("url", "http://localhost:8080", "Public URL of the site, used in emailed links")
+	signingKey := flag.String("signkey", "", "Secret used to sign links, login stamps and cookies, required in production")
+	uploadDir := flag.String("uploads", "./uploads", "Directory uploaded room photos are stored in")
+	holdTTL := flag.Duration("holdttl", 15*time.Minute, "How long a room is held while a guest fills in the reservation form")
+	twoFactorRoles := flag.String("2fa-roles", "", "Comma separated roles that must use two-factor authentication, e.g. manager,owner")
+	rememberDevice := flag.Duration("2fa-remember", 30*24*time.Hour, "How long a device can skip the two-factor step, 0 to always ask")
+	ipHeader := flag.String("ipheader", "", "Header a trusted reverse proxy puts the client's IP address in, e.g. X-Forwarded-For")
 
 	dbHost := flag.String("dbhost", "localhost", "Database host")
 	dbName := flag.String("dbname", "", "Database name")
@@ -74,12 +90,37 @@ func run() (*driver.DB, error) {
 		os.Exit(1)
 	}
 
+	// a random key would change on every restart, breaking emailed links and logging out all staff
+	if *inProduction && *signingKey == "" {
+		fmt.Println("Missing required flag -signkey, needed when -production is set")
+		os.Exit(1)
+	}
+
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
 
 	// change this to true when in production
 	app.InProduction = *inProduction
 	app.UseCache = *UseCache
+	app.BaseURL = *baseURL
+	app.SigningKey = *signingKey
+	app.HoldTTL = *holdTTL
+	app.RememberDeviceFor = *rememberDevice
+	app.ClientIPHeader = *ipHeader
+
+	app.TwoFactorRoles = make(map[models.Role]bool)
+	for _, name := range strings.Split(*twoFactorRoles, ",") {
+		if strings.TrimSpace(name) == "" {
+			continue
+		}
+
+		role, err := models.ParseRole(name)
+		if err != nil {
+			return nil, err
+		}
+		app.TwoFactorRoles[role] = true
+	}
+	app.Storage = media.NewLocalStorage(*uploadDir)
 
 	infoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -95,6 +136,16 @@ func run() (*driver.DB, error) {
 
 	app.Session = session
 
+	if app.SigningKey == "" {
+		key := make([]byte, 32)
+		_, err := rand.Read(key)
+		if err != nil {
+			return nil, err
+		}
+		app.SigningKey = hex.EncodeToString(key)
+		app.InfoLog.Println("No -signkey given, emailed links and logins will stop working on restart")
+	}
+
 	app.InfoLog.Println("Connecting to database")
 	connectionString := fmt.Sprintf("host=%s port=%s dbname=%s user=%s password=%s sslmode=%s",
 		*dbHost,
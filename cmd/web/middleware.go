@@ -1,10 +1,16 @@
 package main
 
 import (
+	"errors"
 	"net/http"
+	"strings"
 
 	"github.com/justinas/nosurf"
+	"github.com/sindrishtepani/bookings/internal/handlers"
 	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/media"
+	"github.com/sindrishtepani/bookings/internal/models"
+	"github.com/sindrishtepani/bookings/internal/repository"
 )
 
 // NoSurf Adds CRSF protection in all POST requests
@@ -18,21 +24,152 @@ func NoSurf(next http.Handler) http.Handler {
 		SameSite: http.SameSiteLaxMode,
 	})
 
+	// a browser can't be tricked into sending someone's API token, so requests APIToken has already
+	// let in with one don't need a csrf token. Sending the header alone isn't enough
+	crsfHandler.ExemptFunc(func(r *http.Request) bool {
+		_, ok := helpers.TokenUser(r)
+		return ok
+	})
+
 	return crsfHandler
 }
 
+// LimitRequestBody caps the size of request bodies so uploads can't exhaust memory or disk. It has
+// to run before NoSurf, which parses the form to find the csrf token
+func LimitRequestBody(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// leave room for the other form fields sent with a photo
+		r.Body = http.MaxBytesReader(w, r.Body, media.MaxPhotoSize+1<<20)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// APIToken checks the API token sent to the admin, and serves the request as the token's user. It
+// has to run before NoSurf, which lets through requests made with a token it has accepted. Requests
+// elsewhere, or without a token, pass through untouched
+func APIToken(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, ok := bearerToken(r)
+		if !ok || (r.URL.Path != "/admin" && !strings.HasPrefix(r.URL.Path, "/admin/")) {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		tokenAuth(w, r, next, token)
+	})
+}
+
 // SessionLoad Saves loads and saves the session on every request
 func SessionLoad(next http.Handler) http.Handler {
 	return session.LoadAndSave(next)
 }
 
+// Auth only lets through logged in users. The user is reloaded on every request, so deactivating
+// someone, changing their role or resetting their password takes effect straight away rather than
+// at their next login. Requests APIToken has let in with an API token have been checked already
 func Auth(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := helpers.TokenUser(r); ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
 		if !helpers.IsAuthenticated(r) {
 			session.Put(r.Context(), "error", "Log in first!")
 			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
 			return
 		}
+
+		user, err := handlers.Repo.DB.GetUserByID(session.GetInt(r.Context(), "user_id"))
+		if err != nil || user.Active == 0 || !helpers.ValidPasswordStamp(user.Password, session.GetString(r.Context(), "password_stamp")) {
+			_ = session.Destroy(r.Context())
+			_ = session.RenewToken(r.Context())
+			session.Put(r.Context(), "error", "Log in first!")
+			http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+			return
+		}
+		session.Put(r.Context(), "access_level", int(user.Role()))
+
+		// roles that must use two-factor login can't go further until they've set it up
+		if app.TwoFactorRoles[user.Role()] && user.TOTPEnabled == 0 && r.URL.Path != "/admin/two-factor" {
+			session.Put(r.Context(), "warning", "Set up two-factor login to carry on")
+			http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+			return
+		}
+
 		next.ServeHTTP(w, r)
 	})
 }
+
+// bearerToken returns the API token sent in the request's Authorization header
+func bearerToken(r *http.Request) (string, bool) {
+	scheme, token, ok := strings.Cut(r.Header.Get("Authorization"), " ")
+	if !ok || !strings.EqualFold(scheme, "Bearer") {
+		return "", false
+	}
+
+	return strings.TrimSpace(token), true
+}
+
+// tokenAuth serves a request made with an API token as the token's user, without a session. A read
+// token acts as the read-only role whatever its user's role, so it passes the role checks for looking
+// around but none of those for changing things
+func tokenAuth(w http.ResponseWriter, r *http.Request, next http.Handler, token string) {
+	t, err := handlers.Repo.DB.UseAPIToken(helpers.HashToken(token))
+	if errors.Is(err, repository.ErrInvalidToken) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		helpers.ClientError(w, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// a token stands in for its user's login, so it stops working whenever they couldn't log in:
+	// deactivated, locked out, or in a role that must use two-factor login they haven't set up
+	user, err := handlers.Repo.DB.GetUserByID(t.UserID)
+	if err != nil || user.Active == 0 || user.Locked() || (app.TwoFactorRoles[user.Role()] && user.TOTPEnabled == 0) {
+		w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+		helpers.ClientError(w, http.StatusUnauthorized)
+		return
+	}
+
+	if t.Scope != models.APIScopeWrite {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("WWW-Authenticate", `Bearer error="insufficient_scope"`)
+			helpers.ClientError(w, http.StatusForbidden)
+			return
+		}
+		user.AccessLevel = int(models.RoleReadOnly)
+	}
+
+	next.ServeHTTP(w, helpers.WithTokenUser(r, user))
+}
+
+// RequireSession refuses requests made with an API token, for the pages that manage how a user
+// logs in and their API tokens. It goes after Auth
+func RequireSession(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if _, ok := helpers.TokenUser(r); ok {
+			helpers.ClientError(w, http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// RequireRole only lets through users whose role is at least role, and shows everyone else the
+// forbidden page. It goes after Auth
+func RequireRole(role models.Role) func(http.Handler) http.Handler {
+	return func(next http.Handler) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if helpers.UserRole(r) < role {
+				handlers.Repo.Forbidden(w, r)
+				return
+			}
+			next.ServeHTTP(w, r)
+		})
+	}
+}
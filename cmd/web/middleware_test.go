@@ -3,7 +3,11 @@ package main
 import (
 	"fmt"
 	"net/http"
+	"net/http/httptest"
 	"testing"
+
+	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/models"
 )
 
 func TestNoSurve(t *testing.T) {
@@ -31,3 +35,148 @@ func TestSessionLoad(t *testing.T) {
 		t.Error(fmt.Printf("type is not http.Handler, but is %T", v))
 	}
 }
+
+func TestRequireRole(t *testing.T) {
+	var testHandler myHandler
+
+	h := RequireRole(models.RoleManager)(&testHandler)
+
+	tests := []struct {
+		name     string
+		role     models.Role
+		token    bool
+		expected int
+	}{
+		{"read only", models.RoleReadOnly, false, http.StatusForbidden},
+		{"front desk", models.RoleFrontDesk, false, http.StatusForbidden},
+		{"manager", models.RoleManager, false, http.StatusOK},
+		{"owner", models.RoleOwner, false, http.StatusOK},
+		{"front desk token", models.RoleFrontDesk, true, http.StatusForbidden},
+		{"manager token", models.RoleManager, true, http.StatusOK},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/admin/rooms", nil)
+		ctx, _ := session.Load(req.Context(), "")
+		req = req.WithContext(ctx)
+
+		if e.token {
+			req = helpers.WithTokenUser(req, models.User{ID: 1, AccessLevel: int(e.role)})
+		} else {
+			session.Put(ctx, "user_id", 1)
+			session.Put(ctx, "access_level", int(e.role))
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, rr.Code)
+		}
+	}
+}
+
+func TestRequireSession(t *testing.T) {
+	var testHandler myHandler
+
+	h := RequireSession(&testHandler)
+
+	switch v := h.(type) {
+	case http.Handler:
+		// do nothing
+	default:
+		t.Error(fmt.Printf("type is not http.Handler, but is %T", v))
+	}
+}
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header   string
+		expected string
+		ok       bool
+	}{
+		{"Bearer bk_abc123", "bk_abc123", true},
+		{"bearer bk_abc123", "bk_abc123", true},
+		{"Basic dXNlcjpwYXNz", "", false},
+		{"Bearer", "", false},
+		{"", "", false},
+	}
+
+	for _, e := range tests {
+		r, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+		if e.header != "" {
+			r.Header.Set("Authorization", e.header)
+		}
+
+		token, ok := bearerToken(r)
+		if token != e.expected || ok != e.ok {
+			t.Errorf("%q: expected %q (%t) but got %q (%t)", e.header, e.expected, e.ok, token, ok)
+		}
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	var testHandler myHandler
+
+	tests := []struct {
+		name      string
+		method    string
+		token     string
+		twoFactor bool
+		expected  int
+	}{
+		{"write token", "POST", "write-token", false, http.StatusOK},
+		{"read token looking", "GET", "read-token", false, http.StatusOK},
+		{"read token changing", "POST", "read-token", false, http.StatusForbidden},
+		{"unknown token", "GET", "no-such-token", false, http.StatusUnauthorized},
+		{"two-factor not set up", "GET", "write-token", true, http.StatusUnauthorized},
+	}
+
+	for _, e := range tests {
+		app.TwoFactorRoles = map[models.Role]bool{models.RoleOwner: e.twoFactor}
+
+		req, _ := http.NewRequest(e.method, "/admin/rooms", nil)
+		rr := httptest.NewRecorder()
+		tokenAuth(rr, req, &testHandler, e.token)
+
+		if rr.Code != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, rr.Code)
+		}
+	}
+
+	app.TwoFactorRoles = nil
+}
+
+func TestAPIToken(t *testing.T) {
+	var testHandler myHandler
+
+	// NoSurf refuses any POST without a csrf token, unless APIToken has accepted an API token first
+	h := APIToken(NoSurf(&testHandler))
+
+	tests := []struct {
+		name     string
+		url      string
+		header   string
+		expected int
+	}{
+		{"admin with token", "/admin/rooms", "Bearer write-token", http.StatusOK},
+		{"admin with bad token", "/admin/rooms", "Bearer no-such-token", http.StatusUnauthorized},
+		{"admin without token", "/admin/rooms", "", http.StatusBadRequest},
+		{"token outside the admin", "/make-reservation", "Bearer write-token", http.StatusBadRequest},
+		{"bad token outside the admin", "/make-reservation", "Bearer no-such-token", http.StatusBadRequest},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		if e.header != "" {
+			req.Header.Set("Authorization", e.header)
+		}
+
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != e.expected {
+			t.Errorf("%s: expected %d but got %d", e.name, e.expected, rr.Code)
+		}
+	}
+}
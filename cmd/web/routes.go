@@ -5,6 +5,7 @@ import (
 
 	"github.com/sindrishtepani/bookings/internal/config"
 	"github.com/sindrishtepani/bookings/internal/handlers"
+	"github.com/sindrishtepani/bookings/internal/models"
 
 	"github.com/go-chi/chi"
 	"github.com/go-chi/chi/middleware"
@@ -14,43 +15,121 @@ func routes(app *config.AppConfig) http.Handler {
 	mux := chi.NewRouter()
 
 	mux.Use(middleware.Recoverer)
+	mux.Use(LimitRequestBody)
+	mux.Use(APIToken)
 	mux.Use(NoSurf)
 	mux.Use(SessionLoad)
 
 	mux.Get("/", handlers.Repo.Home)
 	mux.Get("/about", handlers.Repo.About)
-	mux.Get("/generals-quarters", handlers.Repo.Generals)
-	mux.Get("/majors-suite", handlers.Repo.Majors)
+	mux.Get("/rooms", handlers.Repo.Rooms)
+	mux.Get("/rooms/{slug}", handlers.Repo.Room)
+	mux.Get("/photos/{size}/{name}", handlers.Repo.Photo)
+	mux.Get("/generals-quarters", handlers.Repo.LegacyRoom)
+	mux.Get("/majors-suite", handlers.Repo.LegacyRoom)
 
 	mux.Get("/search", handlers.Repo.Availability)
 	mux.Post("/search", handlers.Repo.PostAvailability)
 	mux.Post("/search-json", handlers.Repo.AvailabilityJSON)
-	mux.Get("/choose-room/{id}", handlers.Repo.ChooseRoom)
-	mux.Get("/book-room", handlers.Repo.BookRoom)
+	mux.Post("/choose-room/{id}", handlers.Repo.ChooseRoom)
+	mux.Post("/choose-rooms", handlers.Repo.PostChooseRooms)
+	mux.Post("/cart/remove/{id}", handlers.Repo.RemoveFromCart)
+	mux.Post("/book-room", handlers.Repo.BookRoom)
+
+	mux.Get("/waitlist", handlers.Repo.Waitlist)
+	mux.Post("/waitlist", handlers.Repo.PostWaitlist)
+	mux.Get("/waitlist/{id}/book", handlers.Repo.WaitlistBook)
 
 	mux.Get("/contact", handlers.Repo.Contact)
 
 	mux.Get("/make-reservation", handlers.Repo.Reservation)
 	mux.Post("/make-reservation", handlers.Repo.PostReservation)
 	mux.Get("/reservation-summary", handlers.Repo.ReservationSummary)
+	mux.Get("/make-group-reservation", handlers.Repo.GroupReservation)
+	mux.Post("/make-group-reservation", handlers.Repo.PostGroupReservation)
+	mux.Get("/group-reservation-summary", handlers.Repo.GroupReservationSummary)
+	mux.Get("/reservations/lookup", handlers.Repo.ReservationLookup)
+	mux.Post("/reservations/lookup", handlers.Repo.PostReservationLookup)
+	mux.Get("/reservations/{code}", handlers.Repo.GuestReservation)
+	mux.Get("/reservations/{code}/cancel", handlers.Repo.GuestCancelReservation)
+	mux.Post("/reservations/{code}/cancel", handlers.Repo.PostGuestCancelReservation)
+
+	mux.Get("/account/register", handlers.Repo.GuestRegister)
+	mux.Post("/account/register", handlers.Repo.PostGuestRegister)
+	mux.Get("/account/login", handlers.Repo.GuestLogin)
+	mux.Post("/account/login", handlers.Repo.PostGuestLogin)
+	mux.Get("/account/logout", handlers.Repo.GuestLogout)
+	mux.Get("/account/bookings", handlers.Repo.GuestBookings)
 
 	mux.Get("/user/login", handlers.Repo.ShowLogin)
 	mux.Post("/user/login", handlers.Repo.PostShowLogin)
+	mux.Get("/user/two-factor", handlers.Repo.TwoFactor)
+	mux.Post("/user/two-factor", handlers.Repo.PostTwoFactor)
 	mux.Get("/user/logout", handlers.Repo.Logout)
+	mux.Get("/user/forgot-password", handlers.Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", handlers.Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", handlers.Repo.ResetPassword)
+	mux.Post("/user/reset-password", handlers.Repo.PostResetPassword)
+	mux.Get("/user/invite", handlers.Repo.UserInvite)
+	mux.Post("/user/invite", handlers.Repo.PostUserInvite)
 
 	mux.Route("/admin", func(mux chi.Router) {
-		//mux.Use(Auth)
+		mux.Use(Auth)
+
+		// every role can look around the admin, the roles needed to change things are set per route
+		frontDesk := RequireRole(models.RoleFrontDesk)
+		manager := RequireRole(models.RoleManager)
+		owner := RequireRole(models.RoleOwner)
+
 		mux.Get("/dashboard", handlers.Repo.AdminDashboard)
+		mux.With(RequireSession).Get("/two-factor", handlers.Repo.AdminTwoFactor)
+		mux.With(RequireSession).Post("/two-factor", handlers.Repo.AdminPostTwoFactor)
+		mux.With(RequireSession).Get("/api-tokens", handlers.Repo.AdminAPITokens)
+		mux.With(RequireSession).Post("/api-tokens", handlers.Repo.AdminPostAPIToken)
+		mux.With(RequireSession).Post("/revoke-api-token", handlers.Repo.AdminRevokeAPIToken)
 
 		mux.Get("/reservations-new", handlers.Repo.AdminNewReservations)
 		mux.Get("/reservations-all", handlers.Repo.AdminAllReservations)
+		mux.With(frontDesk).Get("/make-reservation", handlers.Repo.AdminMakeReservation)
+		mux.With(frontDesk).Post("/make-reservation", handlers.Repo.AdminPostMakeReservation)
 		mux.Get("/reservations-calendar", handlers.Repo.AdminReservationsCalendar)
-		mux.Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.With(manager).Post("/reservations-calendar", handlers.Repo.AdminPostReservationsCalendar)
+		mux.Get("/reservations-timeline", handlers.Repo.AdminReservationsTimeline)
+		mux.With(manager).Get("/blocks/{id}", handlers.Repo.AdminShowBlock)
+		mux.With(manager).Post("/blocks/{id}", handlers.Repo.AdminPostBlock)
+		mux.With(manager).Post("/delete-block", handlers.Repo.AdminDeleteBlock)
 
 		mux.Get("/reservations/{src}/", handlers.Repo.AdminShowReservation)
-		mux.Post("/reservations/{src}/", handlers.Repo.AdminPostShowReservation)
-		mux.Get("/process-reservation/{src}/", handlers.Repo.AdminProcessReservation)
-		mux.Get("/delete-reservation/{src}/", handlers.Repo.AdminDeleteReservation)
+		mux.With(frontDesk).Post("/reservations/{src}/", handlers.Repo.AdminPostShowReservation)
+		mux.With(frontDesk).Get("/process-reservation/{src}/", handlers.Repo.AdminProcessReservation)
+		mux.With(manager).Get("/delete-reservation/{src}/", handlers.Repo.AdminDeleteReservation)
+		mux.Get("/groups/{id}", handlers.Repo.AdminShowGroup)
+
+		mux.Get("/rates", handlers.Repo.AdminRoomRates)
+		mux.With(manager).Post("/rates", handlers.Repo.AdminPostRoomRate)
+		mux.With(manager).Post("/delete-rate", handlers.Repo.AdminDeleteRoomRate)
+
+		mux.Get("/rooms", handlers.Repo.AdminRooms)
+		mux.Get("/rooms/{id}", handlers.Repo.AdminShowRoom)
+		mux.With(manager).Post("/rooms/{id}", handlers.Repo.AdminPostRoom)
+		mux.Get("/rooms/{id}/photos", handlers.Repo.AdminRoomPhotos)
+		mux.With(manager).Post("/rooms/{id}/photos", handlers.Repo.AdminPostRoomPhoto)
+		mux.With(manager).Post("/room-photos/{id}", handlers.Repo.AdminPostRoomPhotoDetails)
+		mux.With(manager).Post("/delete-room-photo", handlers.Repo.AdminDeleteRoomPhoto)
+		mux.With(manager).Post("/archive-room", handlers.Repo.AdminArchiveRoom)
+		mux.With(owner).Post("/delete-room", handlers.Repo.AdminDeleteRoom)
+
+		mux.Get("/stay-rules", handlers.Repo.AdminStayRules)
+		mux.With(manager).Post("/stay-rules", handlers.Repo.AdminPostStayRule)
+		mux.With(manager).Post("/delete-stay-rule", handlers.Repo.AdminDeleteStayRule)
+
+		mux.With(owner).Get("/users", handlers.Repo.AdminUsers)
+		mux.With(owner).Get("/users/{id}", handlers.Repo.AdminShowUser)
+		mux.With(owner).Post("/users/{id}", handlers.Repo.AdminPostUser)
+		mux.With(owner).Post("/activate-user", handlers.Repo.AdminActivateUser)
+		mux.With(owner).Post("/resend-invite", handlers.Repo.AdminResendInvite)
+		mux.With(owner).Post("/unlock-user", handlers.Repo.AdminUnlockUser)
+		mux.With(owner).Post("/reset-two-factor", handlers.Repo.AdminResetTwoFactor)
 	})
 
 	fileServer := http.FileServer(http.Dir("./static/"))
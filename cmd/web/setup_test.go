@@ -1,12 +1,34 @@
 package main
 
 import (
+	"html/template"
+	"log"
 	"net/http"
 	"os"
 	"testing"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/sindrishtepani/bookings/internal/handlers"
+	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/render"
 )
 
 func TestMain(m *testing.M) {
+	app.InfoLog = log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
+	app.ErrorLog = log.New(os.Stdout, "ERROR\t", log.Ldate|log.Ltime|log.Lshortfile)
+
+	session = scs.New()
+	session.Lifetime = 24 * time.Hour
+	app.Session = session
+
+	// the middleware only needs the status codes of the pages it shows, not the pages themselves
+	app.UseCache = true
+	app.TemplateCache = map[string]*template.Template{}
+
+	handlers.NewHandler(handlers.NewTestRepo(&app))
+	render.NewRenderer(&app)
+	helpers.NewHelpers(&app)
 
 	os.Exit(m.Run())
 }
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"time"
+
+	"github.com/sindrishtepani/bookings/internal/handlers"
+)
+
+// listenForLapsedWaitlistOffers checks every interval for waitlist offers that ran out unused and
+// offers those rooms to the next guests in line
+func listenForLapsedWaitlistOffers(interval time.Duration) {
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+
+		for range ticker.C {
+			handlers.Repo.PassOnLapsedWaitlistOffers()
+		}
+	}()
+}
@@ -3,8 +3,10 @@ package config
 import (
 	"html/template"
 	"log"
+	"time"
 
 	"github.com/alexedwards/scs/v2"
+	"github.com/sindrishtepani/bookings/internal/media"
 	"github.com/sindrishtepani/bookings/internal/models"
 )
 
@@ -17,4 +19,15 @@ type AppConfig struct {
 	InProduction  bool
 	Session       *scs.SessionManager
 	MailChan      chan models.MailData
+	BaseURL       string
+	SigningKey    string
+	HoldTTL       time.Duration
+	Storage       media.Storage
+	// TwoFactorRoles are the roles that must use two-factor authentication to reach the admin
+	TwoFactorRoles map[models.Role]bool
+	// RememberDeviceFor is how long a device can skip the two-factor step after a code is given on it
+	RememberDeviceFor time.Duration
+	// ClientIPHeader is the header a trusted reverse proxy puts the client's IP address in, or empty
+	// when clients connect straight to the app
+	ClientIPHeader string
 }
@@ -1,9 +1,19 @@
 package handlers
 
 import (
+	"context"
+	"database/sql"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"html/template"
+	"io"
+	"io/fs"
+	"math"
+	"net"
 	"net/http"
+	"net/url"
+	"sort"
 	"strconv"
 	"strings"
 	"time"
@@ -13,10 +23,12 @@ import (
 	"github.com/sindrishtepani/bookings/internal/driver"
 	"github.com/sindrishtepani/bookings/internal/forms"
 	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/media"
 	"github.com/sindrishtepani/bookings/internal/models"
 	"github.com/sindrishtepani/bookings/internal/render"
 	"github.com/sindrishtepani/bookings/internal/repository"
 	"github.com/sindrishtepani/bookings/internal/repository/dbrepo"
+	"github.com/sindrishtepani/bookings/internal/totp"
 )
 
 var Repo *Repository
@@ -48,7 +60,6 @@ func NewHandler(r *Repository) {
 }
 
 func (m *Repository) Home(w http.ResponseWriter, r *http.Request) {
-	m.DB.AllUsers()
 	render.Template(w, r, "home.page.tmpl", &models.TemplateData{})
 }
 
@@ -65,6 +76,14 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// guests with an account don't have to type in their details again
+	if g, ok := m.loggedInGuest(r); ok && res.FirstName == "" {
+		res.FirstName = g.FirstName
+		res.LastName = g.LastName
+		res.Email = g.Email
+		res.Phone = g.Phone
+	}
+
 	room, err := m.DB.GetRoomByID(res.RoomID)
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't find room")
@@ -74,6 +93,15 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	res.Room.RoomName = room.RoomName
 
+	price, err := m.DB.GetPriceForStay(res.RoomID, res.StartDate, res.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get price for room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.TotalPrice = price.Total
+
 	m.App.Session.Put(r.Context(), "reservation", res)
 
 	sd := res.StartDate.Format("2006-01-02")
@@ -85,6 +113,7 @@ func (m *Repository) Reservation(w http.ResponseWriter, r *http.Request) {
 
 	data := make(map[string]interface{})
 	data["reservation"] = res
+	data["price"] = price
 
 	render.Template(w, r, "make-reservation.page.tmpl", &models.TemplateData{
 		Form:      forms.New(nil),
@@ -112,6 +141,7 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 	reservation.LastName = r.Form.Get("last_name")
 	reservation.Phone = r.Form.Get("phone")
 	reservation.Email = r.Form.Get("email")
+	reservation.GuestID = m.App.Session.GetInt(r.Context(), "guest_id")
 
 	form := forms.New(r.PostForm)
 
@@ -129,74 +159,219 @@ func (m *Repository) PostReservation(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	newReservationID, err := m.DB.InsertReservation(reservation)
+	if reservation.Adults < 1 {
+		reservation.Adults = 1
+	}
+
+	problem, err := m.reservationProblem(reservation)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert new reservation")
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if problem != "" {
+		m.abandonReservation(r.Context(), reservation)
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+problem+". Please search again.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	// price the stay again so the total stored is what the rates are at booking time
+	price, err := m.DB.GetPriceForStay(reservation.RoomID, reservation.StartDate, reservation.EndDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get price for room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	reservation.TotalPrice = price.Total
+
+	reservation.ConfirmationCode, err = helpers.NewConfirmationCode()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't create confirmation code")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	restriction := models.RoomRestriction{
-		StartDate:     reservation.StartDate,
-		EndDate:       reservation.EndDate,
-		RoomID:        reservation.RoomID,
-		ReservationID: newReservationID,
-		RestrictionID: 1,
+	newReservationID, err := m.DB.BookRoom(reservation)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, someone just took this room for those dates. Please search again.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
 	}
 
-	err = m.DB.InsertRoomRestriction(restriction)
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "can't insert new room restriction")
+		m.App.Session.Put(r.Context(), "error", "can't insert new reservation")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	reservation.ID = newReservationID
+	reservation.HoldID = 0
+
+	m.sendReservationConfirmation(reservation)
+
 	htmlMessage := fmt.Sprintf(`
 	<strong>Reservation Confirmation</strong><br>
-	Dear %s, <br>
-	This is to confirm your reservation from %s to %s, for %s.`,
-		reservation.FirstName,
+	A reservation from %s to %s, for %s (confirmation code %s).`,
 		reservation.StartDate.Format("2006-01-02"),
 		reservation.EndDate.Format("2006-01-02"),
-		reservation.Room.RoomName)
+		reservation.Room.RoomName,
+		reservation.ConfirmationCode)
 
 	msg := models.MailData{
-		To:       reservation.Email,
+		To:       "me@here.com",
 		From:     "me@here.com",
-		Subject:  "Reservation Confirmation",
+		Subject:  "Reservation Notification",
 		Content:  htmlMessage,
 		Template: "basic.html",
 	}
 
 	m.App.MailChan <- msg
 
-	htmlMessage = fmt.Sprintf(`
-	<strong>Reservation Confirmation</strong><br>
-	A reservation from %s to %s, for %s.`,
-		reservation.StartDate.Format("2006-01-02"),
-		reservation.EndDate.Format("2006-01-02"),
-		reservation.Room.RoomName)
+	m.App.Session.Put(r.Context(), "reservation", reservation)
+	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+}
 
-	msg = models.MailData{
-		To:       "me@here.com",
+// reservationProblem returns why res can't be booked, or an empty string if it can. The search only
+// offers rooms that pass these checks, but guests can reach the booking form other ways, so every
+// booking checks again
+func (m *Repository) reservationProblem(res models.Reservation) (string, error) {
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		return "", err
+	}
+
+	if room.Archived == 1 {
+		return fmt.Sprintf("%s is no longer available", room.RoomName), nil
+	}
+
+	if !room.Sleeps(res.Adults, res.Children) {
+		return fmt.Sprintf("%s sleeps up to %d adults and %d children", room.RoomName, room.MaxAdults, room.MaxChildren), nil
+	}
+
+	rules, err := m.DB.StayRulesForDates(res.StartDate, res.EndDate)
+	if err != nil {
+		return "", err
+	}
+
+	if reason := stayRuleViolation(rules, res.RoomID, res.StartDate, res.EndDate); reason != "" {
+		return fmt.Sprintf("%s: %s", room.RoomName, reason), nil
+	}
+
+	return "", nil
+}
+
+// abandonReservation drops a reservation the guest can't go on with, letting go of its room
+func (m *Repository) abandonReservation(ctx context.Context, res models.Reservation) {
+	if res.HoldID > 0 {
+		err := m.DB.ReleaseHold(res.HoldID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	m.App.Session.Remove(ctx, "reservation")
+}
+
+// sendReservationConfirmation emails the guest the details of their new reservation
+func (m *Repository) sendReservationConfirmation(res models.Reservation) {
+	htmlMessage := fmt.Sprintf(`
+	<strong>Reservation Confirmation</strong><br>
+	Dear %s, <br>
+	This is to confirm your reservation from %s to %s, for %s.<br>
+	The total for your stay is %s.<br>
+	Your confirmation code is <strong>%s</strong>. You can view your reservation at any time
+	<a href="%s/reservations/%s">here</a>.<br>
+	If your plans change, you can <a href="%s%s">cancel your reservation</a>.`,
+		res.FirstName,
+		res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName,
+		render.FormatPrice(res.TotalPrice),
+		res.ConfirmationCode,
+		m.App.BaseURL,
+		res.ConfirmationCode,
+		m.App.BaseURL,
+		cancelURL(res.ConfirmationCode))
+
+	m.App.MailChan <- models.MailData{
+		To:       res.Email,
 		From:     "me@here.com",
-		Subject:  "Reservation Notification",
+		Subject:  "Reservation Confirmation",
 		Content:  htmlMessage,
 		Template: "basic.html",
 	}
+}
 
-	m.App.MailChan <- msg
+// Rooms lists the rooms guests can book
+func (m *Repository) Rooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	m.App.Session.Put(r.Context(), "reservation", reservation)
-	http.Redirect(w, r, "/reservation-summary", http.StatusSeeOther)
+	covers, err := m.DB.CoverPhotos()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+	data["covers"] = covers
+
+	render.Template(w, r, "rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// Room shows a room's page, found by the slug in the url
+func (m *Repository) Room(w http.ResponseWriter, r *http.Request) {
+	room, err := m.DB.GetRoomBySlug(chi.URLParam(r, "slug"))
+	if errors.Is(err, sql.ErrNoRows) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photos, err := m.DB.RoomPhotos(room.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = photos
+
+	render.Template(w, r, "room.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
 }
 
-func (m *Repository) Generals(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "generals.page.tmpl", &models.TemplateData{})
+// legacyRoomSlugs maps the urls rooms had before room pages came from the database to their slugs
+var legacyRoomSlugs = map[string]string{
+	"/generals-quarters": "generals-quarters",
+	"/majors-suite":      "majors-suite",
 }
 
-func (m *Repository) Majors(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "majors.page.tmpl", &models.TemplateData{})
+// LegacyRoom permanently redirects an old room url to the room's page
+func (m *Repository) LegacyRoom(w http.ResponseWriter, r *http.Request) {
+	slug, ok := legacyRoomSlugs[r.URL.Path]
+	if !ok {
+		http.NotFound(w, r)
+		return
+	}
+
+	http.Redirect(w, r, "/rooms/"+slug, http.StatusMovedPermanently)
 }
 
 func (m *Repository) Availability(w http.ResponseWriter, r *http.Request) {
@@ -231,7 +406,20 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate)
+	if problem := stayDatesProblem(startDate, endDate); problem != "" {
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+problem+".")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	adults, children, err := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid number of guests")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	rooms, err := m.DB.SearchAvailabilityForAllRooms(startDate, endDate, adults, children)
 
 	if err != nil {
 		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
@@ -239,18 +427,81 @@ func (m *Repository) PostAvailability(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	// when no one room sleeps the whole party, offer every free room so the guest can pick several
+	// that sleep everyone between them
+	combined := false
 	if len(rooms) == 0 {
-		m.App.Session.Put(r.Context(), "error", "No availability")
+		rooms, err = m.DB.SearchAvailabilityForAllRooms(startDate, endDate, 0, 0)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		combined = true
+	}
+
+	if len(rooms) == 0 || (combined && !roomsSleep(rooms, adults, children)) {
+		m.App.Session.Put(r.Context(), "error", "No availability. Join the waitlist and we'll email you if a room frees up.")
+		waitlistURL := fmt.Sprintf("/waitlist?start=%s&end=%s&adults=%d&children=%d", sd, ed, adults, children)
+		http.Redirect(w, r, waitlistURL, http.StatusSeeOther)
+		return
+	}
+
+	rules, err := m.DB.StayRulesForDates(startDate, endDate)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get availability for rooms")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	// leave out rooms whose stay rules don't allow these dates, and tell the guest why if none are left
+	var allowed []models.Room
+	var reasons []string
+	for _, room := range rooms {
+		if reason := stayRuleViolation(rules, room.ID, startDate, endDate); reason != "" {
+			reasons = append(reasons, fmt.Sprintf("%s: %s", room.RoomName, reason))
+			continue
+		}
+		allowed = append(allowed, room)
+	}
+
+	if len(allowed) == 0 {
+		m.App.Session.Put(r.Context(), "error", "No availability for these dates. "+strings.Join(reasons, "; "))
 		http.Redirect(w, r, "/search", http.StatusSeeOther)
 		return
 	}
 
+	rooms = allowed
+
+	if combined && !roomsSleep(rooms, adults, children) {
+		m.App.Session.Put(r.Context(), "error", "No availability. Join the waitlist and we'll email you if a room frees up.")
+		waitlistURL := fmt.Sprintf("/waitlist?start=%s&end=%s&adults=%d&children=%d", sd, ed, adults, children)
+		http.Redirect(w, r, waitlistURL, http.StatusSeeOther)
+		return
+	}
+
+	// total price of the stay in each room, by room id
+	prices := make(map[int]int)
+	for _, room := range rooms {
+		price, err := m.DB.GetPriceForStay(room.ID, startDate, endDate)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't get prices for rooms")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+		prices[room.ID] = price.Total
+	}
+
 	data := make(map[string]interface{})
 	data["rooms"] = rooms
+	data["prices"] = prices
+	data["combined"] = combined
 
 	res := models.Reservation{
 		StartDate: startDate,
 		EndDate:   endDate,
+		Adults:    adults,
+		Children:  children,
 	}
 
 	m.App.Session.Put(r.Context(), "reservation", res)
@@ -266,6 +517,89 @@ type jsonResponse struct {
 	RoomID    string `json:"room_id"`
 	StartDate string `json:"start_date"`
 	EndDate   string `json:"end_date"`
+	Adults    int    `json:"adults"`
+	Children  int    `json:"children"`
+}
+
+// stayRuleViolation returns why a stay in roomID from start to end breaks one of rules, or an
+// empty string if the stay is allowed
+func stayRuleViolation(rules []models.StayRule, roomID int, start, end time.Time) string {
+	for _, rule := range rules {
+		if rule.RoomID != roomID {
+			continue
+		}
+
+		if reason := rule.Violation(start, end); reason != "" {
+			return reason
+		}
+	}
+
+	return ""
+}
+
+// roomsSleep reports whether rooms can sleep the party between them, with at least one adult in each room used.
+// It tries the biggest rooms, as many as there are adults
+func roomsSleep(rooms []models.Room, adults, children int) bool {
+	biggest := append([]models.Room(nil), rooms...)
+	sort.Slice(biggest, func(i, j int) bool {
+		return biggest[i].MaxAdults+biggest[i].MaxChildren > biggest[j].MaxAdults+biggest[j].MaxChildren
+	})
+
+	lines := make([]models.Reservation, 0, len(biggest))
+	for _, room := range biggest {
+		if len(lines) == adults {
+			break
+		}
+		lines = append(lines, models.Reservation{Room: room})
+	}
+
+	return shareParty(lines, adults, children)
+}
+
+// parseGuests reads the number of adults and children from a search form. An empty
+// adults field means one adult and an empty children field means none.
+func parseGuests(a, c string) (int, int, error) {
+	adults, children := 1, 0
+
+	var err error
+	if a != "" {
+		adults, err = strconv.Atoi(a)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if c != "" {
+		children, err = strconv.Atoi(c)
+		if err != nil {
+			return 0, 0, err
+		}
+	}
+
+	if adults < 1 || children < 0 {
+		return 0, 0, errors.New("a reservation needs at least one adult")
+	}
+
+	return adults, children, nil
+}
+
+// maxStayNights is the longest stay guests can book online. Staff can book longer stays from the admin
+const maxStayNights = 30
+
+// stayDatesProblem says why guests can't book a stay from start to end online, or returns "" if they can
+func stayDatesProblem(start, end time.Time) string {
+	today := time.Now().UTC().Truncate(24 * time.Hour)
+
+	switch {
+	case !end.After(start):
+		return "departure must be after arrival"
+	case start.Before(today):
+		return "arrival can't be in the past"
+	case end.Sub(start) > maxStayNights*24*time.Hour:
+		return fmt.Sprintf("stays can be at most %d nights", maxStayNights)
+	}
+
+	return ""
 }
 
 func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
@@ -294,6 +628,19 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
 
+	adults, children, err := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if err != nil {
+		resp := jsonResponse{
+			OK:      false,
+			Message: "Invalid number of guests",
+		}
+
+		out, _ := json.MarshalIndent(resp, "", "     ")
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(out)
+		return
+	}
+
 	isAvailable, err := m.DB.HasAvailabilityByDatesByRoomID(startDate, endDate, roomID)
 	if err != nil {
 		resp := jsonResponse{
@@ -308,12 +655,58 @@ func (m *Repository) AvailabilityJSON(w http.ResponseWriter, r *http.Request) {
 
 	}
 
+	message := ""
+	if isAvailable {
+		room, err := m.DB.GetRoomByID(roomID)
+		if err != nil {
+			resp := jsonResponse{
+				OK:      false,
+				Message: "Error querying database",
+			}
+
+			out, _ := json.MarshalIndent(resp, "", "     ")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(out)
+			return
+		}
+
+		if room.Archived == 1 {
+			isAvailable = false
+			message = "This room can no longer be booked"
+		} else if !room.Sleeps(adults, children) {
+			isAvailable = false
+			message = fmt.Sprintf("This room sleeps up to %d adults and %d children", room.MaxAdults, room.MaxChildren)
+		}
+	}
+
+	if isAvailable {
+		rules, err := m.DB.StayRulesForDates(startDate, endDate)
+		if err != nil {
+			resp := jsonResponse{
+				OK:      false,
+				Message: "Error querying database",
+			}
+
+			out, _ := json.MarshalIndent(resp, "", "     ")
+			w.Header().Set("Content-Type", "application/json")
+			w.Write(out)
+			return
+		}
+
+		if reason := stayRuleViolation(rules, roomID, startDate, endDate); reason != "" {
+			isAvailable = false
+			message = "Sorry, " + reason
+		}
+	}
+
 	resp := jsonResponse{
 		OK:        isAvailable,
-		Message:   "",
+		Message:   message,
 		StartDate: sd,
 		EndDate:   ed,
 		RoomID:    strconv.Itoa(roomID),
+		Adults:    adults,
+		Children:  children,
 	}
 
 	out, _ := json.MarshalIndent(resp, "", "     ")
@@ -351,427 +744,4451 @@ func (m *Repository) ReservationSummary(w http.ResponseWriter, r *http.Request)
 	})
 }
 
-// ChooseRoom grabs roomID from URL and adds it to reservation session and redirects to make reservation
-func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
-	exploded := strings.Split(r.RequestURI, "/")
-	roomID, err := strconv.Atoi(exploded[2])
+// PostChooseRooms puts the rooms picked on the choose-room page in the cart and shows the group checkout
+func (m *Repository) PostChooseRooms(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		m.App.Session.Put(r.Context(), "error", "can't parse form")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
-	if !ok {
-		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+	var cart []int
+	seen := make(map[int]bool)
+	for _, v := range r.Form["room_id"] {
+		roomID, err := strconv.Atoi(v)
+		if err != nil || seen[roomID] {
+			m.App.Session.Put(r.Context(), "error", "invalid room")
+			http.Redirect(w, r, "/search", http.StatusSeeOther)
+			return
+		}
+		seen[roomID] = true
+		cart = append(cart, roomID)
+	}
+
+	switch len(cart) {
+	case 0:
+		m.App.Session.Put(r.Context(), "error", "Please choose at least one room")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+	case 1:
+		m.chooseRoom(w, r, cart[0])
+	default:
+		m.App.Session.Put(r.Context(), "cart", cart)
+		http.Redirect(w, r, "/make-group-reservation", http.StatusSeeOther)
+	}
+}
+
+// RemoveFromCart takes a room out of the group checkout, and books the last room on its own
+func (m *Repository) RemoveFromCart(w http.ResponseWriter, r *http.Request) {
+	roomID, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
 		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	res.RoomID = roomID
-
-	m.App.Session.Put(r.Context(), "reservation", res)
+	cart, _ := m.App.Session.Get(r.Context(), "cart").([]int)
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	var remaining []int
+	for _, id := range cart {
+		if id != roomID {
+			remaining = append(remaining, id)
+		}
+	}
 
+	switch len(remaining) {
+	case 0:
+		m.App.Session.Remove(r.Context(), "cart")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+	case 1:
+		m.App.Session.Remove(r.Context(), "cart")
+		m.chooseRoom(w, r, remaining[0])
+	default:
+		m.App.Session.Put(r.Context(), "cart", remaining)
+		http.Redirect(w, r, "/make-group-reservation", http.StatusSeeOther)
+	}
 }
 
-// BookRoom takes URL params, builds sessional variable and sets reservation session var and redirects to make reservation
-func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
-	roomID, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	sd := r.URL.Query().Get("s")
-	ed := r.URL.Query().Get("e")
-
-	layout := "2006-01-02"
+// cartReservations builds one reservation per room in the cart for the dates in res, priced for the stay. The
+// guests still have to be shared out across them with shareParty
+func (m *Repository) cartReservations(res models.Reservation, cart []int) ([]models.Reservation, int, error) {
+	var lines []models.Reservation
+	total := 0
 
-	startDate, _ := time.Parse(layout, sd)
-	endDate, _ := time.Parse(layout, ed)
+	for _, roomID := range cart {
+		room, err := m.DB.GetRoomByID(roomID)
+		if err != nil {
+			return nil, 0, err
+		}
 
-	var res models.Reservation
+		price, err := m.DB.GetPriceForStay(roomID, res.StartDate, res.EndDate)
+		if err != nil {
+			return nil, 0, err
+		}
 
-	res.RoomID = roomID
+		line := res
+		line.RoomID = roomID
+		line.Room = room
+		line.TotalPrice = price.Total
 
-	room, err := m.DB.GetRoomByID(res.RoomID)
-	if err != nil {
-		m.App.Session.Put(r.Context(), "error", "Can't get room from db!")
-		http.Redirect(w, r, "/", http.StatusSeeOther)
-		return
+		lines = append(lines, line)
+		total += price.Total
 	}
 
-	res.Room.RoomName = room.RoomName
-	res.StartDate = startDate
-	res.EndDate = endDate
+	return lines, total, nil
+}
 
-	m.App.Session.Put(r.Context(), "reservation", res)
+// shareParty shares the adults and children out across lines, putting an adult in every room and then
+// filling the rooms in order. It reports whether everyone fits
+func shareParty(lines []models.Reservation, adults, children int) bool {
+	if adults < len(lines) {
+		return false
+	}
 
-	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+	for i := range lines {
+		if lines[i].Room.MaxAdults < 1 {
+			return false
+		}
+		lines[i].Adults = 1
+		lines[i].Children = 0
+	}
+	adults -= len(lines)
+
+	for i := range lines {
+		n := lines[i].Room.MaxAdults - lines[i].Adults
+		if n > adults {
+			n = adults
+		}
+		lines[i].Adults += n
+		adults -= n
+	}
+
+	for i := range lines {
+		n := lines[i].Room.MaxAdults + lines[i].Room.MaxChildren - lines[i].Adults
+		if n > children {
+			n = children
+		}
+		lines[i].Children = n
+		children -= n
+	}
+
+	return adults == 0 && children == 0
 }
 
-// ShowLogin renders the login page
-func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-		Form: forms.New(nil),
+// partyTooBigMessage is shown when the rooms in the cart can't sleep the party between them
+const partyTooBigMessage = "Sorry, these rooms can't sleep your party between them, with an adult in each room. Please search again."
+
+// GroupReservation shows the guest form for booking every room in the cart
+func (m *Repository) GroupReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	cart, ok := m.App.Session.Get(r.Context(), "cart").([]int)
+	if !ok || len(cart) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please choose at least one room")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	lines, total, err := m.cartReservations(res, cart)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't get rooms in your booking")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !shareParty(lines, res.Adults, res.Children) {
+		m.App.Session.Remove(r.Context(), "cart")
+		m.App.Session.Put(r.Context(), "error", partyTooBigMessage)
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+	stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+	// guests with an account don't have to type in their details again
+	var group models.ReservationGroup
+	if g, ok := m.loggedInGuest(r); ok {
+		group.FirstName = g.FirstName
+		group.LastName = g.LastName
+		group.Email = g.Email
+		group.Phone = g.Phone
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = lines
+	data["group"] = group
+
+	intMap := make(map[string]int)
+	intMap["total"] = total
+
+	render.Template(w, r, "make-group-reservation.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		Data:      data,
+		StringMap: stringMap,
+		IntMap:    intMap,
 	})
 }
 
-// PostShowLogin renders the login page
-func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.RenewToken(r.Context())
+// PostGroupReservation books every room in the cart under one reservation group
+func (m *Repository) PostGroupReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "can't get from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	cart, ok := m.App.Session.Get(r.Context(), "cart").([]int)
+	if !ok || len(cart) == 0 {
+		m.App.Session.Put(r.Context(), "error", "Please choose at least one room")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
 	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	group := models.ReservationGroup{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		Phone:     r.Form.Get("phone"),
+	}
+
+	res.FirstName = group.FirstName
+	res.LastName = group.LastName
+	res.Email = group.Email
+	res.Phone = group.Phone
+	res.GuestID = m.App.Session.GetInt(r.Context(), "guest_id")
 
+	// price every room again so the totals stored are what the rates are at booking time
+	lines, total, err := m.cartReservations(res, cart)
 	if err != nil {
-		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "can't get rooms in your booking")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if !shareParty(lines, res.Adults, res.Children) {
+		m.App.Session.Remove(r.Context(), "cart")
+		m.App.Session.Put(r.Context(), "error", partyTooBigMessage)
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
 		return
 	}
+
+	for _, line := range lines {
+		problem, err := m.reservationProblem(line)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't get rooms in your booking")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		if problem != "" {
+			m.App.Session.Remove(r.Context(), "cart")
+			m.App.Session.Put(r.Context(), "error", "Sorry, "+problem+". Please search again.")
+			http.Redirect(w, r, "/search", http.StatusSeeOther)
+			return
+		}
+	}
+
 	form := forms.New(r.PostForm)
-	form.Required("email", "password")
+
+	form.Required("first_name", "last_name", "email")
+	form.MinLength("first_name", 3)
 	form.IsEmail("email")
 
 	if !form.Valid() {
-		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
-			Form: form,
+		stringMap := make(map[string]string)
+		stringMap["start_date"] = res.StartDate.Format("2006-01-02")
+		stringMap["end_date"] = res.EndDate.Format("2006-01-02")
+
+		data := make(map[string]interface{})
+		data["reservations"] = lines
+		data["group"] = group
+
+		intMap := make(map[string]int)
+		intMap["total"] = total
+
+		render.Template(w, r, "make-group-reservation.page.tmpl", &models.TemplateData{
+			Form:      form,
+			Data:      data,
+			StringMap: stringMap,
+			IntMap:    intMap,
 		})
 		return
 	}
 
-	id, _, err := m.DB.Authenticate(r.Form.Get("email"), r.Form.Get("password"))
+	// the cart isn't held, so any of its rooms may have gone since the guest picked them
+	for _, line := range lines {
+		available, err := m.DB.HasAvailabilityByDatesByRoomID(line.StartDate, line.EndDate, line.RoomID)
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't check availability")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+
+		if !available {
+			m.App.Session.Remove(r.Context(), "cart")
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Sorry, %s has just been taken for those dates. Please search again.", line.Room.RoomName))
+			http.Redirect(w, r, "/search", http.StatusSeeOther)
+			return
+		}
+	}
+
+	for i := range lines {
+		lines[i].ConfirmationCode, err = helpers.NewConfirmationCode()
+		if err != nil {
+			m.App.Session.Put(r.Context(), "error", "can't create confirmation code")
+			http.Redirect(w, r, "/", http.StatusSeeOther)
+			return
+		}
+	}
+
+	group.TotalPrice = total
+	group.Reservations = lines
+
+	group.ID, err = m.DB.BookRooms(group)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Remove(r.Context(), "cart")
+		m.App.Session.Remove(r.Context(), "reservation")
+		m.App.Session.Put(r.Context(), "error", "Sorry, someone just took one of these rooms for those dates. Please search again.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
 
 	if err != nil {
-		m.App.ErrorLog.Println(err)
-		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
-		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		m.App.Session.Put(r.Context(), "error", "can't insert new reservation")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
-	m.App.Session.Put(r.Context(), "user_id", id)
-	m.App.Session.Put(r.Context(), "flash", "Logged in!")
-	http.Redirect(w, r, "/", http.StatusSeeOther)
-}
+	var rooms strings.Builder
+	for _, line := range lines {
+		fmt.Fprintf(&rooms, `
+	%s &mdash; %s, confirmation code <strong>%s</strong>
+	(<a href="%s/reservations/%s">view</a>, <a href="%s%s">cancel</a>)<br>`,
+			line.Room.RoomName,
+			render.FormatPrice(line.TotalPrice),
+			line.ConfirmationCode,
+			m.App.BaseURL,
+			line.ConfirmationCode,
+			m.App.BaseURL,
+			cancelURL(line.ConfirmationCode))
+	}
 
-// Logout logs the user out by destroying session
-func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
-	_ = m.App.Session.Destroy(r.Context())
-	_ = m.App.Session.RenewToken(r.Context())
+	htmlMessage := fmt.Sprintf(`
+	<strong>Reservation Confirmation</strong><br>
+	Dear %s, <br>
+	This is to confirm your reservation of %d rooms from %s to %s:<br>
+	%s
+	The total for your stay is %s.`,
+		group.FirstName,
+		len(lines),
+		res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"),
+		rooms.String(),
+		render.FormatPrice(group.TotalPrice))
 
-	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
-}
+	msg := models.MailData{
+		To:       group.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Confirmation",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
 
-func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
-	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{})
+	m.App.MailChan <- msg
+
+	htmlMessage = fmt.Sprintf(`
+	<strong>Reservation Confirmation</strong><br>
+	A group reservation (#%d) of %d rooms from %s to %s for %s %s:<br>
+	%s`,
+		group.ID,
+		len(lines),
+		res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"),
+		group.FirstName,
+		group.LastName,
+		rooms.String())
+
+	msg = models.MailData{
+		To:       "me@here.com",
+		From:     "me@here.com",
+		Subject:  "Reservation Notification",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	m.App.MailChan <- msg
+
+	m.App.Session.Remove(r.Context(), "cart")
+	m.App.Session.Remove(r.Context(), "reservation")
+	m.App.Session.Put(r.Context(), "group", group)
+	http.Redirect(w, r, "/group-reservation-summary", http.StatusSeeOther)
 }
 
-// AdminNewReservations shows all new reservations in admin
-func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllNewReservations()
-	if err != nil {
-		helpers.ServerError(w, err)
+// GroupReservationSummary shows the rooms booked in a group checkout
+func (m *Repository) GroupReservationSummary(w http.ResponseWriter, r *http.Request) {
+	group, ok := m.App.Session.Get(r.Context(), "group").(models.ReservationGroup)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
 		return
 	}
 
+	m.App.Session.Remove(r.Context(), "group")
+
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["group"] = group
 
-	render.Template(w, r, "admin-new-reservations.page.tmpl", &models.TemplateData{
+	render.Template(w, r, "group-reservation-summary.page.tmpl", &models.TemplateData{
 		Data: data,
 	})
 }
 
-// AdminReservations shows all reservations in admin
-func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
-	reservations, err := m.DB.AllReservations()
+// waitlistOfferTTL is how long a guest has to use the booking link sent when a room frees up
+const waitlistOfferTTL = 24 * time.Hour
+
+// Waitlist shows the form guests use to join the waitlist for their dates
+func (m *Repository) Waitlist(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
 	data := make(map[string]interface{})
-	data["reservations"] = reservations
+	data["rooms"] = rooms
 
-	render.Template(w, r, "admin-all-reservations.page.tmpl", &models.TemplateData{
+	render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+		Form: forms.New(r.URL.Query()),
 		Data: data,
 	})
 }
 
-func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
-	explodedUrl := strings.Split(r.RequestURI, "/")
-	src := explodedUrl[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
+// PostWaitlist adds the guest to the waitlist
+func (m *Repository) PostWaitlist(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
 
-	id, err := strconv.Atoi(r.URL.Query().Get("id"))
-	year := r.URL.Query().Get("y")
-	month := r.URL.Query().Get("m")
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "start", "end")
+	form.IsEmail("email")
 
-	stringMap["year"] = year
-	stringMap["month"] = month
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
 
+	endDate, err := time.Parse(layout, r.Form.Get("end"))
 	if err != nil {
-		helpers.ServerError(w, err)
+		form.Errors.Add("end", "Invalid date")
+	}
+
+	if !endDate.After(startDate) {
+		form.Errors.Add("end", "Departure must be after arrival")
+	}
+
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+
+	adults, children, err := parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if err != nil {
+		form.Errors.Add("adults", "Invalid number of guests")
+	}
+
+	if !form.Valid() {
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["rooms"] = rooms
+
+		render.Template(w, r, "waitlist.page.tmpl", &models.TemplateData{
+			Form: form,
+			Data: data,
+		})
 		return
 	}
 
-	res, err := m.DB.GetReservationByID(id)
+	entry := models.WaitlistEntry{
+		FirstName: r.Form.Get("first_name"),
+		LastName:  r.Form.Get("last_name"),
+		Email:     r.Form.Get("email"),
+		StartDate: startDate,
+		EndDate:   endDate,
+		RoomID:    roomID,
+		Adults:    adults,
+		Children:  children,
+	}
+
+	_, err = m.DB.InsertWaitlistEntry(entry)
 	if err != nil {
-		helpers.ServerError(w, err)
+		m.App.Session.Put(r.Context(), "error", "can't add you to the waitlist")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "You're on the waitlist. We'll email you if a room frees up for your dates.")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// waitlistBookURL returns the signed, time-limited link that lets a waitlisted guest book roomID
+func waitlistBookURL(entryID, roomID int, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	sig := helpers.SignValue(fmt.Sprintf("waitlist:%d:%d:%s", entryID, roomID, exp))
+
+	return fmt.Sprintf("/waitlist/%d/book?room=%d&exp=%s&sig=%s", entryID, roomID, exp, url.QueryEscape(sig))
+}
+
+// notifyWaitlist offers roomID to the first guest on the waitlist, oldest first, whose whole stay
+// now fits after the room was freed between start and end, and who could book it
+func (m *Repository) notifyWaitlist(roomID int, start, end time.Time) {
+	entries, err := m.DB.WaitlistEntriesForDates(roomID, start, end)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	for _, e := range entries {
+		available, err := m.DB.HasAvailabilityByDatesByRoomID(e.StartDate, e.EndDate, roomID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			return
+		}
+
+		if !available {
+			continue
+		}
+
+		// an offer the guest can't take up, say for a room too small for their party, would only
+		// keep the room from the next guest in line
+		problem, err := m.reservationProblem(models.Reservation{
+			RoomID:    roomID,
+			StartDate: e.StartDate,
+			EndDate:   e.EndDate,
+			Adults:    e.Adults,
+			Children:  e.Children,
+		})
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			return
+		}
+
+		if problem != "" {
+			continue
+		}
+
+		expires := time.Now().Add(waitlistOfferTTL)
+
+		htmlMessage := fmt.Sprintf(`
+	<strong>A room is available</strong><br>
+	Dear %s, <br>
+	A room has become available from %s to %s.<br>
+	<a href="%s%s">Book it now</a>. This link expires on %s.`,
+			e.FirstName,
+			e.StartDate.Format("2006-01-02"),
+			e.EndDate.Format("2006-01-02"),
+			m.App.BaseURL,
+			waitlistBookURL(e.ID, roomID, expires),
+			expires.Format("2006-01-02 15:04"))
+
+		m.App.MailChan <- models.MailData{
+			To:       e.Email,
+			From:     "me@here.com",
+			Subject:  "A room is available for your dates",
+			Content:  htmlMessage,
+			Template: "basic.html",
+		}
+
+		err = m.DB.MarkWaitlistEntryNotified(e.ID, roomID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+
 		return
 	}
+}
+
+// PassOnLapsedWaitlistOffers offers each room whose waitlist offer ran out unused to the next guest in line
+func (m *Repository) PassOnLapsedWaitlistOffers() {
+	entries, err := m.DB.LapsedWaitlistOffers(time.Now().Add(-waitlistOfferTTL))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	for _, e := range entries {
+		err := m.DB.CloseWaitlistOffer(e.ID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+			continue
+		}
+
+		// if the guest booked the room after all it won't be free, and no one else is offered it
+		m.notifyWaitlist(e.OfferedRoomID, e.StartDate, e.EndDate)
+	}
+}
+
+// WaitlistBook checks the link sent to a waitlisted guest and starts a reservation for them
+func (m *Repository) WaitlistBook(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(r.URL.Query().Get("room"))
+	exp := r.URL.Query().Get("exp")
+	sig := r.URL.Query().Get("sig")
+
+	if !helpers.ValidSignature(fmt.Sprintf("waitlist:%d:%d:%s", id, roomID, exp), sig) {
+		m.App.Session.Put(r.Context(), "error", "Invalid booking link")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	expires, _ := strconv.ParseInt(exp, 10, 64)
+	if time.Now().Unix() > expires {
+		m.App.Session.Put(r.Context(), "error", "This booking link has expired")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	entry, err := m.DB.GetWaitlistEntryByID(id)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid booking link")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	available, err := m.DB.HasAvailabilityByDatesByRoomID(entry.StartDate, entry.EndDate, roomID)
+	if err != nil || !available {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has already been booked")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	res := models.Reservation{
+		FirstName: entry.FirstName,
+		LastName:  entry.LastName,
+		Email:     entry.Email,
+		StartDate: entry.StartDate,
+		EndDate:   entry.EndDate,
+		RoomID:    roomID,
+		Adults:    entry.Adults,
+		Children:  entry.Children,
+	}
+
+	problem, err := m.reservationProblem(res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if problem != "" {
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+problem+". Please search for another room.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	res, err = m.holdRoom(r.Context(), res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Sorry, this room has already been booked")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// ReservationLookup shows the form guests use to find their reservation
+func (m *Repository) ReservationLookup(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["code"] = r.URL.Query().Get("code")
+
+	render.Template(w, r, "reservation-lookup.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	})
+}
+
+// PostReservationLookup checks the confirmation code and email and shows the reservation
+func (m *Repository) PostReservationLookup(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form")
+		http.Redirect(w, r, "/reservations/lookup", http.StatusSeeOther)
+		return
+	}
+
+	code := strings.ToUpper(strings.TrimSpace(r.Form.Get("code")))
+	email := strings.TrimSpace(r.Form.Get("email"))
+
+	form := forms.New(r.PostForm)
+	form.Required("code", "email")
+	form.IsEmail("email")
+
+	stringMap := make(map[string]string)
+	stringMap["code"] = code
+
+	if !form.Valid() {
+		render.Template(w, r, "reservation-lookup.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	res, err := m.DB.GetReservationByCode(code)
+	if err != nil || !strings.EqualFold(res.Email, email) {
+		m.App.InfoLog.Println("reservation lookup failed for code", code)
+		m.App.Session.Put(r.Context(), "error", "We couldn't find a reservation with that code and email")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/lookup?code=%s", url.QueryEscape(code)), http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "lookup_code", code)
+	m.App.Session.Put(r.Context(), "lookup_email", email)
+
+	http.Redirect(w, r, fmt.Sprintf("/reservations/%s", code), http.StatusSeeOther)
+}
+
+// GuestReservation shows a reservation to a guest who has looked it up with code and email
+func (m *Repository) GuestReservation(w http.ResponseWriter, r *http.Request) {
+	code := strings.ToUpper(chi.URLParam(r, "code"))
+
+	lookedUp := m.App.Session.GetString(r.Context(), "lookup_code") == code
+	guestID := m.App.Session.GetInt(r.Context(), "guest_id")
+
+	if !lookedUp && guestID == 0 {
+		http.Redirect(w, r, fmt.Sprintf("/reservations/lookup?code=%s", url.QueryEscape(code)), http.StatusSeeOther)
+		return
+	}
+
+	// guests can see the reservations booked from their account without looking them up
+	res, err := m.DB.GetReservationByCode(code)
+	ownReservation := guestID > 0 && res.GuestID == guestID
+	if err != nil || !(ownReservation || lookedUp && strings.EqualFold(res.Email, m.App.Session.GetString(r.Context(), "lookup_email"))) {
+		m.App.Session.Remove(r.Context(), "lookup_code")
+		m.App.Session.Put(r.Context(), "error", "We couldn't find that reservation")
+		http.Redirect(w, r, "/reservations/lookup", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+
+	stringMap := make(map[string]string)
+	stringMap["cancel_url"] = cancelURL(res.ConfirmationCode)
+
+	render.Template(w, r, "guest-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+	})
+}
+
+// cancelURL returns the signed path a guest uses to cancel the reservation with code
+func cancelURL(code string) string {
+	return fmt.Sprintf("/reservations/%s/cancel?sig=%s", code, helpers.SignValue("cancel:"+code))
+}
+
+// reservationForCancellation loads the reservation for a signed cancellation link,
+// returning false if the link is invalid or the reservation can no longer be cancelled
+func (m *Repository) reservationForCancellation(w http.ResponseWriter, r *http.Request) (models.Reservation, bool) {
+	code := strings.ToUpper(chi.URLParam(r, "code"))
+
+	if !helpers.ValidSignature("cancel:"+code, r.URL.Query().Get("sig")) {
+		m.App.Session.Put(r.Context(), "error", "That cancellation link is not valid")
+		http.Redirect(w, r, "/reservations/lookup", http.StatusSeeOther)
+		return models.Reservation{}, false
+	}
+
+	res, err := m.DB.GetReservationByCode(code)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "We couldn't find that reservation")
+		http.Redirect(w, r, "/reservations/lookup", http.StatusSeeOther)
+		return res, false
+	}
+
+	if res.Cancelled == 1 {
+		m.App.Session.Put(r.Context(), "warning", "This reservation has already been cancelled")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/lookup?code=%s", code), http.StatusSeeOther)
+		return res, false
+	}
+
+	if !res.StartDate.After(time.Now()) {
+		m.App.Session.Put(r.Context(), "error", "This stay has already started and can't be cancelled online. Please contact us.")
+		http.Redirect(w, r, fmt.Sprintf("/reservations/lookup?code=%s", code), http.StatusSeeOther)
+		return res, false
+	}
+
+	return res, true
+}
+
+// GuestCancelReservation shows the cancellation policy and asks the guest to confirm
+func (m *Repository) GuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationForCancellation(w, r)
+	if !ok {
+		return
+	}
+
+	policy, err := m.DB.GetCancellationPolicyForRoom(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["policy"] = policy
+
+	intMap := make(map[string]int)
+	intMap["refund_percent"] = policy.RefundPercent(res.StartDate, time.Now())
+
+	stringMap := make(map[string]string)
+	stringMap["cancel_url"] = cancelURL(res.ConfirmationCode)
+
+	render.Template(w, r, "guest-cancel-reservation.page.tmpl", &models.TemplateData{
+		Data:      data,
+		IntMap:    intMap,
+		StringMap: stringMap,
+		Form:      forms.New(nil),
+	})
+}
+
+// PostGuestCancelReservation cancels a reservation from a signed link and notifies guest and owner
+func (m *Repository) PostGuestCancelReservation(w http.ResponseWriter, r *http.Request) {
+	res, ok := m.reservationForCancellation(w, r)
+	if !ok {
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	policy, err := m.DB.GetCancellationPolicyForRoom(res.RoomID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	refund := policy.RefundPercent(res.StartDate, time.Now())
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+
+	err = m.DB.CancelReservation(res.ID, reason, refund)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.notifyWaitlist(res.RoomID, res.StartDate, res.EndDate)
+
+	htmlMessage := fmt.Sprintf(`
+	<strong>Reservation Cancelled</strong><br>
+	Dear %s, <br>
+	Your reservation %s from %s to %s, for %s, has been cancelled.<br>
+	Under the %s policy you will be refunded %d%% of your booking.`,
+		res.FirstName,
+		res.ConfirmationCode,
+		res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName,
+		policy.Name,
+		refund)
+
+	m.App.MailChan <- models.MailData{
+		To:       res.Email,
+		From:     "me@here.com",
+		Subject:  "Reservation Cancelled",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	htmlMessage = fmt.Sprintf(`
+	<strong>Reservation Cancelled</strong><br>
+	%s %s cancelled reservation %s from %s to %s, for %s.<br>
+	Refund due: %d%%<br>
+	Reason: %s`,
+		res.FirstName,
+		res.LastName,
+		res.ConfirmationCode,
+		res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName,
+		refund,
+		template.HTMLEscapeString(reason))
+
+	m.App.MailChan <- models.MailData{
+		To:       "me@here.com",
+		From:     "me@here.com",
+		Subject:  "Reservation Cancellation",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your reservation has been cancelled")
+	http.Redirect(w, r, fmt.Sprintf("/reservations/%s", res.ConfirmationCode), http.StatusSeeOther)
+}
+
+// localPath returns next if it is a path on this site, and so safe to redirect to, or fallback if not
+func localPath(next, fallback string) string {
+	if !strings.HasPrefix(next, "/") || strings.HasPrefix(next, "//") || strings.HasPrefix(next, "/\\") {
+		return fallback
+	}
+
+	return next
+}
+
+// loggedInGuest returns the profile of the guest logged in to their account, if there is one
+func (m *Repository) loggedInGuest(r *http.Request) (models.Guest, bool) {
+	id := m.App.Session.GetInt(r.Context(), "guest_id")
+	if id == 0 {
+		return models.Guest{}, false
+	}
+
+	g, err := m.DB.GetGuestByID(id)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return g, false
+	}
+
+	return g, true
+}
+
+// logInGuest starts a guest's session on their account
+func (m *Repository) logInGuest(r *http.Request, id int) {
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Put(r.Context(), "guest_id", id)
+}
+
+// GuestRegister shows the form for a guest to open an account
+func (m *Repository) GuestRegister(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["next"] = localPath(r.URL.Query().Get("next"), "")
+
+	render.Template(w, r, "guest-register.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	})
+}
+
+// PostGuestRegister opens a guest account and logs the guest in to it
+func (m *Repository) PostGuestRegister(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	next := localPath(r.URL.Query().Get("next"), "")
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "password", "confirm_password")
+	form.IsEmail("email")
+	form.MinLength("password", 8)
+	if r.Form.Get("password") != r.Form.Get("confirm_password") {
+		form.Errors.Add("confirm_password", "Passwords don't match")
+	}
+
+	g := models.Guest{
+		FirstName: strings.TrimSpace(r.Form.Get("first_name")),
+		LastName:  strings.TrimSpace(r.Form.Get("last_name")),
+		Email:     strings.TrimSpace(r.Form.Get("email")),
+		Phone:     strings.TrimSpace(r.Form.Get("phone")),
+	}
+
+	if form.Valid() {
+		g.ID, err = m.DB.InsertGuest(g, r.Form.Get("password"))
+		if errors.Is(err, repository.ErrEmailTaken) {
+			form.Errors.Add("email", "There is already an account with this email, log in instead")
+		}
+	}
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["next"] = next
+
+		render.Template(w, r, "guest-register.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.logInGuest(r, g.ID)
+
+	m.App.Session.Put(r.Context(), "flash", "Welcome! Your account is ready")
+	http.Redirect(w, r, localPath(next, "/account/bookings"), http.StatusSeeOther)
+}
+
+// GuestLogin shows the login form for guest accounts
+func (m *Repository) GuestLogin(w http.ResponseWriter, r *http.Request) {
+	stringMap := make(map[string]string)
+	stringMap["next"] = localPath(r.URL.Query().Get("next"), "")
+
+	render.Template(w, r, "guest-login.page.tmpl", &models.TemplateData{
+		Form:      forms.New(nil),
+		StringMap: stringMap,
+	})
+}
+
+// PostGuestLogin logs a guest in to their account
+func (m *Repository) PostGuestLogin(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	next := localPath(r.URL.Query().Get("next"), "")
+
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["next"] = next
+
+		render.Template(w, r, "guest-login.page.tmpl", &models.TemplateData{
+			Form:      form,
+			StringMap: stringMap,
+		})
+		return
+	}
+
+	id, err := m.DB.AuthenticateGuest(strings.TrimSpace(r.Form.Get("email")), r.Form.Get("password"))
+	if err != nil {
+		m.App.InfoLog.Println("guest login failed:", err)
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/account/login?next="+url.QueryEscape(next), http.StatusSeeOther)
+		return
+	}
+
+	m.logInGuest(r, id)
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in!")
+	http.Redirect(w, r, localPath(next, "/account/bookings"), http.StatusSeeOther)
+}
+
+// GuestLogout logs a guest out of their account
+func (m *Repository) GuestLogout(w http.ResponseWriter, r *http.Request) {
+	m.App.Session.Remove(r.Context(), "guest_id")
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// GuestBookings lists the upcoming and past stays booked from the logged in guest's account
+func (m *Repository) GuestBookings(w http.ResponseWriter, r *http.Request) {
+	g, ok := m.loggedInGuest(r)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Log in to see your bookings")
+		http.Redirect(w, r, "/account/login?next=/account/bookings", http.StatusSeeOther)
+		return
+	}
+
+	reservations, err := m.DB.GuestReservations(g.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	// stays still to come or under way are upcoming, cancelled ones are kept with the past
+	var upcoming, past []models.Reservation
+	cancelURLs := make(map[string]string)
+	for _, res := range reservations {
+		if res.Cancelled == 0 && res.EndDate.After(today) {
+			upcoming = append(upcoming, res)
+			if res.StartDate.After(now) {
+				cancelURLs[res.ConfirmationCode] = cancelURL(res.ConfirmationCode)
+			}
+		} else {
+			past = append(past, res)
+		}
+	}
+
+	// upcoming stays read soonest first
+	sort.Slice(upcoming, func(i, j int) bool {
+		return upcoming[i].StartDate.Before(upcoming[j].StartDate)
+	})
+
+	data := make(map[string]interface{})
+	data["guest"] = g
+	data["upcoming"] = upcoming
+	data["past"] = past
+
+	render.Template(w, r, "guest-bookings.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: cancelURLs,
+	})
+}
+
+// ChooseRoom grabs roomID from URL and holds it for the reservation in the session
+func (m *Repository) ChooseRoom(w http.ResponseWriter, r *http.Request) {
+	exploded := strings.Split(r.RequestURI, "/")
+	roomID, err := strconv.Atoi(exploded[2])
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "missing url parameter")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.chooseRoom(w, r, roomID)
+}
+
+// chooseRoom puts roomID in the reservation session and starts the reservation
+func (m *Repository) chooseRoom(w http.ResponseWriter, r *http.Request, roomID int) {
+	res, ok := m.App.Session.Get(r.Context(), "reservation").(models.Reservation)
+	if !ok {
+		m.App.Session.Put(r.Context(), "error", "Can't get reservation from session")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.RoomID = roomID
+
+	m.startReservation(w, r, res)
+}
+
+// startReservation checks res can be booked, holds its room and redirects to make reservation. Holds are
+// only made from POSTs carrying the csrf token, so other sites can't take rooms off the market by linking here
+func (m *Repository) startReservation(w http.ResponseWriter, r *http.Request, res models.Reservation) {
+	if problem := stayDatesProblem(res.StartDate, res.EndDate); problem != "" {
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+problem+". Please search again.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	problem, err := m.reservationProblem(res)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't find room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	if problem != "" {
+		m.App.Session.Put(r.Context(), "error", "Sorry, "+problem+". Please search again.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	res, err = m.holdRoom(r.Context(), res)
+	if errors.Is(err, repository.ErrRoomUnavailable) {
+		m.App.Session.Put(r.Context(), "error", "Sorry, someone just took this room for those dates. Please search again.")
+		http.Redirect(w, r, "/search", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't hold room")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservation", res)
+
+	http.Redirect(w, r, "/make-reservation", http.StatusSeeOther)
+}
+
+// holdRoom holds res.RoomID for the dates of res while the guest fills in the reservation form,
+// releasing the hold from any reservation the guest had already started
+func (m *Repository) holdRoom(ctx context.Context, res models.Reservation) (models.Reservation, error) {
+	if prev, ok := m.App.Session.Get(ctx, "reservation").(models.Reservation); ok && prev.HoldID > 0 {
+		err := m.DB.ReleaseHold(prev.HoldID)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	res.HoldID = 0
+
+	holdID, err := m.DB.HoldRoom(res.RoomID, res.StartDate, res.EndDate, m.App.HoldTTL)
+	if err != nil {
+		return res, err
+	}
+
+	res.HoldID = holdID
+
+	return res, nil
+}
+
+// BookRoom takes the posted room, dates and guests, builds the reservation session and holds the room
+func (m *Repository) BookRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse form!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	roomID, _ := strconv.Atoi(r.Form.Get("id"))
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("s"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse start date!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	endDate, err := time.Parse(layout, r.Form.Get("e"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "can't parse end date!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	adults, children, err := parseGuests(r.Form.Get("a"), r.Form.Get("c"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "invalid number of guests")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	var res models.Reservation
+
+	res.RoomID = roomID
+	res.Adults = adults
+	res.Children = children
+
+	room, err := m.DB.GetRoomByID(res.RoomID)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Can't get room from db!")
+		http.Redirect(w, r, "/", http.StatusSeeOther)
+		return
+	}
+
+	res.Room.RoomName = room.RoomName
+	res.StartDate = startDate
+	res.EndDate = endDate
+
+	m.startReservation(w, r, res)
+}
+
+// ShowLogin renders the login page
+func (m *Repository) ShowLogin(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostShowLogin renders the login page
+func (m *Repository) PostShowLogin(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.RenewToken(r.Context())
+	err := r.ParseForm()
+
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+	form := forms.New(r.PostForm)
+	form.Required("email", "password")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "login.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	email := r.Form.Get("email")
+
+	wait, err := m.loginWait(r, email)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	if wait > 0 {
+		m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Too many failed logins. Try again in %s", waitText(wait)))
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// only a real account can be locked, an unknown email still counts towards the IP address's failures
+	var account *models.User
+	u, err := m.DB.GetUserByEmail(email)
+	if err == nil {
+		account = &u
+	} else if !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if account != nil && account.Locked() {
+		m.App.Session.Put(r.Context(), "error", lockedMessage)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	id, _, err := m.DB.Authenticate(email, r.Form.Get("password"))
+
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+
+		err = m.loginFailed(r, email, account)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	user, err := m.DB.GetUserByID(id)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		m.App.Session.Put(r.Context(), "error", "Invalid login credentials")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	// users with two-factor login give a code next, unless they've asked this device to remember them
+	if user.TOTPEnabled == 1 && !deviceRemembered(r, user) {
+		m.App.Session.Put(r.Context(), "pending_user_id", user.ID)
+		http.Redirect(w, r, "/user/two-factor", http.StatusSeeOther)
+		return
+	}
+
+	m.logInUser(r, user)
+
+	m.App.Session.Put(r.Context(), "flash", "Logged in!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// logInUser starts a staff session for u once they have proved who they are
+func (m *Repository) logInUser(r *http.Request, u models.User) {
+	_ = m.App.Session.RenewToken(r.Context())
+	m.App.Session.Remove(r.Context(), "pending_user_id")
+	m.App.Session.Put(r.Context(), "user_id", u.ID)
+	m.App.Session.Put(r.Context(), "access_level", int(u.Role()))
+	m.App.Session.Put(r.Context(), "password_stamp", helpers.PasswordStamp(u.Password))
+
+	err := m.DB.ClearLoginFailures(loginKey(u.Email))
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+}
+
+// Staff logins are throttled by account and by IP address. After loginBackoffAfter failures in
+// loginFailureWindow each attempt waits twice as long as the last, and loginLockoutAfter failures
+// lock the account for loginLockoutFor
+const (
+	loginFailureWindow = 15 * time.Minute
+	loginBackoffAfter  = 3
+	loginMaxBackoff    = 5 * time.Minute
+	loginLockoutAfter  = 10
+	loginLockoutFor    = 30 * time.Minute
+)
+
+// lockedMessage is shown to anyone trying to log in to a locked account
+const lockedMessage = "This account is locked after too many failed logins. Try again later, or ask an owner to unlock it"
+
+// loginKey returns the email address failed logins are recorded against, so changing its case doesn't dodge the limit
+func loginKey(email string) string {
+	return strings.ToLower(strings.TrimSpace(email))
+}
+
+// clientIP returns the IP address a request came from. Behind a proxy that's the last address in the
+// configured header, the one the proxy added, as any before it were sent by the client
+func (m *Repository) clientIP(r *http.Request) string {
+	if m.App.ClientIPHeader != "" {
+		addrs := strings.Split(r.Header.Get(m.App.ClientIPHeader), ",")
+		if ip := strings.TrimSpace(addrs[len(addrs)-1]); ip != "" {
+			return ip
+		}
+	}
+
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	return host
+}
+
+// loginBackoff returns how long to wait after the last of a run of failed logins before trying again
+func loginBackoff(failures int) time.Duration {
+	if failures < loginBackoffAfter {
+		return 0
+	}
+
+	doublings := failures - loginBackoffAfter
+	if doublings > 20 {
+		return loginMaxBackoff
+	}
+
+	backoff := time.Second << doublings
+	if backoff > loginMaxBackoff {
+		return loginMaxBackoff
+	}
+
+	return backoff
+}
+
+// waitText returns a wait for people to read, in whole minutes or seconds
+func waitText(d time.Duration) string {
+	if d > time.Minute {
+		return fmt.Sprintf("%d minutes", int(math.Ceil(d.Minutes())))
+	}
+
+	seconds := int(math.Ceil(d.Seconds()))
+	if seconds == 1 {
+		return "1 second"
+	}
+
+	return fmt.Sprintf("%d seconds", seconds)
+}
+
+// loginWait returns how long a login for email from the request's IP address has to wait because of
+// earlier failures by either. It's zero or less when the login can go ahead
+func (m *Repository) loginWait(r *http.Request, email string) (time.Duration, error) {
+	f, err := m.DB.RecentLoginFailures(loginKey(email), m.clientIP(r), time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		return 0, err
+	}
+
+	failures := f.Account
+	if f.IP > failures {
+		failures = f.IP
+	}
+
+	return time.Until(f.Last.Add(loginBackoff(failures))), nil
+}
+
+// loginFailed records a failed login for email, locking account if it has failed too often. account is
+// nil when no user has the email
+func (m *Repository) loginFailed(r *http.Request, email string, account *models.User) error {
+	key, ip := loginKey(email), m.clientIP(r)
+	m.App.InfoLog.Printf("failed login for %s from %s", key, ip)
+
+	err := m.DB.InsertLoginFailure(key, ip)
+	if err != nil {
+		return err
+	}
+
+	// failures older than the window no longer count, so there's no need to keep them
+	err = m.DB.DeleteLoginFailuresBefore(time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		return err
+	}
+
+	if account == nil {
+		return nil
+	}
+
+	f, err := m.DB.RecentLoginFailures(key, ip, time.Now().Add(-loginFailureWindow))
+	if err != nil {
+		return err
+	}
+
+	if f.Account < loginLockoutAfter {
+		return nil
+	}
+
+	account.LockedUntil = time.Now().Add(loginLockoutFor)
+	err = m.DB.LockUser(account.ID, account.LockedUntil)
+	if err != nil {
+		return err
+	}
+
+	m.App.InfoLog.Printf("locked %s after %d failed logins", key, f.Account)
+	m.sendLockoutNotice(*account, f.Account, ip)
+
+	return nil
+}
+
+// sendLockoutNotice tells a user their account was locked, in case it wasn't them trying to log in
+func (m *Repository) sendLockoutNotice(u models.User, failures int, ip string) {
+	htmlMessage := fmt.Sprintf(`
+	<strong>Your account is locked</strong><br>
+	Dear %s, <br>
+	There were %d failed logins to your Fort Smythe Bed and Breakfast admin account, the last from %s,
+	so it is locked until %s.<br>
+	If that wasn't you, someone may be guessing your password.
+	<a href="%s/user/forgot-password">Reset your password</a> once the lock ends, or ask an owner to unlock
+	your account sooner.`,
+		u.FirstName,
+		failures,
+		ip,
+		u.LockedUntil.Format("2006-01-02 15:04"),
+		m.App.BaseURL)
+
+	m.App.MailChan <- models.MailData{
+		To:       u.Email,
+		From:     "me@here.com",
+		Subject:  "Your admin account is locked",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+// totpIssuer names the site in authenticator apps
+const totpIssuer = "Fort Smythe Bed and Breakfast"
+
+// rememberDeviceCookie returns the name of the cookie that lets u skip the two-factor step on a device
+func rememberDeviceCookie(u models.User) string {
+	return fmt.Sprintf("remember_device_%d", u.ID)
+}
+
+// rememberDeviceValue returns the signed value of u's remember device cookie. The signature covers
+// their secret, so turning two-factor login off and on again forgets every device
+func rememberDeviceValue(u models.User, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+
+	return exp + ":" + helpers.SignValue(fmt.Sprintf("device:%d:%s:%s", u.ID, exp, u.TOTPSecret))
+}
+
+// deviceRemembered reports whether the request carries a remember device cookie for u that is still good
+func deviceRemembered(r *http.Request, u models.User) bool {
+	c, err := r.Cookie(rememberDeviceCookie(u))
+	if err != nil {
+		return false
+	}
+
+	exp, sig, _ := strings.Cut(c.Value, ":")
+	expires, _ := strconv.ParseInt(exp, 10, 64)
+	if time.Now().Unix() > expires {
+		return false
+	}
+
+	return helpers.ValidSignature(fmt.Sprintf("device:%d:%s:%s", u.ID, exp, u.TOTPSecret), sig)
+}
+
+// checkSecondFactor reports whether code is a current code from u's authenticator app, or one of
+// their unused recovery codes, and uses it up
+func (m *Repository) checkSecondFactor(u models.User, code string) (ok, recovery bool, err error) {
+	if step, valid := totp.Validate(u.TOTPSecret, code, time.Now()); valid {
+		ok, err = m.DB.UseTOTPStep(u.ID, step)
+		return ok, false, err
+	}
+
+	ok, err = m.DB.UseRecoveryCode(u.ID, helpers.HashToken(totp.NormalizeRecoveryCode(code)))
+	return ok, ok, err
+}
+
+// TwoFactor asks a user who has given their password for a code from their authenticator app
+func (m *Repository) TwoFactor(w http.ResponseWriter, r *http.Request) {
+	if !m.App.Session.Exists(r.Context(), "pending_user_id") {
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	intMap := make(map[string]int)
+	intMap["remember_days"] = int(m.App.RememberDeviceFor.Hours() / 24)
+
+	render.Template(w, r, "two-factor.page.tmpl", &models.TemplateData{
+		Form:   forms.New(nil),
+		IntMap: intMap,
+	})
+}
+
+// PostTwoFactor checks the code and finishes logging the user in
+func (m *Repository) PostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	id := m.App.Session.GetInt(r.Context(), "pending_user_id")
+	if id == 0 {
+		m.App.Session.Put(r.Context(), "error", "Log in first!")
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u, err := m.DB.GetUserByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if u.Locked() {
+		m.App.Session.Remove(r.Context(), "pending_user_id")
+		m.App.Session.Put(r.Context(), "error", lockedMessage)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("code")
+
+	// wrong codes count as failed logins too, so codes can't be guessed any faster than passwords
+	if form.Valid() {
+		wait, err := m.loginWait(r, u.Email)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if wait > 0 {
+			form.Errors.Add("code", fmt.Sprintf("Too many wrong codes. Try again in %s", waitText(wait)))
+		}
+	}
+
+	var recovery bool
+	if form.Valid() {
+		var ok bool
+		ok, recovery, err = m.checkSecondFactor(u, r.Form.Get("code"))
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !ok {
+			err = m.loginFailed(r, u.Email, &u)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+
+			if u.Locked() {
+				m.App.Session.Remove(r.Context(), "pending_user_id")
+				m.App.Session.Put(r.Context(), "error", lockedMessage)
+				http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+				return
+			}
+
+			form.Errors.Add("code", "That code isn't right, please try again")
+		}
+	}
+
+	if !form.Valid() {
+		intMap := make(map[string]int)
+		intMap["remember_days"] = int(m.App.RememberDeviceFor.Hours() / 24)
+
+		render.Template(w, r, "two-factor.page.tmpl", &models.TemplateData{
+			Form:   form,
+			IntMap: intMap,
+		})
+		return
+	}
+
+	m.logInUser(r, u)
+
+	if r.Form.Get("remember") == "1" && m.App.RememberDeviceFor > 0 {
+		expires := time.Now().Add(m.App.RememberDeviceFor)
+		http.SetCookie(w, &http.Cookie{
+			Name:     rememberDeviceCookie(u),
+			Value:    rememberDeviceValue(u, expires),
+			Path:     "/",
+			Expires:  expires,
+			HttpOnly: true,
+			Secure:   m.App.InProduction,
+			SameSite: http.SameSiteLaxMode,
+		})
+	}
+
+	if recovery {
+		m.App.Session.Put(r.Context(), "warning", "You logged in with a recovery code, which can't be used again. Make new codes from Two-Factor Login if you're running low.")
+	}
+	m.App.Session.Put(r.Context(), "flash", "Logged in!")
+	http.Redirect(w, r, "/", http.StatusSeeOther)
+}
+
+// passwordResetTTL is how long a password reset link works for
+const passwordResetTTL = time.Hour
+
+// ForgotPassword shows the form to ask for a password reset link
+func (m *Repository) ForgotPassword(w http.ResponseWriter, r *http.Request) {
+	render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+		Form: forms.New(nil),
+	})
+}
+
+// PostForgotPassword emails a password reset link to the user with the given email. The reply is
+// the same whether or not there is such a user, so the form can't be used to find staff emails
+func (m *Repository) PostForgotPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("email")
+	form.IsEmail("email")
+
+	if !form.Valid() {
+		render.Template(w, r, "forgot-password.page.tmpl", &models.TemplateData{
+			Form: form,
+		})
+		return
+	}
+
+	u, err := m.DB.GetUserByEmail(r.Form.Get("email"))
+	if err != nil && !errors.Is(err, sql.ErrNoRows) {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// invited users set their password from the invite instead
+	if err == nil && u.Active == 1 && !u.Invited() {
+		token, err := helpers.NewToken()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		expires := time.Now().Add(passwordResetTTL)
+		err = m.DB.InsertPasswordResetToken(u.ID, helpers.HashToken(token), expires)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		htmlMessage := fmt.Sprintf(`
+	<strong>Reset your password</strong><br>
+	Dear %s, <br>
+	Someone asked to reset the password for your account. If it was you,
+	<a href="%s/user/reset-password?token=%s">choose a new password</a>. This link expires on %s
+	and can only be used once. If it wasn't you, you can ignore this email.`,
+			u.FirstName,
+			m.App.BaseURL,
+			url.QueryEscape(token),
+			expires.Format("2006-01-02 15:04"))
+
+		m.App.MailChan <- models.MailData{
+			To:       u.Email,
+			From:     "me@here.com",
+			Subject:  "Reset your password",
+			Content:  htmlMessage,
+			Template: "basic.html",
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "If that email belongs to an account, we've sent it a link to reset the password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// ResetPassword shows the form to choose a new password, if the emailed link is still good
+func (m *Repository) ResetPassword(w http.ResponseWriter, r *http.Request) {
+	u, err := m.DB.GetUserByResetToken(helpers.HashToken(r.URL.Query().Get("token")))
+	if errors.Is(err, repository.ErrInvalidToken) {
+		m.App.Session.Put(r.Context(), "error", "This reset link has expired or was already used")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = u
+
+	render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// PostResetPassword sets the new password and uses up the reset link. Any sessions the user had
+// end, since they were started with the old password
+func (m *Repository) PostResetPassword(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	tokenHash := helpers.HashToken(r.URL.Query().Get("token"))
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "confirm_password")
+	form.MinLength("password", 8)
+	if r.Form.Get("password") != r.Form.Get("confirm_password") {
+		form.Errors.Add("confirm_password", "Passwords don't match")
+	}
+
+	if !form.Valid() {
+		u, err := m.DB.GetUserByResetToken(tokenHash)
+		if err != nil && !errors.Is(err, repository.ErrInvalidToken) {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["user"] = u
+
+		render.Template(w, r, "reset-password.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	_, err = m.DB.ResetPassword(tokenHash, r.Form.Get("password"))
+	if errors.Is(err, repository.ErrInvalidToken) {
+		m.App.Session.Put(r.Context(), "error", "This reset link has expired or was already used")
+		http.Redirect(w, r, "/user/forgot-password", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your password has been reset, log in with your new password")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// Forbidden tells a logged in user their role doesn't allow what they asked for
+func (m *Repository) Forbidden(w http.ResponseWriter, r *http.Request) {
+	w.WriteHeader(http.StatusForbidden)
+	render.Template(w, r, "forbidden.page.tmpl", &models.TemplateData{})
+}
+
+// Logout logs the user out by destroying session
+func (m *Repository) Logout(w http.ResponseWriter, r *http.Request) {
+	_ = m.App.Session.Destroy(r.Context())
+	_ = m.App.Session.RenewToken(r.Context())
+
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// AdminDashboard shows today's arrivals and departures, occupancy and revenue
+func (m *Repository) AdminDashboard(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+
+	stats, err := m.DB.DashboardStats(today)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// chart series, read by Chart.js in the page's script
+	var occupancyLabels []string
+	var occupancyValues []float64
+	for _, d := range stats.Occupancy {
+		occupancyLabels = append(occupancyLabels, d.Date.Format("Jan 2"))
+
+		percent := 0.0
+		if d.Rooms > 0 {
+			percent = float64(d.Occupied) * 100 / float64(d.Rooms)
+		}
+		occupancyValues = append(occupancyValues, percent)
+	}
+
+	var revenueLabels []string
+	var revenueValues []float64
+	for _, mr := range stats.Revenue {
+		revenueLabels = append(revenueLabels, mr.Month.Format("Jan 2006"))
+		revenueValues = append(revenueValues, float64(mr.Revenue)/100)
+	}
+
+	data := make(map[string]interface{})
+	data["stats"] = stats
+	data["occupancy_labels"] = occupancyLabels
+	data["occupancy_values"] = occupancyValues
+	data["revenue_labels"] = revenueLabels
+	data["revenue_values"] = revenueValues
+
+	intMap := make(map[string]int)
+	intMap["stats_window"] = models.StatsWindow
+
+	render.Template(w, r, "admin-dashboard.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminNewReservations shows all new reservations in admin
+func (m *Repository) AdminNewReservations(w http.ResponseWriter, r *http.Request) {
+	m.reservationList(w, r, "new", "admin-new-reservations.page.tmpl")
+}
+
+// AdminReservations shows all reservations in admin
+func (m *Repository) AdminAllReservations(w http.ResponseWriter, r *http.Request) {
+	m.reservationList(w, r, "all", "admin-all-reservations.page.tmpl")
+}
+
+// reservationsPerPage is how many reservations the admin lists show at a time
+const reservationsPerPage = 25
+
+// reservationList shows a page of the new or all reservations list, searched, filtered, sorted and paged
+// by the query string. It remembers the query so staff come back to the same page after opening a reservation
+func (m *Repository) reservationList(w http.ResponseWriter, r *http.Request, src, tmpl string) {
+	q := r.URL.Query()
+
+	f := reservationFilter(q)
+	if src == "new" {
+		f.Processed = 0
+	}
+
+	reservations, total, err := m.DB.SearchReservations(f)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRoomsIncludingArchived()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "reservations-"+src+"-query", q.Encode())
+
+	base := "/admin/reservations-" + src
+	pagination := models.Pagination{Page: f.Page, PerPage: f.PerPage, Total: total}
+
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+	stringMap["sort"] = f.Sort
+	stringMap["dir"] = "asc"
+	if f.Desc {
+		stringMap["dir"] = "desc"
+	}
+
+	for _, sort := range []string{"arrival", "created", "name"} {
+		dir := "asc"
+		if sort == f.Sort && !f.Desc {
+			dir = "desc"
+		}
+		stringMap["sort_"+sort] = listURL(base, q, map[string]string{"sort": sort, "dir": dir, "page": ""})
+	}
+
+	if pagination.HasPrev() {
+		stringMap["prev_url"] = listURL(base, q, map[string]string{"page": strconv.Itoa(f.Page - 1)})
+	}
+	if pagination.HasNext() {
+		stringMap["next_url"] = listURL(base, q, map[string]string{"page": strconv.Itoa(f.Page + 1)})
+	}
+
+	data := make(map[string]interface{})
+	data["reservations"] = reservations
+	data["rooms"] = rooms
+	data["pagination"] = pagination
+
+	render.Template(w, r, tmpl, &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(q),
+	})
+}
+
+// reservationFilter reads the search, filters, sort and page of an admin reservation list from its query
+// string. It sorts by arrival, latest first, unless told otherwise
+func reservationFilter(q url.Values) models.ReservationFilter {
+	layout := "2006-01-02"
+
+	f := models.ReservationFilter{
+		Search:    strings.TrimSpace(q.Get("q")),
+		Processed: -1,
+		Sort:      q.Get("sort"),
+		Desc:      q.Get("dir") != "asc",
+		PerPage:   reservationsPerPage,
+	}
+
+	switch f.Sort {
+	case "arrival", "created", "name":
+	default:
+		f.Sort = "arrival"
+	}
+
+	f.RoomID, _ = strconv.Atoi(q.Get("room"))
+	f.From, _ = time.Parse(layout, q.Get("from"))
+	f.To, _ = time.Parse(layout, q.Get("to"))
+
+	switch q.Get("processed") {
+	case "0":
+		f.Processed = 0
+	case "1":
+		f.Processed = 1
+	}
+
+	f.Page, _ = strconv.Atoi(q.Get("page"))
+	if f.Page < 1 {
+		f.Page = 1
+	}
+
+	return f
+}
+
+// listURL returns base with the query q, changed by set. Setting a key to "" removes it
+func listURL(base string, q url.Values, set map[string]string) string {
+	values := url.Values{}
+	for k, v := range q {
+		values[k] = v
+	}
+
+	for k, v := range set {
+		if v == "" {
+			values.Del(k)
+		} else {
+			values.Set(k, v)
+		}
+	}
+
+	if len(values) == 0 {
+		return base
+	}
+
+	return base + "?" + values.Encode()
+}
+
+// reservationListURL returns the new or all reservations list as staff last left it
+func (m *Repository) reservationListURL(ctx context.Context, src string) string {
+	link := "/admin/reservations-" + src
+
+	if q := m.App.Session.GetString(ctx, "reservations-"+src+"-query"); q != "" {
+		link += "?" + q
+	}
+
+	return link
+}
+
+func (m *Repository) AdminShowReservation(w http.ResponseWriter, r *http.Request) {
+	explodedUrl := strings.Split(r.RequestURI, "/")
+	src := explodedUrl[3]
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	id, err := strconv.Atoi(r.URL.Query().Get("id"))
+	year := r.URL.Query().Get("y")
+	month := r.URL.Query().Get("m")
+
+	stringMap["year"] = year
+	stringMap["month"] = month
+	stringMap["back"] = m.reservationListURL(r.Context(), src)
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRoomsIncludingArchived()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	values := url.Values{}
+	values.Set("first_name", res.FirstName)
+	values.Set("last_name", res.LastName)
+	values.Set("email", res.Email)
+	values.Set("phone", res.Phone)
+	values.Set("start_date", res.StartDate.Format("2006-01-02"))
+	values.Set("end_date", res.EndDate.Format("2006-01-02"))
+	values.Set("room_id", strconv.Itoa(res.RoomID))
+
+	data := make(map[string]interface{})
+	data["reservation"] = res
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+		Data:      data,
+		StringMap: stringMap,
+		Form:      forms.New(values),
+	})
+}
+
+// AdminMakeReservation shows the form staff use to book a room for a caller or a walk-in guest
+func (m *Repository) AdminMakeReservation(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	values := url.Values{}
+	values.Set("source", models.SourcePhone)
+	values.Set("room_id", r.URL.Query().Get("room"))
+	values.Set("start_date", r.URL.Query().Get("start"))
+	values.Set("end_date", r.URL.Query().Get("end"))
+	values.Set("adults", "1")
+	values.Set("children", "0")
+	values.Set("send_email", "1")
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-make-reservation.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(values),
+	})
+}
+
+// AdminPostMakeReservation books a room on behalf of a guest. Staff can book a stay that breaks a stay
+// rule by giving a reason, which is kept with the reservation
+func (m *Repository) AdminPostMakeReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "start_date", "end_date")
+	if r.Form.Get("email") != "" {
+		form.IsEmail("email")
+	}
+
+	var res models.Reservation
+	res.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	res.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	res.Email = strings.TrimSpace(r.Form.Get("email"))
+	res.Phone = strings.TrimSpace(r.Form.Get("phone"))
+
+	res.Source = r.Form.Get("source")
+	if res.Source != models.SourcePhone && res.Source != models.SourceWalkIn {
+		form.Errors.Add("source", "Choose how the guest booked")
+	}
+
+	res.StartDate, err = time.Parse("2006-01-02", r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+
+	res.EndDate, err = time.Parse("2006-01-02", r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if !res.EndDate.After(res.StartDate) {
+		form.Errors.Add("end_date", "Departure must be after arrival")
+	}
+
+	res.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+	if err != nil {
+		form.Errors.Add("room_id", "Choose a room")
+	}
+
+	res.Adults, res.Children, err = parseGuests(r.Form.Get("adults"), r.Form.Get("children"))
+	if err != nil {
+		form.Errors.Add("adults", "Invalid number of guests")
+	}
+
+	if form.Valid() {
+		room, err := m.DB.GetRoomByID(res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if room.Archived == 1 {
+			form.Errors.Add("room_id", "This room is archived")
+		} else if !room.Sleeps(res.Adults, res.Children) {
+			form.Errors.Add("room_id", fmt.Sprintf("This room sleeps up to %d adults and %d children", room.MaxAdults, room.MaxChildren))
+		}
+
+		res.Room.ID = room.ID
+		res.Room.RoomName = room.RoomName
+
+		rules, err := m.DB.StayRulesForDates(res.StartDate, res.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if reason := stayRuleViolation(rules, res.RoomID, res.StartDate, res.EndDate); reason != "" {
+			if r.Form.Get("override_rules") != "1" {
+				form.Errors.Add("start_date", fmt.Sprintf("This stay breaks a stay rule: %s", reason))
+			} else {
+				res.OverrideReason = strings.TrimSpace(r.Form.Get("override_reason"))
+				if res.OverrideReason == "" {
+					form.Errors.Add("override_reason", "Say why the stay rule is being overridden")
+				}
+			}
+		}
+	}
+
+	var newID int
+	if form.Valid() {
+		price, err := m.DB.GetPriceForStay(res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		res.TotalPrice = price.Total
+
+		res.ConfirmationCode, err = helpers.NewConfirmationCode()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		newID, err = m.DB.BookRoom(res)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			form.Errors.Add("start_date", "The room is already booked or blocked on some of these nights")
+		} else if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	if !form.Valid() {
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["rooms"] = rooms
+
+		render.Template(w, r, "admin-make-reservation.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	res.ID = newID
+
+	// staff took the booking, so there's nothing new for them to process
+	err = m.DB.UpdateProcessedForReservation(newID, 1)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	if r.Form.Get("send_email") == "1" && res.Email != "" {
+		m.sendReservationConfirmation(res)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", fmt.Sprintf("Reservation made, confirmation code %s", res.ConfirmationCode))
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations/all/?id=%d", newID), http.StatusSeeOther)
+}
+
+// AdminShowGroup shows every room booked together in a reservation group
+func (m *Repository) AdminShowGroup(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	group, err := m.DB.GetReservationGroupByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["group"] = group
+
+	render.Template(w, r, "admin-group-show.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+		return
+	}
+
+	params := r.URL.Query()
+
+	id, _ := strconv.Atoi(params.Get("id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	currentMonth := params.Get("m")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	currentYear := params.Get("y")
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	explodedUrl := strings.Split(r.RequestURI, "/")
+	src := explodedUrl[3]
+	stringMap := make(map[string]string)
+	stringMap["src"] = src
+
+	stringMap["month"] = currentMonth
+	stringMap["year"] = currentYear
+
+	res, err := m.DB.GetReservationByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	original := res
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email")
+	form.IsEmail("email")
+
+	res.FirstName = r.Form.Get("first_name")
+	res.LastName = r.Form.Get("last_name")
+	res.Email = r.Form.Get("email")
+	res.Phone = r.Form.Get("phone")
+
+	// the stay of a cancelled reservation can't be changed, and its form doesn't post one
+	if res.Cancelled == 0 && r.Form.Get("start_date") != "" {
+		res.StartDate, err = time.Parse("2006-01-02", r.Form.Get("start_date"))
+		if err != nil {
+			form.Errors.Add("start_date", "Invalid date")
+		}
+
+		res.EndDate, err = time.Parse("2006-01-02", r.Form.Get("end_date"))
+		if err != nil {
+			form.Errors.Add("end_date", "Invalid date")
+		} else if !res.EndDate.After(res.StartDate) {
+			form.Errors.Add("end_date", "Departure must be after arrival")
+		}
+
+		res.RoomID, err = strconv.Atoi(r.Form.Get("room_id"))
+		if err != nil {
+			form.Errors.Add("room_id", "Choose a room")
+		}
+	}
+
+	stayChanged := res.RoomID != original.RoomID ||
+		!res.StartDate.Equal(original.StartDate) || !res.EndDate.Equal(original.EndDate)
+
+	if form.Valid() && stayChanged {
+		room, err := m.DB.GetRoomByID(res.RoomID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		if res.RoomID != original.RoomID && room.Archived == 1 {
+			form.Errors.Add("room_id", "This room is archived")
+		} else if !room.Sleeps(res.Adults, res.Children) {
+			form.Errors.Add("room_id", fmt.Sprintf("This room sleeps up to %d adults and %d children", room.MaxAdults, room.MaxChildren))
+		}
+
+		res.Room.ID = room.ID
+		res.Room.RoomName = room.RoomName
+
+		// price the new stay at the current rates
+		price, err := m.DB.GetPriceForStay(res.RoomID, res.StartDate, res.EndDate)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		res.TotalPrice = price.Total
+	}
+
+	if form.Valid() {
+		err = m.DB.UpdateReservation(res)
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			form.Errors.Add("start_date", "The room is already booked or blocked on some of these nights")
+		}
+	}
+
+	if !form.Valid() {
+		rooms, err := m.DB.AllRoomsIncludingArchived()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		stringMap["back"] = m.reservationListURL(r.Context(), src)
+
+		data := make(map[string]interface{})
+		data["reservation"] = original
+		data["rooms"] = rooms
+
+		render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+			Data:      data,
+			StringMap: stringMap,
+			Form:      form,
+		})
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if stayChanged {
+		// offer the nights the guest no longer needs to the waitlist
+		m.notifyWaitlist(original.RoomID, original.StartDate, original.EndDate)
+	}
+
+	if r.Form.Get("notify_guest") == "1" {
+		m.sendReservationUpdate(res)
+	}
+
+	month := r.Form.Get("month")
+	year := r.Form.Get("year")
+
+	m.App.Session.Put(r.Context(), "flash", "Changes saved")
+	if year != "" {
+		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?m=%s&y=%s", month, year), http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, m.reservationListURL(r.Context(), src), http.StatusSeeOther)
+	}
+}
+
+// sendReservationUpdate emails the guest the details of their reservation after staff changed it
+func (m *Repository) sendReservationUpdate(res models.Reservation) {
+	htmlMessage := fmt.Sprintf(`
+	<strong>Reservation Updated</strong><br>
+	Dear %s, <br>
+	Your reservation has been changed. You are now staying from %s to %s, in %s.<br>
+	The total for your stay is %s.<br>
+	Your confirmation code is still <strong>%s</strong>. You can view your reservation at any time
+	<a href="%s/reservations/%s">here</a>.`,
+		res.FirstName,
+		res.StartDate.Format("2006-01-02"),
+		res.EndDate.Format("2006-01-02"),
+		res.Room.RoomName,
+		render.FormatPrice(res.TotalPrice),
+		res.ConfirmationCode,
+		m.App.BaseURL,
+		res.ConfirmationCode)
+
+	m.App.MailChan <- models.MailData{
+		To:       res.Email,
+		From:     "me@here.com",
+		Subject:  "Your reservation has been updated",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+
+	if r.URL.Query().Get("y") != "" {
+		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
+		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
+		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
+	}
+
+	data := make(map[string]interface{})
+	data["now"] = now
+
+	next := now.AddDate(0, 1, 0)
+	last := now.AddDate(0, -1, 0)
+
+	nextMonth := next.Format("01")
+	nextMonthYear := next.Format("2006")
+
+	lastMonth := last.Format("01")
+	lastMonthYear := last.Format("2006")
+
+	stringMap := make(map[string]string)
+	stringMap["next_month"] = nextMonth
+	stringMap["next_month_year"] = nextMonthYear
+
+	stringMap["last_month"] = lastMonth
+	stringMap["last_month_year"] = lastMonthYear
+
+	stringMap["this_month"] = now.Format("01")
+	stringMap["this_month_year"] = now.Format("2006")
+
+	// get the first and last days of the month
+	currentYear, currentMonth, _ := now.Date()
+	currentLocation := now.Location()
+	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+	intMap := make(map[string]int)
+	intMap["days_in_month"] = lastOfMonth.Day()
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data["rooms"] = rooms
+
+	// get the restrictions of every room for the month in one go
+	restrictions, err := m.DB.GetRoomRestrictionsByDate(firstOfMonth, lastOfMonth.AddDate(0, 0, 1))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	restrictionsByRoom := make(map[int][]models.RoomRestriction)
+	for _, rr := range restrictions {
+		restrictionsByRoom[rr.RoomID] = append(restrictionsByRoom[rr.RoomID], rr)
+	}
+
+	for _, room := range rooms {
+		reservationMap := make(map[string]int)                  // holds reservation ids
+		blockMap := make(map[string]int)                        // holds restriction ids
+		singleBlockMap := make(map[string]int)                  // holds restriction ids of one-night blocks
+		blockDetails := make(map[string]models.RoomRestriction) // holds the block covering each day
+		holdMap := make(map[string]int)                         // holds restriction ids of rooms held during checkout
+
+		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
+			reservationMap[d.Format("2006-01-2")] = 0
+			blockMap[d.Format("2006-01-2")] = 0
+			holdMap[d.Format("2006-01-2")] = 0
+		}
+
+		for _, roomRestriction := range restrictionsByRoom[room.ID] {
+			if roomRestriction.RestrictionID == models.RestrictionHold {
+				// a guest is checking out
+				for d := roomRestriction.StartDate; d.Before(roomRestriction.EndDate); d = d.AddDate(0, 0, 1) {
+					holdMap[d.Format("2006-01-2")] = roomRestriction.ID
+				}
+			} else if roomRestriction.ReservationID > 0 {
+				// a reservation
+				for d := roomRestriction.StartDate; !d.After(roomRestriction.EndDate); d = d.AddDate(0, 0, 1) {
+					reservationMap[d.Format("2006-01-2")] = roomRestriction.ReservationID
+				}
+			} else {
+				// a block
+				for d := roomRestriction.StartDate; d.Before(roomRestriction.EndDate); d = d.AddDate(0, 0, 1) {
+					blockMap[d.Format("2006-01-2")] = roomRestriction.ID
+					blockDetails[d.Format("2006-01-2")] = roomRestriction
+				}
+
+				if roomRestriction.Nights() == 1 {
+					singleBlockMap[roomRestriction.StartDate.Format("2006-01-2")] = roomRestriction.ID
+				}
+			}
+		}
+
+		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
+		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+		data[fmt.Sprintf("block_details_%d", room.ID)] = blockDetails
+		data[fmt.Sprintf("hold_map_%d", room.ID)] = holdMap
+
+		// only one-night blocks are toggled by checkbox, longer ones are edited on their own page
+		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", room.ID), singleBlockMap)
+	}
+
+	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+// timelineRange returns the first day and the number of days of the week, month or quarter view of the
+// timeline that contains day, with the first days of the ranges before and after it
+func timelineRange(view string, day time.Time) (start time.Time, days int, prev, next time.Time) {
+	day = time.Date(day.Year(), day.Month(), day.Day(), 0, 0, 0, 0, time.UTC)
+
+	var end time.Time
+	switch view {
+	case "week":
+		// weeks start on a Monday
+		start = day.AddDate(0, 0, -((int(day.Weekday()) + 6) % 7))
+		end = start.AddDate(0, 0, 7)
+		prev = start.AddDate(0, 0, -7)
+	case "quarter":
+		start = time.Date(day.Year(), (day.Month()-1)/3*3+1, 1, 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 3, 0)
+		prev = start.AddDate(0, -3, 0)
+	default:
+		start = time.Date(day.Year(), day.Month(), 1, 0, 0, 0, 0, time.UTC)
+		end = start.AddDate(0, 1, 0)
+		prev = start.AddDate(0, -1, 0)
+	}
+
+	return start, int(end.Sub(start).Hours() / 24), prev, end
+}
+
+// timelineRows lays the restrictions out as bars on a row per room, clipped to the days from start
+func timelineRows(rooms []models.Room, restrictions []models.RoomRestriction, start time.Time, days int) []models.TimelineRow {
+	end := start.AddDate(0, 0, days)
+
+	rows := make([]models.TimelineRow, len(rooms))
+	index := make(map[int]int)
+	for i, room := range rooms {
+		rows[i].Room = room
+		index[room.ID] = i
+	}
+
+	for _, rr := range restrictions {
+		i, ok := index[rr.RoomID]
+		if !ok {
+			continue
+		}
+
+		from, to := rr.StartDate, rr.EndDate
+		if from.Before(start) {
+			from = start
+		}
+		if to.After(end) {
+			to = end
+		}
+		if !to.After(from) {
+			continue
+		}
+
+		bar := models.TimelineBar{
+			Column: int(from.Sub(start).Hours()/24) + 1,
+			Span:   int(to.Sub(from).Hours() / 24),
+		}
+
+		dates := fmt.Sprintf("%s to %s", rr.StartDate.Format("2006-01-02"), rr.EndDate.Format("2006-01-02"))
+
+		switch {
+		case rr.RestrictionID == models.RestrictionHold:
+			bar.Kind = "hold"
+			bar.Label = "Held"
+			bar.Title = fmt.Sprintf("Held while a guest checks out, %s", dates)
+		case rr.ReservationID > 0:
+			bar.Kind = "reservation"
+			if rr.Reservation.Processed == 0 {
+				bar.Kind = "new-reservation"
+			}
+			bar.Label = strings.TrimSpace(rr.Reservation.FirstName + " " + rr.Reservation.LastName)
+			bar.Title = fmt.Sprintf("%s, %s", bar.Label, dates)
+			bar.URL = fmt.Sprintf("/admin/reservations/cal/?id=%d&y=%s&m=%s",
+				rr.ReservationID, rr.StartDate.Format("2006"), rr.StartDate.Format("01"))
+		default:
+			bar.Kind = "block"
+			bar.Label = rr.Reason
+			if bar.Label == "" {
+				bar.Label = "Blocked"
+			}
+			bar.Title = fmt.Sprintf("%s, %s", bar.Label, dates)
+			bar.URL = fmt.Sprintf("/admin/blocks/%d", rr.ID)
+		}
+
+		rows[i].Bars = append(rows[i].Bars, bar)
+	}
+
+	return rows
+}
+
+// AdminReservationsTimeline shows every room's stays, blocks and holds as bars across a week, month or
+// quarter
+func (m *Repository) AdminReservationsTimeline(w http.ResponseWriter, r *http.Request) {
+	view := r.URL.Query().Get("view")
+	if view != "week" && view != "quarter" {
+		view = "month"
+	}
+
+	day, err := time.Parse("2006-01-02", r.URL.Query().Get("date"))
+	if err != nil {
+		day = time.Now()
+	}
+
+	start, days, prev, next := timelineRange(view, day)
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	restrictions, err := m.DB.GetRoomRestrictionsByDate(start, start.AddDate(0, 0, days))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	var dates []time.Time
+	for i := 0; i < days; i++ {
+		dates = append(dates, start.AddDate(0, 0, i))
+	}
+
+	// label each month the range runs into across its days
+	var months []models.TimelineBar
+	for i, d := range dates {
+		if i == 0 || d.Day() == 1 {
+			months = append(months, models.TimelineBar{Column: i + 1, Label: d.Format("January 2006")})
+		}
+		months[len(months)-1].Span++
+	}
+
+	stringMap := make(map[string]string)
+	stringMap["view"] = view
+	stringMap["prev"] = prev.Format("2006-01-02")
+	stringMap["next"] = next.Format("2006-01-02")
+	stringMap["today"] = time.Now().Format("2006-01-02")
+
+	intMap := make(map[string]int)
+	intMap["days"] = days
+
+	data := make(map[string]interface{})
+	data["start"] = start
+	data["dates"] = dates
+	data["months"] = months
+	data["rows"] = timelineRows(rooms, restrictions, start, days)
+
+	render.Template(w, r, "admin-reservations-timeline.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		IntMap:    intMap,
+		Data:      data,
+	})
+}
+
+func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	month := r.URL.Query().Get("m")
+	year := r.URL.Query().Get("y")
+	src := chi.URLParam(r, "src")
+
+	_ = m.DB.UpdateProcessedForReservation(id, 1)
+	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+
+	if src == "cal" {
+		redirectUrl := fmt.Sprintf("/admin/reservations-calendar?m=%s&y=%s", month, year)
+		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, m.reservationListURL(r.Context(), src), http.StatusSeeOther)
+	}
+}
+
+func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	month := r.URL.Query().Get("m")
+	year := r.URL.Query().Get("y")
+	src := chi.URLParam(r, "src")
+
+	res, err := m.DB.GetReservationByID(id)
+	_ = m.DB.DeleteReservation(id)
+	m.App.Session.Put(r.Context(), "flash", "Reservation succesfully deleted")
+
+	if err == nil && res.Cancelled == 0 {
+		m.notifyWaitlist(res.RoomID, res.StartDate, res.EndDate)
+	}
+
+	if src == "cal" {
+		redirectUrl := fmt.Sprintf("/admin/reservations-calendar?m=%s&y=%s", month, year)
+		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+	} else {
+		http.Redirect(w, r, m.reservationListURL(r.Context(), src), http.StatusSeeOther)
+	}
+}
+
+// AdminPostReservationsCalendar handls post of reservation calendar
+func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	year, _ := strconv.Atoi(r.Form.Get("y"))
+	month, _ := strconv.Atoi(r.Form.Get("m"))
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+
+	for _, room := range rooms {
+		// Get the block map from the session, and loop through map
+		// if we have an entry that DOES NOT EXIST in our posted data,
+		// and if restriction id > 0 then we need to remove that block
+		currentMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
+
+		for name, value := range currentMap {
+			// ok will be false if that value is not in the map
+			if val, ok := currentMap[name]; ok {
+				// only pay attention to values > 0, and are not in the form post
+				if val > 0 {
+					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
+						// delete the room restriction by id
+						err := m.DB.DeleteBlockByID(value)
+						if err != nil {
+							m.App.ErrorLog.Println(err)
+							continue
+						}
+
+						blockDate, _ := time.Parse("2006-01-2", name)
+						m.notifyWaitlist(room.ID, blockDate, blockDate.AddDate(0, 0, 1))
+					}
+				}
+			}
+		}
+	}
+
+	// now handle new blocks
+	for name, _ := range r.PostForm {
+		if strings.HasPrefix(name, "add_block") {
+			exploded := strings.Split(name, "_")
+			roomID, _ := strconv.Atoi(exploded[2])
+			startDate, _ := time.Parse("2006-01-2", exploded[3])
+
+			// insert a new one-night block
+			err := m.DB.InsertBlocks([]int{roomID}, startDate, startDate.AddDate(0, 0, 1), "")
+			if err != nil {
+				m.App.ErrorLog.Println(err)
+			}
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+}
+
+// AdminShowBlock shows the form to block rooms over a range of dates, or to edit a block when the id
+// isn't "new"
+func (m *Repository) AdminShowBlock(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "id")
+
+	stringMap := make(map[string]string)
+	stringMap["id"] = src
+
+	values := url.Values{}
+	data := make(map[string]interface{})
+
+	if src == "new" {
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data["rooms"] = rooms
+
+		if room := r.URL.Query().Get("room"); room != "" {
+			values.Add("room", room)
+		}
+		values.Set("start", r.URL.Query().Get("start"))
+		values.Set("end", r.URL.Query().Get("start"))
+	} else {
+		id, err := strconv.Atoi(src)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		block, err := m.DB.GetBlockByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data["block"] = block
+
+		values.Set("start", block.StartDate.Format("2006-01-02"))
+		values.Set("end", block.EndDate.AddDate(0, 0, -1).Format("2006-01-02"))
+		values.Set("reason", block.Reason)
+	}
+
+	data["selected"] = selectedRooms(values)
+
+	render.Template(w, r, "admin-block-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(values),
+	})
+}
+
+// selectedRooms returns the ids of the rooms ticked on the block form
+func selectedRooms(values url.Values) map[int]bool {
+	selected := make(map[int]bool)
+	for _, v := range values["room"] {
+		if id, err := strconv.Atoi(v); err == nil {
+			selected[id] = true
+		}
+	}
+
+	return selected
+}
+
+// AdminPostBlock blocks the chosen rooms from the first night to the last night given, or moves and
+// re-annotates an existing block when the id isn't "new"
+func (m *Repository) AdminPostBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "id")
+	data := make(map[string]interface{})
+
+	var old models.RoomRestriction
+	if src != "new" {
+		id, err := strconv.Atoi(src)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		old, err = m.DB.GetBlockByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data["block"] = old
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("start", "end")
+
+	reason := strings.TrimSpace(r.Form.Get("reason"))
+
+	start, err := time.Parse("2006-01-02", r.Form.Get("start"))
+	if err != nil {
+		form.Errors.Add("start", "Invalid date")
+	}
+
+	last, err := time.Parse("2006-01-02", r.Form.Get("end"))
+	if err != nil {
+		form.Errors.Add("end", "Invalid date")
+	} else if last.Before(start) {
+		form.Errors.Add("end", "The last night can't be before the first")
+	}
+
+	// a block lifts the morning after its last night
+	end := last.AddDate(0, 0, 1)
+
+	var roomIDs []int
+	if src == "new" {
+		for id := range selectedRooms(r.PostForm) {
+			roomIDs = append(roomIDs, id)
+		}
+		sort.Ints(roomIDs)
+
+		if len(roomIDs) == 0 {
+			form.Errors.Add("room", "Choose at least one room")
+		}
+	}
+
+	if form.Valid() {
+		if src == "new" {
+			err = m.DB.InsertBlocks(roomIDs, start, end, reason)
+		} else {
+			block := old
+			block.StartDate = start
+			block.EndDate = end
+			block.Reason = reason
+			err = m.DB.UpdateBlock(block)
+		}
+		if errors.Is(err, repository.ErrRoomUnavailable) {
+			form.Errors.Add("start", "These dates run into a reservation or another block")
+		}
+	}
+
+	if !form.Valid() {
+		if src == "new" {
+			rooms, err := m.DB.AllRooms()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+
+			data["rooms"] = rooms
+		}
+		data["selected"] = selectedRooms(r.PostForm)
+
+		stringMap := make(map[string]string)
+		stringMap["id"] = src
+
+		render.Template(w, r, "admin-block-show.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Data:      data,
+			Form:      form,
+		})
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// offer any nights the block no longer covers to the waitlist
+	if src != "new" {
+		if start.After(old.StartDate) {
+			freedEnd := start
+			if freedEnd.After(old.EndDate) {
+				freedEnd = old.EndDate
+			}
+			m.notifyWaitlist(old.RoomID, old.StartDate, freedEnd)
+		}
+		if end.Before(old.EndDate) {
+			freedStart := end
+			if freedStart.Before(old.StartDate) {
+				freedStart = old.StartDate
+			}
+			m.notifyWaitlist(old.RoomID, freedStart, old.EndDate)
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Block saved")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", start.Year(), start.Month()), http.StatusSeeOther)
+}
+
+// AdminDeleteBlock removes an owner block and offers its nights to the waitlist
+func (m *Repository) AdminDeleteBlock(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	block, err := m.DB.GetBlockByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteBlockByID(block.ID)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.notifyWaitlist(block.RoomID, block.StartDate, block.EndDate)
+
+	m.App.Session.Put(r.Context(), "flash", "Block removed")
+	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", block.StartDate.Year(), block.StartDate.Month()), http.StatusSeeOther)
+}
+
+// AdminRoomRates shows the seasonal rates and a form to add one
+func (m *Repository) AdminRoomRates(w http.ResponseWriter, r *http.Request) {
+	rates, err := m.DB.AllRoomRates()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rates"] = rates
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rates.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// AdminPostRoomRate adds a seasonal rate for a room
+func (m *Repository) AdminPostRoomRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name", "start_date", "end_date", "nightly_rate")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if endDate.Before(startDate) {
+		form.Errors.Add("end_date", "The last night must not be before the first")
+	}
+
+	nightlyRate, err := helpers.ParsePrice(r.Form.Get("nightly_rate"))
+	if err != nil {
+		form.Errors.Add("nightly_rate", "Invalid price")
+	}
+
+	weekendRate, err := helpers.ParsePrice(r.Form.Get("weekend_rate"))
+	if err != nil {
+		form.Errors.Add("weekend_rate", "Invalid price")
+	}
+
+	roomID, _ := strconv.Atoi(r.Form.Get("room_id"))
+
+	if !form.Valid() {
+		rates, err := m.DB.AllRoomRates()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["rates"] = rates
+		data["rooms"] = rooms
+
+		render.Template(w, r, "admin-rates.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	err = m.DB.InsertRoomRate(models.RoomRate{
+		RoomID:      roomID,
+		Name:        r.Form.Get("name"),
+		StartDate:   startDate,
+		EndDate:     endDate,
+		NightlyRate: nightlyRate,
+		WeekendRate: weekendRate,
+	})
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate added")
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// AdminDeleteRoomRate deletes a seasonal rate
+func (m *Repository) AdminDeleteRoomRate(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	err = m.DB.DeleteRoomRate(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Rate deleted")
+	http.Redirect(w, r, "/admin/rates", http.StatusSeeOther)
+}
+
+// AdminStayRules shows the stay rules and a form to add one
+func (m *Repository) AdminStayRules(w http.ResponseWriter, r *http.Request) {
+	rules, err := m.DB.AllStayRules()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	rooms, err := m.DB.AllRooms()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rules"] = rules
+	data["rooms"] = rooms
+	data["weekdays"] = weekdays
+
+	render.Template(w, r, "admin-stay-rules.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// weekdays lists the days of the week in the order they are shown on the stay rules page
+var weekdays = []time.Weekday{
+	time.Monday, time.Tuesday, time.Wednesday, time.Thursday, time.Friday, time.Saturday, time.Sunday,
+}
+
+// weekdayMask turns the weekday checkboxes posted under field into a bitmask of 1 << time.Weekday
+func weekdayMask(values []string) int {
+	mask := 0
+	for _, v := range values {
+		d, err := strconv.Atoi(v)
+		if err == nil && d >= 0 && d <= 6 {
+			mask |= 1 << d
+		}
+	}
+
+	return mask
+}
+
+// AdminPostStayRule adds a stay rule for a room
+func (m *Repository) AdminPostStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_id", "name", "start_date", "end_date")
+
+	layout := "2006-01-02"
+	startDate, err := time.Parse(layout, r.Form.Get("start_date"))
+	if err != nil {
+		form.Errors.Add("start_date", "Invalid date")
+	}
+
+	endDate, err := time.Parse(layout, r.Form.Get("end_date"))
+	if err != nil {
+		form.Errors.Add("end_date", "Invalid date")
+	} else if endDate.Before(startDate) {
+		form.Errors.Add("end_date", "The last day must not be before the first")
+	}
+
+	minNights, maxNights := 0, 0
+	if v := r.Form.Get("min_nights"); v != "" {
+		minNights, err = strconv.Atoi(v)
+		if err != nil || minNights < 0 {
+			form.Errors.Add("min_nights", "Invalid number of nights")
+		}
+	}
+
+	if v := r.Form.Get("max_nights"); v != "" {
+		maxNights, err = strconv.Atoi(v)
+		if err != nil || maxNights < 0 {
+			form.Errors.Add("max_nights", "Invalid number of nights")
+		} else if maxNights > 0 && maxNights < minNights {
+			form.Errors.Add("max_nights", "The maximum must not be less than the minimum")
+		}
+	}
+
+	rule := models.StayRule{
+		Name:              r.Form.Get("name"),
+		StartDate:         startDate,
+		EndDate:           endDate,
+		MinNights:         minNights,
+		MaxNights:         maxNights,
+		ClosedToArrival:   weekdayMask(r.Form["closed_to_arrival"]),
+		ClosedToDeparture: weekdayMask(r.Form["closed_to_departure"]),
+	}
+	rule.RoomID, _ = strconv.Atoi(r.Form.Get("room_id"))
+
+	if rule.MinNights == 0 && rule.MaxNights == 0 && rule.ClosedToArrival == 0 && rule.ClosedToDeparture == 0 {
+		form.Errors.Add("name", "The rule doesn't restrict anything")
+	}
+
+	if !form.Valid() {
+		rules, err := m.DB.AllStayRules()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		rooms, err := m.DB.AllRooms()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		data := make(map[string]interface{})
+		data["rules"] = rules
+		data["rooms"] = rooms
+		data["weekdays"] = weekdays
+
+		render.Template(w, r, "admin-stay-rules.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	err = m.DB.InsertStayRule(rule)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule added")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminDeleteStayRule deletes a stay rule
+func (m *Repository) AdminDeleteStayRule(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	err = m.DB.DeleteStayRule(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Stay rule deleted")
+	http.Redirect(w, r, "/admin/stay-rules", http.StatusSeeOther)
+}
+
+// AdminRooms lists every room, archived rooms included
+func (m *Repository) AdminRooms(w http.ResponseWriter, r *http.Request) {
+	rooms, err := m.DB.AllRoomsIncludingArchived()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["rooms"] = rooms
+
+	render.Template(w, r, "admin-rooms.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowRoom shows the form to add a room, or to edit one when the id isn't "new"
+func (m *Repository) AdminShowRoom(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "id")
+
+	stringMap := make(map[string]string)
+	stringMap["id"] = src
+
+	values := url.Values{}
+	values.Set("max_adults", "2")
+	values.Set("max_children", "0")
+
+	if src != "new" {
+		id, err := strconv.Atoi(src)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		room, err := m.DB.GetRoomByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		values.Set("room_name", room.RoomName)
+		values.Set("slug", room.Slug)
+		values.Set("description", room.Description)
+		values.Set("amenities", room.Amenities)
+		values.Set("image", room.Image)
+		values.Set("base_rate", render.FormatPrice(room.BaseRate))
+		if room.WeekendRate > 0 {
+			values.Set("weekend_rate", render.FormatPrice(room.WeekendRate))
+		}
+		values.Set("max_adults", strconv.Itoa(room.MaxAdults))
+		values.Set("max_children", strconv.Itoa(room.MaxChildren))
+	}
+
+	render.Template(w, r, "admin-room-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Form:      forms.New(values),
+	})
+}
+
+// AdminPostRoom adds a room, or updates one when the id isn't "new"
+func (m *Repository) AdminPostRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "id")
+
+	var room models.Room
+	if src != "new" {
+		room.ID, err = strconv.Atoi(src)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("room_name", "base_rate", "max_adults")
+	form.MinLength("room_name", 3)
+
+	room.RoomName = strings.TrimSpace(r.Form.Get("room_name"))
+	room.Description = strings.TrimSpace(r.Form.Get("description"))
+	room.Amenities = strings.TrimSpace(r.Form.Get("amenities"))
+	room.Image = strings.TrimSpace(r.Form.Get("image"))
+
+	room.Slug = strings.TrimSpace(r.Form.Get("slug"))
+	if room.Slug == "" {
+		room.Slug = helpers.Slugify(room.RoomName)
+	}
+	if room.Slug != helpers.Slugify(room.Slug) {
+		form.Errors.Add("slug", "Use only lowercase letters, numbers and dashes")
+	}
+
+	room.BaseRate, err = helpers.ParsePrice(r.Form.Get("base_rate"))
+	if err != nil {
+		form.Errors.Add("base_rate", "Invalid price")
+	}
+
+	room.WeekendRate, err = helpers.ParsePrice(r.Form.Get("weekend_rate"))
+	if err != nil {
+		form.Errors.Add("weekend_rate", "Invalid price")
+	}
+
+	room.MaxAdults, err = strconv.Atoi(r.Form.Get("max_adults"))
+	if err != nil || room.MaxAdults < 1 {
+		form.Errors.Add("max_adults", "A room must sleep at least one adult")
+	}
+
+	if v := r.Form.Get("max_children"); v != "" {
+		room.MaxChildren, err = strconv.Atoi(v)
+		if err != nil || room.MaxChildren < 0 {
+			form.Errors.Add("max_children", "Invalid number of children")
+		}
+	}
+
+	if form.Valid() {
+		if room.ID == 0 {
+			_, err = m.DB.InsertRoom(room)
+		} else {
+			err = m.DB.UpdateRoom(room)
+		}
+		if errors.Is(err, repository.ErrSlugTaken) {
+			form.Errors.Add("slug", "Another room already uses this address")
+		}
+	}
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["id"] = src
+
+		render.Template(w, r, "admin-room-show.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Form:      form,
+		})
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room saved")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminArchiveRoom archives a room, or restores it when archived is 0
+func (m *Repository) AdminArchiveRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+	archived, _ := strconv.Atoi(r.Form.Get("archived"))
+
+	err = m.DB.ArchiveRoom(id, archived)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if archived == 1 {
+		m.App.Session.Put(r.Context(), "flash", "Room archived")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "Room restored")
+	}
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminDeleteRoom deletes a room that has nothing booked or blocked ahead of it
+func (m *Repository) AdminDeleteRoom(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	// the photo records go with the room, so look them up first to remove their files after
+	photos, err := m.DB.RoomPhotos(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRoom(id)
+	if errors.Is(err, repository.ErrRoomInUse) {
+		m.App.Session.Put(r.Context(), "error", "This room has reservations or upcoming blocks. Archive it instead.")
+		http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	for _, p := range photos {
+		err = media.DeletePhoto(m.App.Storage, p.FileName)
+		if err != nil {
+			m.App.ErrorLog.Println(err)
+		}
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Room deleted")
+	http.Redirect(w, r, "/admin/rooms", http.StatusSeeOther)
+}
+
+// AdminRoomPhotos shows a room's photos and the form to upload more
+func (m *Repository) AdminRoomPhotos(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	room, err := m.DB.GetRoomByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photos, err := m.DB.RoomPhotos(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["room"] = room
+	data["photos"] = photos
+
+	intMap := make(map[string]int)
+	intMap["max_photo_size"] = media.MaxPhotoSize
+	intMap["max_photo_mb"] = media.MaxPhotoSize >> 20
+
+	render.Template(w, r, "admin-room-photos.page.tmpl", &models.TemplateData{
+		Data:   data,
+		IntMap: intMap,
+	})
+}
+
+// AdminPostRoomPhoto uploads a photo of a room, storing it in every size
+func (m *Repository) AdminPostRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	// check the room is there before saving anything for it
+	_, err = m.DB.GetRoomByID(id)
+	if err != nil {
+		http.NotFound(w, r)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d/photos", id)
+
+	err = r.ParseMultipartForm(media.MaxPhotoSize)
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose a photo to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	file, header, err := r.FormFile("photo")
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Choose a photo to upload")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+	defer file.Close()
+
+	if header.Size > media.MaxPhotoSize {
+		m.App.Session.Put(r.Context(), "error", "That photo is too large")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	data, err := io.ReadAll(file)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	fileName, err := media.SavePhoto(m.App.Storage, data)
+	if errors.Is(err, media.ErrUnsupportedImage) {
+		m.App.Session.Put(r.Context(), "error", "Only JPEG and PNG photos can be uploaded")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if errors.Is(err, media.ErrImageTooLarge) {
+		m.App.Session.Put(r.Context(), "error", "That photo is too large")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	_, err = m.DB.InsertRoomPhoto(models.RoomPhoto{
+		RoomID:   id,
+		FileName: fileName,
+		Caption:  strings.TrimSpace(r.Form.Get("caption")),
+	})
+	if err != nil {
+		_ = media.DeletePhoto(m.App.Storage, fileName)
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo uploaded")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminPostRoomPhotoDetails updates a photo's caption and position
+func (m *Repository) AdminPostRoomPhotoDetails(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, err := strconv.Atoi(chi.URLParam(r, "id"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	photo, err := m.DB.GetRoomPhotoByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	back := fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID)
+
+	photo.Caption = strings.TrimSpace(r.Form.Get("caption"))
+
+	photo.SortOrder, err = strconv.Atoi(r.Form.Get("sort_order"))
+	if err != nil {
+		m.App.Session.Put(r.Context(), "error", "Invalid position")
+		http.Redirect(w, r, back, http.StatusSeeOther)
+		return
+	}
+
+	err = m.DB.UpdateRoomPhoto(photo)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo saved")
+	http.Redirect(w, r, back, http.StatusSeeOther)
+}
+
+// AdminDeleteRoomPhoto deletes a room photo and its files
+func (m *Repository) AdminDeleteRoomPhoto(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	photo, err := m.DB.GetRoomPhotoByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = m.DB.DeleteRoomPhoto(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	err = media.DeletePhoto(m.App.Storage, photo.FileName)
+	if err != nil {
+		m.App.ErrorLog.Println(err)
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Photo deleted")
+	http.Redirect(w, r, fmt.Sprintf("/admin/rooms/%d/photos", photo.RoomID), http.StatusSeeOther)
+}
+
+// Photo serves a room photo from storage. File names are random and never reused, so
+// browsers may cache photos for good
+func (m *Repository) Photo(w http.ResponseWriter, r *http.Request) {
+	size := chi.URLParam(r, "size")
+	if !media.ValidSize(size) {
+		http.NotFound(w, r)
+		return
+	}
+
+	name := chi.URLParam(r, "name")
+
+	f, err := m.App.Storage.Open(media.PhotoPath(size, name))
+	if errors.Is(err, fs.ErrNotExist) || errors.Is(err, fs.ErrInvalid) {
+		http.NotFound(w, r)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "public, max-age=31536000, immutable")
+	http.ServeContent(w, r, name, info.ModTime(), f)
+}
+
+// userInviteTTL is how long a new user has to accept their invite and set a password
+const userInviteTTL = 7 * 24 * time.Hour
+
+// userRoles are the roles offered when inviting or editing a user, least allowed first
+var userRoles = []models.Role{models.RoleReadOnly, models.RoleFrontDesk, models.RoleManager, models.RoleOwner}
+
+// userInviteURL returns the signed link a new user follows to set their password. The signature
+// covers the user's password, which is empty until the invite is accepted, so the link only works once
+func userInviteURL(u models.User, expires time.Time) string {
+	exp := strconv.FormatInt(expires.Unix(), 10)
+	sig := helpers.SignValue(fmt.Sprintf("invite:%d:%s:%s", u.ID, exp, u.Password))
+
+	return fmt.Sprintf("/user/invite?id=%d&exp=%s&sig=%s", u.ID, exp, url.QueryEscape(sig))
+}
+
+// sendUserInvite emails u a link to set their password and log in
+func (m *Repository) sendUserInvite(u models.User) {
+	expires := time.Now().Add(userInviteTTL)
+
+	htmlMessage := fmt.Sprintf(`
+	<strong>You've been invited</strong><br>
+	Dear %s, <br>
+	You've been given %s access to the Fort Smythe Bed and Breakfast admin.<br>
+	<a href="%s%s">Set your password</a>. This link expires on %s.`,
+		u.FirstName,
+		u.Role(),
+		m.App.BaseURL,
+		userInviteURL(u, expires),
+		expires.Format("2006-01-02 15:04"))
+
+	m.App.MailChan <- models.MailData{
+		To:       u.Email,
+		From:     "me@here.com",
+		Subject:  "Your admin account",
+		Content:  htmlMessage,
+		Template: "basic.html",
+	}
+}
+
+// AdminUsers lists the staff users
+func (m *Repository) AdminUsers(w http.ResponseWriter, r *http.Request) {
+	users, err := m.DB.AllUsers()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["users"] = users
+
+	render.Template(w, r, "admin-users.page.tmpl", &models.TemplateData{
+		Data: data,
+	})
+}
+
+// AdminShowUser shows the form to invite a user, or to edit one when the id isn't "new"
+func (m *Repository) AdminShowUser(w http.ResponseWriter, r *http.Request) {
+	src := chi.URLParam(r, "id")
+
+	stringMap := make(map[string]string)
+	stringMap["id"] = src
+
+	data := make(map[string]interface{})
+	data["roles"] = userRoles
+
+	values := url.Values{}
+	values.Set("access_level", strconv.Itoa(int(models.RoleFrontDesk)))
+
+	if src != "new" {
+		id, err := strconv.Atoi(src)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		u, err := m.DB.GetUserByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["user"] = u
+
+		values.Set("first_name", u.FirstName)
+		values.Set("last_name", u.LastName)
+		values.Set("email", u.Email)
+		values.Set("access_level", strconv.Itoa(int(u.Role())))
+	}
+
+	render.Template(w, r, "admin-user-show.page.tmpl", &models.TemplateData{
+		StringMap: stringMap,
+		Data:      data,
+		Form:      forms.New(values),
+	})
+}
+
+// AdminPostUser invites a user, or updates one when the id isn't "new"
+func (m *Repository) AdminPostUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	src := chi.URLParam(r, "id")
+
+	data := make(map[string]interface{})
+	data["roles"] = userRoles
+
+	u := models.User{Active: 1}
+	if src != "new" {
+		id, err := strconv.Atoi(src)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+
+		u, err = m.DB.GetUserByID(id)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		data["user"] = u
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("first_name", "last_name", "email", "access_level")
+	form.IsEmail("email")
+
+	u.FirstName = strings.TrimSpace(r.Form.Get("first_name"))
+	u.LastName = strings.TrimSpace(r.Form.Get("last_name"))
+	u.Email = strings.TrimSpace(r.Form.Get("email"))
+
+	u.AccessLevel, err = strconv.Atoi(r.Form.Get("access_level"))
+	if err != nil || models.RoleFromAccessLevel(u.AccessLevel) != models.Role(u.AccessLevel) {
+		form.Errors.Add("access_level", "Choose a role")
+	}
+
+	if form.Valid() {
+		if u.ID == 0 {
+			u.ID, err = m.DB.InsertUser(u)
+		} else {
+			err = m.DB.UpdateUser(u)
+		}
+		if errors.Is(err, repository.ErrEmailTaken) {
+			form.Errors.Add("email", "Another user already has this email")
+		}
+		if errors.Is(err, repository.ErrLastOwner) {
+			form.Errors.Add("access_level", "There must be at least one active owner")
+		}
+	}
+
+	if !form.Valid() {
+		stringMap := make(map[string]string)
+		stringMap["id"] = src
+
+		render.Template(w, r, "admin-user-show.page.tmpl", &models.TemplateData{
+			StringMap: stringMap,
+			Data:      data,
+			Form:      form,
+		})
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if src == "new" {
+		m.sendUserInvite(u)
+		m.App.Session.Put(r.Context(), "flash", "Invite sent to "+u.Email)
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "User saved")
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminActivateUser deactivates a user, or reactivates them when active is 1. Deactivated users
+// are logged out and can't log back in
+func (m *Repository) AdminActivateUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+	active, _ := strconv.Atoi(r.Form.Get("active"))
+
+	if active == 0 && id == helpers.UserID(r) {
+		m.App.Session.Put(r.Context(), "error", "You can't deactivate yourself")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	u, err := m.DB.GetUserByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	u.Active = active
+	err = m.DB.UpdateUser(u)
+	if errors.Is(err, repository.ErrLastOwner) {
+		m.App.Session.Put(r.Context(), "error", "There must be at least one active owner")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if active == 1 {
+		m.App.Session.Put(r.Context(), "flash", "User reactivated")
+	} else {
+		m.App.Session.Put(r.Context(), "flash", "User deactivated")
+	}
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminUnlockUser lets a user locked out by failed logins log in again straight away
+func (m *Repository) AdminUnlockUser(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	err = m.DB.UnlockUser(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "User unlocked")
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// AdminResendInvite sends a fresh invite to a user who hasn't set their password yet
+func (m *Repository) AdminResendInvite(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	id, _ := strconv.Atoi(r.Form.Get("id"))
+
+	u, err := m.DB.GetUserByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	if !u.Invited() {
+		m.App.Session.Put(r.Context(), "error", "This user has already accepted their invite")
+		http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+		return
+	}
+
+	m.sendUserInvite(u)
+
+	m.App.Session.Put(r.Context(), "flash", "Invite sent to "+u.Email)
+	http.Redirect(w, r, "/admin/users", http.StatusSeeOther)
+}
+
+// invitedUser returns the user an invite link was sent to, or a message saying why the link
+// can't be used
+func (m *Repository) invitedUser(r *http.Request) (models.User, string) {
+	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
+	exp := r.URL.Query().Get("exp")
+	sig := r.URL.Query().Get("sig")
+
+	u, err := m.DB.GetUserByID(id)
+	if err != nil || u.Active == 0 {
+		return u, "Invalid invite link"
+	}
+
+	if !helpers.ValidSignature(fmt.Sprintf("invite:%d:%s:%s", u.ID, exp, u.Password), sig) {
+		return u, "Invalid invite link"
+	}
+
+	expires, _ := strconv.ParseInt(exp, 10, 64)
+	if time.Now().Unix() > expires {
+		return u, "This invite link has expired, ask the owner for a new one"
+	}
+
+	return u, ""
+}
+
+// UserInvite shows an invited user the form to set their password
+func (m *Repository) UserInvite(w http.ResponseWriter, r *http.Request) {
+	u, msg := m.invitedUser(r)
+	if msg != "" {
+		m.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	data := make(map[string]interface{})
+	data["user"] = u
+
+	render.Template(w, r, "user-invite.page.tmpl", &models.TemplateData{
+		Data: data,
+		Form: forms.New(nil),
+	})
+}
+
+// PostUserInvite sets an invited user's password, after which they can log in
+func (m *Repository) PostUserInvite(w http.ResponseWriter, r *http.Request) {
+	u, msg := m.invitedUser(r)
+	if msg != "" {
+		m.App.Session.Put(r.Context(), "error", msg)
+		http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+		return
+	}
+
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	form := forms.New(r.PostForm)
+	form.Required("password", "confirm_password")
+	form.MinLength("password", 8)
+	if r.Form.Get("password") != r.Form.Get("confirm_password") {
+		form.Errors.Add("confirm_password", "Passwords don't match")
+	}
+
+	if !form.Valid() {
+		data := make(map[string]interface{})
+		data["user"] = u
+
+		render.Template(w, r, "user-invite.page.tmpl", &models.TemplateData{
+			Data: data,
+			Form: form,
+		})
+		return
+	}
+
+	err = m.DB.SetUserPassword(u.ID, r.Form.Get("password"))
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+
+	m.App.Session.Put(r.Context(), "flash", "Your password is set, you can now log in")
+	http.Redirect(w, r, "/user/login", http.StatusSeeOther)
+}
+
+// renderTwoFactorSettings shows u's two-factor login settings, with recoveryCodes listed if they
+// have just been made, since they're only shown once
+func (m *Repository) renderTwoFactorSettings(w http.ResponseWriter, r *http.Request, u models.User, form *forms.Form, recoveryCodes []string) {
+	data := make(map[string]interface{})
+	data["user"] = u
+	data["recovery_codes"] = recoveryCodes
+
+	intMap := make(map[string]int)
+	if m.App.TwoFactorRoles[u.Role()] {
+		intMap["required"] = 1
+	}
+
+	stringMap := make(map[string]string)
+
+	if u.TOTPEnabled == 1 {
+		count, err := m.DB.CountRecoveryCodes(u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		intMap["recovery_codes_left"] = count
+	} else {
+		// the secret waits in the session until the user confirms a code from it
+		secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+		if secret == "" {
+			var err error
+			secret, err = totp.NewSecret()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
+			m.App.Session.Put(r.Context(), "totp_setup_secret", secret)
+		}
 
-	data := make(map[string]interface{})
-	data["reservation"] = res
+		stringMap["secret"] = secret
+		stringMap["uri"] = totp.URI(totpIssuer, u.Email, secret)
+	}
 
-	render.Template(w, r, "admin-reservations-show.page.tmpl", &models.TemplateData{
+	render.Template(w, r, "admin-two-factor.page.tmpl", &models.TemplateData{
 		Data:      data,
+		IntMap:    intMap,
 		StringMap: stringMap,
-		Form:      forms.New(nil),
+		Form:      form,
 	})
 }
 
-func (m *Repository) AdminPostShowReservation(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+// newRecoveryCodes returns a fresh set of recovery codes and the hashes that are stored for them
+func newRecoveryCodes() ([]string, []string, error) {
+	codes, err := totp.NewRecoveryCodes(10)
 	if err != nil {
-		m.App.ErrorLog.Println(err)
-		return
+		return nil, nil, err
 	}
 
-	params := r.URL.Query()
+	hashes := make([]string, len(codes))
+	for i, c := range codes {
+		hashes[i] = helpers.HashToken(totp.NormalizeRecoveryCode(c))
+	}
 
-	id, _ := strconv.Atoi(params.Get("id"))
+	return codes, hashes, nil
+}
+
+// AdminTwoFactor shows the logged in user how to set up two-factor login, or lets them manage it
+func (m *Repository) AdminTwoFactor(w http.ResponseWriter, r *http.Request) {
+	u, err := m.DB.GetUserByID(helpers.UserID(r))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	currentMonth := params.Get("m")
+	m.renderTwoFactorSettings(w, r, u, forms.New(nil), nil)
+}
+
+// AdminPostTwoFactor turns the logged in user's two-factor login on or off, or makes them new
+// recovery codes. Each needs a current code
+func (m *Repository) AdminPostTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	currentYear := params.Get("y")
+	u, err := m.DB.GetUserByID(helpers.UserID(r))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	explodedUrl := strings.Split(r.RequestURI, "/")
-	src := explodedUrl[3]
-	stringMap := make(map[string]string)
-	stringMap["src"] = src
-
-	stringMap["month"] = currentMonth
-	stringMap["year"] = currentYear
-
-	res, err := m.DB.GetReservationByID(id)
-	if err != nil {
-		helpers.ServerError(w, err)
+	form := forms.New(r.PostForm)
+	form.Required("code")
+	if !form.Valid() {
+		m.renderTwoFactorSettings(w, r, u, form, nil)
 		return
 	}
 
-	res.FirstName = r.Form.Get("first_name")
-	res.LastName = r.Form.Get("last_name")
-	res.Email = r.Form.Get("email")
-	res.Phone = r.Form.Get("phone")
+	code := r.Form.Get("code")
 
-	err = m.DB.UpdateReservation(res)
-	if err != nil {
-		helpers.ServerError(w, err)
-		return
-	}
+	switch r.Form.Get("action") {
+	case "enable":
+		secret := m.App.Session.GetString(r.Context(), "totp_setup_secret")
+		step, ok := totp.Validate(secret, code, time.Now())
+		if secret == "" || !ok {
+			form.Errors.Add("code", "That code isn't right. Check the time on your phone is correct and try again")
+			m.renderTwoFactorSettings(w, r, u, form, nil)
+			return
+		}
 
-	month := r.Form.Get("month")
-	year := r.Form.Get("year")
+		codes, hashes, err := newRecoveryCodes()
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes saved")
-	if year != "" {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?m=%s&y=%s", month, year), http.StatusSeeOther)
-	} else {
-		http.Redirect(w, r, fmt.Sprintf("/admin/reservations-%s", src), http.StatusSeeOther)
-	}
-}
+		err = m.DB.EnableTOTP(u.ID, secret, hashes)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-func (m *Repository) AdminReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	now := time.Now()
+		// the code just given can't be used again to log in
+		_, err = m.DB.UseTOTPStep(u.ID, step)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-	if r.URL.Query().Get("y") != "" {
-		year, _ := strconv.Atoi(r.URL.Query().Get("y"))
-		month, _ := strconv.Atoi(r.URL.Query().Get("m"))
-		now = time.Date(year, time.Month(month), 1, 0, 0, 0, 0, time.UTC)
-	}
+		m.App.Session.Remove(r.Context(), "totp_setup_secret")
 
-	data := make(map[string]interface{})
-	data["now"] = now
+		u.TOTPSecret = secret
+		u.TOTPEnabled = 1
+		m.renderTwoFactorSettings(w, r, u, forms.New(nil), codes)
 
-	next := now.AddDate(0, 1, 0)
-	last := now.AddDate(0, -1, 0)
+	case "disable", "recovery-codes":
+		if u.TOTPEnabled == 0 {
+			http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+			return
+		}
 
-	nextMonth := next.Format("01")
-	nextMonthYear := next.Format("2006")
+		ok, _, err := m.checkSecondFactor(u, code)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
+		if !ok {
+			form.Errors.Add("code", "That code isn't right, please try again")
+			m.renderTwoFactorSettings(w, r, u, form, nil)
+			return
+		}
 
-	lastMonth := last.Format("01")
-	lastMonthYear := last.Format("2006")
+		if r.Form.Get("action") == "recovery-codes" {
+			codes, hashes, err := newRecoveryCodes()
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
 
-	stringMap := make(map[string]string)
-	stringMap["next_month"] = nextMonth
-	stringMap["next_month_year"] = nextMonthYear
+			err = m.DB.ReplaceRecoveryCodes(u.ID, hashes)
+			if err != nil {
+				helpers.ServerError(w, err)
+				return
+			}
 
-	stringMap["last_month"] = lastMonth
-	stringMap["last_month_year"] = lastMonthYear
+			m.renderTwoFactorSettings(w, r, u, forms.New(nil), codes)
+			return
+		}
 
-	stringMap["this_month"] = now.Format("01")
-	stringMap["this_month_year"] = now.Format("2006")
+		if m.App.TwoFactorRoles[u.Role()] {
+			m.App.Session.Put(r.Context(), "error", fmt.Sprintf("Two-factor login is required for the %s role", u.Role()))
+			http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
+			return
+		}
 
-	// get the first and last days of the month
-	currentYear, currentMonth, _ := now.Date()
-	currentLocation := now.Location()
-	firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-	lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+		err = m.DB.DisableTOTP(u.ID)
+		if err != nil {
+			helpers.ServerError(w, err)
+			return
+		}
 
-	intMap := make(map[string]int)
-	intMap["days_in_month"] = lastOfMonth.Day()
+		m.App.Session.Put(r.Context(), "flash", "Two-factor login turned off")
+		http.Redirect(w, r, "/admin/two-factor", http.StatusSeeOther)
 
-	rooms, err := m.DB.AllRooms()
+	default:
+		helpers.ClientError(w, http.StatusBadRequest)
+	}
+}
+
+// AdminResetTwoFactor turns off two-factor login for a user who has lost their authenticator app
+// and recovery codes. If their role requires it they set it up again at their next login
+func (m *Repository) AdminResetTwoFactor(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	data["rooms"] = rooms
-
-	for _, room := range rooms {
-		reservationMap := make(map[string]int) // holds reservation ids
-		blockMap := make(map[string]int)       // holds restriction ids
+	id, _ := strconv.Atoi(r.Form.Get("id"))
 
-		for d := firstOfMonth; !d.After(lastOfMonth); d = d.AddDate(0, 0, 1) {
-			reservationMap[d.Format("2006-01-2")] = 0
-			blockMap[d.Format("2006-01-2")] = 0
-		}
+	err = m.DB.DisableTOTP(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-		// get all room restrictions for the current room
-		roomRestrictions, err := m.DB.GetRoomRestrictionsForRoomByDate(room.ID, firstOfMonth, lastOfMonth)
-		if err != nil {
-			helpers.ServerError(w, err)
-			return
-		}
+	m.App.Session.Put(r.Context(), "flash", "Two-factor login reset")
+	http.Redirect(w, r, fmt.Sprintf("/admin/users/%d", id), http.StatusSeeOther)
+}
 
-		for _, roomRestriction := range roomRestrictions {
-			if roomRestriction.ReservationID > 0 {
-				// a reservation
-				for d := roomRestriction.StartDate; !d.After(roomRestriction.EndDate); d = d.AddDate(0, 0, 1) {
-					reservationMap[d.Format("2006-01-2")] = roomRestriction.ReservationID
-				}
-			} else {
-				// a block
-				blockMap[roomRestriction.StartDate.Format("2006-01-2")] = roomRestriction.ID
-			}
-		}
+// apiTokenDays are the lifetimes, in days, a new API token can be given
+var apiTokenDays = []int{7, 30, 90, 365}
 
-		data[fmt.Sprintf("reservation_map_%d", room.ID)] = reservationMap
-		data[fmt.Sprintf("block_map_%d", room.ID)] = blockMap
+// renderAPITokens shows the API tokens the user can see, which for owners is everyone's, with
+// newToken shown if one has just been made, since it's only shown once
+func (m *Repository) renderAPITokens(w http.ResponseWriter, r *http.Request, form *forms.Form, newToken string) {
+	var tokens []models.APIToken
+	var err error
 
-		m.App.Session.Put(r.Context(), fmt.Sprintf("block_map_%d", room.ID), blockMap)
+	if helpers.UserRole(r) >= models.RoleOwner {
+		tokens, err = m.DB.AllAPITokens()
+	} else {
+		tokens, err = m.DB.UserAPITokens(helpers.UserID(r))
+	}
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	render.Template(w, r, "admin-reservations-calendar.page.tmpl", &models.TemplateData{
+	data := make(map[string]interface{})
+	data["tokens"] = tokens
+	data["days"] = apiTokenDays
+
+	stringMap := make(map[string]string)
+	stringMap["token"] = newToken
+	stringMap["base_url"] = m.App.BaseURL
+
+	intMap := make(map[string]int)
+	intMap["user_id"] = helpers.UserID(r)
+
+	render.Template(w, r, "admin-api-tokens.page.tmpl", &models.TemplateData{
+		Data:      data,
 		StringMap: stringMap,
 		IntMap:    intMap,
-		Data:      data,
+		Form:      form,
 	})
 }
 
-func (m *Repository) AdminProcessReservation(w http.ResponseWriter, r *http.Request) {
+// newAPITokenForm returns the empty form for making an API token, defaulting to a read token for a month
+func newAPITokenForm() *forms.Form {
+	return forms.New(url.Values{
+		"scope":        {models.APIScopeRead},
+		"expires_days": {"30"},
+	})
+}
 
-	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	month := r.URL.Query().Get("m")
-	year := r.URL.Query().Get("y")
-	src := chi.URLParam(r, "src")
+// AdminAPITokens lists the user's API tokens, with the form to make a new one
+func (m *Repository) AdminAPITokens(w http.ResponseWriter, r *http.Request) {
+	m.renderAPITokens(w, r, newAPITokenForm(), "")
+}
 
-	_ = m.DB.UpdateProcessedForReservation(id, 1)
-	m.App.Session.Put(r.Context(), "flash", "Reservation marked as processed")
+// AdminPostAPIToken makes a new API token for the logged in user
+func (m *Repository) AdminPostAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
 
-	if src == "cal" {
-		redirectUrl := fmt.Sprintf("/admin/reservations-calendar?m=%s&y=%s", month, year)
-		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
-	} else {
-		redirectUrl := fmt.Sprintf("/admin/reservations-%s", src)
-		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+	form := forms.New(r.PostForm)
+	form.Required("name", "scope", "expires_days")
+
+	scope := r.Form.Get("scope")
+	if scope != models.APIScopeRead && scope != models.APIScopeWrite {
+		form.Errors.Add("scope", "Choose read or write")
 	}
-}
 
-func (m *Repository) AdminDeleteReservation(w http.ResponseWriter, r *http.Request) {
-	id, _ := strconv.Atoi(r.URL.Query().Get("id"))
-	month := r.URL.Query().Get("m")
-	year := r.URL.Query().Get("y")
-	src := chi.URLParam(r, "src")
+	days, _ := strconv.Atoi(r.Form.Get("expires_days"))
+	validDays := false
+	for _, d := range apiTokenDays {
+		if days == d {
+			validDays = true
+		}
+	}
+	if !validDays {
+		form.Errors.Add("expires_days", "Choose when the token expires")
+	}
 
-	_ = m.DB.DeleteReservation(id)
-	m.App.Session.Put(r.Context(), "flash", "Reservation succesfully deleted")
+	if !form.Valid() {
+		m.renderAPITokens(w, r, form, "")
+		return
+	}
 
-	if src == "cal" {
-		redirectUrl := fmt.Sprintf("/admin/reservations-calendar?m=%s&y=%s", month, year)
-		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
-	} else {
-		redirectUrl := fmt.Sprintf("/admin/reservations-%s", src)
-		http.Redirect(w, r, redirectUrl, http.StatusSeeOther)
+	token, err := helpers.NewToken()
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
+	}
+	// the prefix lets secret scanners spot tokens that end up somewhere they shouldn't
+	token = "bk_" + token
+
+	t := models.APIToken{
+		UserID:    helpers.UserID(r),
+		Name:      r.Form.Get("name"),
+		Scope:     scope,
+		ExpiresAt: time.Now().AddDate(0, 0, days),
 	}
-}
 
-// AdminPostReservationsCalendar handls post of reservation calendar
-func (m *Repository) AdminPostReservationsCalendar(w http.ResponseWriter, r *http.Request) {
-	err := r.ParseForm()
+	_, err = m.DB.InsertAPIToken(t, helpers.HashToken(token))
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	year, _ := strconv.Atoi(r.Form.Get("y"))
-	month, _ := strconv.Atoi(r.Form.Get("m"))
+	m.renderAPITokens(w, r, newAPITokenForm(), token)
+}
 
-	rooms, err := m.DB.AllRooms()
+// AdminRevokeAPIToken deletes an API token so it can't be used again. Users can revoke their own
+// tokens, and owners anyone's
+func (m *Repository) AdminRevokeAPIToken(w http.ResponseWriter, r *http.Request) {
+	err := r.ParseForm()
 	if err != nil {
 		helpers.ServerError(w, err)
 		return
 	}
 
-	form := forms.New(r.PostForm)
-
-	for _, room := range rooms {
-		// Get the block map from the session, and loop through map
-		// if we have an entry that DOES NOT EXIST in our posted data,
-		// and if restriction id > 0 then we need to remove that block
-		currentMap := m.App.Session.Get(r.Context(), fmt.Sprintf("block_map_%d", room.ID)).(map[string]int)
+	id, _ := strconv.Atoi(r.Form.Get("id"))
 
-		for name, value := range currentMap {
-			// ok will be false if that value is not in the map
-			if val, ok := currentMap[name]; ok {
-				// only pay attention to values > 0, and are not in the form post
-				if val > 0 {
-					if !form.Has(fmt.Sprintf("remove_block_%d_%s", room.ID, name)) {
-						// delete the room restriction by id
-						err := m.DB.DeleteBlockByID(value)
-						if err != nil {
-							m.App.ErrorLog.Println(err)
-						}
-					}
-				}
-			}
-		}
+	t, err := m.DB.GetAPITokenByID(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	// now handle new blocks
-	for name, _ := range r.PostForm {
-		if strings.HasPrefix(name, "add_block") {
-			exploded := strings.Split(name, "_")
-			roomID, _ := strconv.Atoi(exploded[2])
-			startDate, _ := time.Parse("2006-01-2", exploded[3])
+	if t.UserID != helpers.UserID(r) && helpers.UserRole(r) < models.RoleOwner {
+		m.Forbidden(w, r)
+		return
+	}
 
-			// insert a new block
-			err := m.DB.InsertBlockForRoomRestriction(roomID, startDate)
-			if err != nil {
-				m.App.ErrorLog.Println(err)
-			}
-		}
+	err = m.DB.DeleteAPIToken(id)
+	if err != nil {
+		helpers.ServerError(w, err)
+		return
 	}
 
-	m.App.Session.Put(r.Context(), "flash", "Changes Saved")
-	http.Redirect(w, r, fmt.Sprintf("/admin/reservations-calendar?y=%d&m=%d", year, month), http.StatusSeeOther)
+	m.App.Session.Put(r.Context(), "flash", "API token revoked")
+	http.Redirect(w, r, "/admin/api-tokens", http.StatusSeeOther)
 }
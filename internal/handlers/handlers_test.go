@@ -1,10 +1,14 @@
 package handlers
 
 import (
+	"bytes"
 	"context"
 	"encoding/json"
 	"fmt"
+	"image"
+	"image/png"
 	"log"
+	"mime/multipart"
 	"net/http"
 	"net/http/httptest"
 	"net/url"
@@ -14,8 +18,12 @@ import (
 	"testing"
 	"time"
 
+	"github.com/go-chi/chi"
 	"github.com/sindrishtepani/bookings/internal/driver"
+	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/media"
 	"github.com/sindrishtepani/bookings/internal/models"
+	"github.com/sindrishtepani/bookings/internal/totp"
 )
 
 var theTests = []struct {
@@ -28,17 +36,87 @@ var theTests = []struct {
 	{"about", "/about", "GET", http.StatusOK},
 	{"gq", "/generals-quarters", "GET", http.StatusOK},
 	{"ms", "/majors-suite", "GET", http.StatusOK},
+	{"rooms", "/rooms", "GET", http.StatusOK},
+	{"room", "/rooms/generals-quarters", "GET", http.StatusOK},
+	{"missing room", "/rooms/no-such-room", "GET", http.StatusNotFound},
+	{"room fails", "/rooms/fail", "GET", http.StatusInternalServerError},
 	{"sa", "/search", "GET", http.StatusOK},
 	{"contact", "/contact", "GET", http.StatusOK},
+	{"waitlist", "/waitlist?start=2050-01-01&end=2050-01-02", "GET", http.StatusOK},
+	{"lookup", "/reservations/lookup", "GET", http.StatusOK},
+	{"guest res not looked up", "/reservations/ABCDEFGHJK", "GET", http.StatusOK},
 	{"non-existent", "/green/eggs/and/ham", "GET", http.StatusNotFound},
 	{"login", "/user/login", "GET", http.StatusOK},
 	{"logout", "/user/logout", "GET", http.StatusOK},
 	{"dashboard", "/admin/dashboard", "GET", http.StatusOK},
 	{"new res", "/admin/reservations-new", "GET", http.StatusOK},
 	{"all res", "/admin/reservations-all", "GET", http.StatusOK},
+	{"filtered res", "/admin/reservations-all?q=smith&room=1&from=2050-01-01&to=2050-01-31&processed=1&sort=name&dir=asc&page=2", "GET", http.StatusOK},
+	{"failing res search", "/admin/reservations-new?q=fail", "GET", http.StatusInternalServerError},
 	{"show res", "/admin/reservations/new/?id=1", "GET", http.StatusOK},
+	{"show group", "/admin/groups/1", "GET", http.StatusOK},
+	{"show missing group", "/admin/groups/5", "GET", http.StatusInternalServerError},
 	{"show res cal", "/admin/reservations-calendar", "GET", http.StatusOK},
+	{"admin make reservation", "/admin/make-reservation", "GET", http.StatusOK},
+	{"admin make reservation for a room", "/admin/make-reservation?room=1&start=2050-01-01&end=2050-01-03", "GET", http.StatusOK},
 	{"show res cal with params", "/admin/reservations-calendar?y=2020&m=1", "GET", http.StatusOK},
+	{"show res cal fails", "/admin/reservations-calendar?y=1999&m=1", "GET", http.StatusInternalServerError},
+	{"show timeline", "/admin/reservations-timeline", "GET", http.StatusOK},
+	{"show timeline week", "/admin/reservations-timeline?view=week&date=2050-01-05", "GET", http.StatusOK},
+	{"show timeline quarter", "/admin/reservations-timeline?view=quarter&date=2050-05-05", "GET", http.StatusOK},
+	{"show timeline fails", "/admin/reservations-timeline?date=1999-01-05", "GET", http.StatusInternalServerError},
+	{"new block", "/admin/blocks/new", "GET", http.StatusOK},
+	{"new block for a day", "/admin/blocks/new?room=1&start=2050-01-01", "GET", http.StatusOK},
+	{"edit block", "/admin/blocks/1", "GET", http.StatusOK},
+	{"edit missing block", "/admin/blocks/5", "GET", http.StatusInternalServerError},
+	{"delete block", "/admin/delete-block?id=1", "POST", http.StatusOK},
+	{"delete missing block", "/admin/delete-block?id=5", "POST", http.StatusInternalServerError},
+	{"rates", "/admin/rates", "GET", http.StatusOK},
+	{"delete rate", "/admin/delete-rate?id=1", "POST", http.StatusOK},
+	{"stay rules", "/admin/stay-rules", "GET", http.StatusOK},
+	{"delete stay rule", "/admin/delete-stay-rule?id=1", "POST", http.StatusOK},
+	{"rooms", "/admin/rooms", "GET", http.StatusOK},
+	{"new room", "/admin/rooms/new", "GET", http.StatusOK},
+	{"edit room", "/admin/rooms/1", "GET", http.StatusOK},
+	{"edit missing room", "/admin/rooms/5", "GET", http.StatusInternalServerError},
+	{"archive room", "/admin/archive-room?id=1&archived=1", "POST", http.StatusOK},
+	{"restore room", "/admin/archive-room?id=2&archived=0", "POST", http.StatusOK},
+	{"delete room", "/admin/delete-room?id=2", "POST", http.StatusOK},
+	{"delete room in use", "/admin/delete-room?id=1", "POST", http.StatusOK},
+	{"delete room fails", "/admin/delete-room?id=5", "POST", http.StatusInternalServerError},
+	{"room photos", "/admin/rooms/1/photos", "GET", http.StatusOK},
+	{"missing room photos", "/admin/rooms/5/photos", "GET", http.StatusInternalServerError},
+	{"delete room photo", "/admin/delete-room-photo?id=1", "POST", http.StatusOK},
+	{"delete missing room photo", "/admin/delete-room-photo?id=5", "POST", http.StatusInternalServerError},
+	{"missing photo", "/photos/thumb/missing.jpg", "GET", http.StatusNotFound},
+	{"photo in unknown size", "/photos/huge/abc.jpg", "GET", http.StatusNotFound},
+	{"users", "/admin/users", "GET", http.StatusOK},
+	{"new user", "/admin/users/new", "GET", http.StatusOK},
+	{"edit user", "/admin/users/2", "GET", http.StatusOK},
+	{"edit missing user", "/admin/users/5", "GET", http.StatusInternalServerError},
+	{"deactivate user", "/admin/activate-user?id=2&active=0", "POST", http.StatusOK},
+	{"deactivate last owner", "/admin/activate-user?id=1&active=0", "POST", http.StatusOK},
+	{"deactivate missing user", "/admin/activate-user?id=5&active=0", "POST", http.StatusInternalServerError},
+	{"resend invite", "/admin/resend-invite?id=2", "POST", http.StatusOK},
+	{"resend accepted invite", "/admin/resend-invite?id=1", "POST", http.StatusOK},
+	{"resend invite to missing user", "/admin/resend-invite?id=5", "POST", http.StatusInternalServerError},
+	{"forged invite", "/user/invite?id=2&exp=9999999999&sig=forged", "GET", http.StatusOK},
+	{"forgot password", "/user/forgot-password", "GET", http.StatusOK},
+	{"guest register", "/account/register?next=/make-reservation", "GET", http.StatusOK},
+	{"guest login", "/account/login", "GET", http.StatusOK},
+	{"guest logout", "/account/logout", "GET", http.StatusOK},
+	{"guest bookings logged out", "/account/bookings", "GET", http.StatusOK},
+	{"reset password", "/user/reset-password?token=valid-token", "GET", http.StatusOK},
+	{"reset password with used token", "/user/reset-password?token=used-token", "GET", http.StatusOK},
+	{"two factor without a login", "/user/two-factor", "GET", http.StatusOK},
+	{"two factor settings", "/admin/two-factor", "GET", http.StatusOK},
+	{"reset two factor", "/admin/reset-two-factor?id=1", "POST", http.StatusOK},
+	{"reset two factor fails", "/admin/reset-two-factor?id=5", "POST", http.StatusInternalServerError},
+	{"unlock user", "/admin/unlock-user?id=2", "POST", http.StatusOK},
+	{"unlock missing user", "/admin/unlock-user?id=5", "POST", http.StatusInternalServerError},
+	{"api tokens", "/admin/api-tokens", "GET", http.StatusOK},
+	{"revoke someone else's api token", "/admin/revoke-api-token?id=1", "POST", http.StatusForbidden},
+	{"revoke missing api token", "/admin/revoke-api-token?id=9", "POST", http.StatusInternalServerError},
 }
 
 // TestHandlers tests all routes that don't require extra tests (gets)
@@ -48,7 +126,16 @@ func TestHandlers(t *testing.T) {
 	defer ts.Close()
 
 	for _, e := range theTests {
-		resp, err := ts.Client().Get(ts.URL + e.url)
+		var resp *http.Response
+		var err error
+		if e.method == "POST" {
+			// post the query as the form, the way the admin's buttons do
+			path, query, _ := strings.Cut(e.url, "?")
+			values, _ := url.ParseQuery(query)
+			resp, err = ts.Client().PostForm(ts.URL+path, values)
+		} else {
+			resp, err = ts.Client().Get(ts.URL + e.url)
+		}
 		if err != nil {
 			t.Log(err)
 			t.Fatal(err)
@@ -64,10 +151,24 @@ func TestHandlers(t *testing.T) {
 var reservationTests = []struct {
 	name               string
 	reservation        models.Reservation
+	guestID            int
 	expectedStatusCode int
 	expectedLocation   string
 	expectedHTML       string
 }{
+	{
+		name: "prefilled-from-guest-account",
+		reservation: models.Reservation{
+			RoomID: 1,
+			Room: models.Room{
+				ID:       1,
+				RoomName: "General's Quarters",
+			},
+		},
+		guestID:            1,
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `value="john@smith.com"`,
+	},
 	{
 		name: "reservation-in-session",
 		reservation: models.Reservation{
@@ -113,6 +214,9 @@ func TestReservation(t *testing.T) {
 		if e.reservation.RoomID > 0 {
 			session.Put(ctx, "reservation", e.reservation)
 		}
+		if e.guestID > 0 {
+			session.Put(ctx, "guest_id", e.guestID)
+		}
 
 		handler := http.HandlerFunc(Repo.Reservation)
 		handler.ServeHTTP(rr, req)
@@ -142,6 +246,7 @@ func TestReservation(t *testing.T) {
 // postReservationTests is the test data for hte PostReservation handler test
 var postReservationTests = []struct {
 	name                 string
+	adults               int
 	postedData           url.Values
 	expectedResponseCode int
 	expectedLocation     string
@@ -214,6 +319,67 @@ var postReservationTests = []struct {
 		expectedHTML:         "",
 		expectedLocation:     "/",
 	},
+	{
+		name: "pricing-fails",
+		postedData: url.Values{
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"98"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/",
+	},
+	{
+		name: "room-taken-meanwhile",
+		postedData: url.Values{
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"3"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search",
+	},
+	{
+		name: "broken-stay-rule",
+		postedData: url.Values{
+			"start_date": {"2045-07-01"},
+			"end_date":   {"2045-07-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search",
+	},
+	{
+		name:   "party-too-big-for-room",
+		adults: 3,
+		postedData: url.Values{
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-02"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedHTML:         "",
+		expectedLocation:     "/search",
+	},
 }
 
 // TestPostReservation tests the PostReservation handler
@@ -246,6 +412,7 @@ func TestPostReservation(t *testing.T) {
 			Email:     e.postedData.Get("email"),
 			Phone:     e.postedData.Get("phone"),
 			RoomID:    roomID,
+			Adults:    e.adults,
 		}
 		app.Session.Put(req.Context(), "reservation", testReservation)
 
@@ -322,6 +489,49 @@ var testAvailabilityJSONData = []struct {
 		expectedOK:      false,
 		expectedMessage: "Error querying database",
 	},
+	{
+		name: "room too small for guests",
+		postedData: url.Values{
+			"start":    {"2040-01-01"},
+			"end":      {"2040-01-02"},
+			"room_id":  {"1"},
+			"adults":   {"2"},
+			"children": {"3"},
+		},
+		expectedOK:      false,
+		expectedMessage: "This room sleeps up to 2 adults and 2 children",
+	},
+	{
+		name: "invalid number of guests",
+		postedData: url.Values{
+			"start":   {"2040-01-01"},
+			"end":     {"2040-01-02"},
+			"room_id": {"1"},
+			"adults":  {"0"},
+		},
+		expectedOK:      false,
+		expectedMessage: "Invalid number of guests",
+	},
+	{
+		name: "stay too short for stay rule",
+		postedData: url.Values{
+			"start":   {"2045-07-01"},
+			"end":     {"2045-07-02"},
+			"room_id": {"1"},
+		},
+		expectedOK:      false,
+		expectedMessage: "Sorry, stays arriving on Sat, Jul 1 must be at least 3 nights",
+	},
+	{
+		name: "stay rules query fails",
+		postedData: url.Values{
+			"start":   {"2061-07-01"},
+			"end":     {"2061-07-02"},
+			"room_id": {"1"},
+		},
+		expectedOK:      false,
+		expectedMessage: "Error querying database",
+	},
 }
 
 // TestAvailabilityJSON tests the AvailabilityJSON handler
@@ -352,6 +562,10 @@ func TestAvailabilityJSON(t *testing.T) {
 		if j.OK != e.expectedOK {
 			t.Errorf("%s: expected %v but got %v", e.name, e.expectedOK, j.OK)
 		}
+
+		if e.expectedMessage != "" && j.Message != e.expectedMessage {
+			t.Errorf("%s: expected message %q but got %q", e.name, e.expectedMessage, j.Message)
+		}
 	}
 }
 
@@ -409,6 +623,80 @@ var testPostAvailabilityData = []struct {
 		},
 		expectedStatusCode: http.StatusSeeOther,
 	},
+	{
+		name: "rooms sleep the party between them",
+		postedData: url.Values{
+			"start":    {"2040-01-01"},
+			"end":      {"2040-01-02"},
+			"adults":   {"4"},
+			"children": {"3"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "no rooms sleep that many guests",
+		postedData: url.Values{
+			"start":    {"2040-01-01"},
+			"end":      {"2040-01-02"},
+			"adults":   {"5"},
+			"children": {"3"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/waitlist?start=2040-01-01&end=2040-01-02&adults=5&children=3",
+	},
+	{
+		name: "invalid number of guests",
+		postedData: url.Values{
+			"start":  {"2040-01-01"},
+			"end":    {"2040-01-02"},
+			"adults": {"many"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name: "rejected by stay rule",
+		postedData: url.Values{
+			"start": {"2045-07-01"},
+			"end":   {"2045-07-02"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "allowed by stay rule",
+		postedData: url.Values{
+			"start": {"2045-07-01"},
+			"end":   {"2045-07-05"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "departure before arrival",
+		postedData: url.Values{
+			"start": {"2040-01-02"},
+			"end":   {"2040-01-01"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "arrival in the past",
+		postedData: url.Values{
+			"start": {"2020-01-01"},
+			"end":   {"2020-01-02"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "stay too long",
+		postedData: url.Values{
+			"start": {"2040-01-01"},
+			"end":   {"2041-01-01"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
 }
 
 // TestPostAvailability tests the PostAvailabilityHandler
@@ -431,6 +719,13 @@ func TestPostAvailability(t *testing.T) {
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("%s gave wrong status code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
 		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
 	}
 }
 
@@ -493,58 +788,53 @@ func TestReservationSummary(t *testing.T) {
 	}
 }
 
-// chooseRoomTests is the data for ChooseRoom handler tests, /choose-room/{id}
-var chooseRoomTests = []struct {
+// postReservationLookupTests is the data for the PostReservationLookup handler test
+var postReservationLookupTests = []struct {
 	name               string
-	reservation        models.Reservation
-	url                string
+	postedData         url.Values
 	expectedStatusCode int
 	expectedLocation   string
+	expectedHTML       string
 }{
 	{
-		name: "reservation-in-session",
-		reservation: models.Reservation{
-			RoomID: 1,
-			Room: models.Room{
-				ID:       1,
-				RoomName: "General's Quarters",
-			},
+		name: "valid-code-and-email",
+		postedData: url.Values{
+			"code":  {"abcdefghjk"},
+			"email": {"john@smith.com"},
 		},
-		url:                "/choose-room/1",
 		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/make-reservation",
+		expectedLocation:   "/reservations/ABCDEFGHJK",
 	},
 	{
-		name:               "reservation-not-in-session",
-		reservation:        models.Reservation{},
-		url:                "/choose-room/1",
+		name: "wrong-email",
+		postedData: url.Values{
+			"code":  {"ABCDEFGHJK"},
+			"email": {"jack@nimble.com"},
+		},
 		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/",
+		expectedLocation:   "/reservations/lookup?code=ABCDEFGHJK",
 	},
 	{
-		name:               "malformed-url",
-		reservation:        models.Reservation{},
-		url:                "/choose-room/fish",
-		expectedStatusCode: http.StatusSeeOther,
-		expectedLocation:   "/",
+		name: "invalid-data",
+		postedData: url.Values{
+			"code":  {""},
+			"email": {"j"},
+		},
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       `action="/reservations/lookup"`,
 	},
 }
 
-// TestChooseRoom tests the ChooseRoom handler
-func TestChooseRoom(t *testing.T) {
-	for _, e := range chooseRoomTests {
-		req, _ := http.NewRequest("GET", e.url, nil)
+// TestPostReservationLookup tests the PostReservationLookup handler
+func TestPostReservationLookup(t *testing.T) {
+	for _, e := range postReservationLookupTests {
+		req, _ := http.NewRequest("POST", "/reservations/lookup", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-		// set the RequestURI on the request so that we can grab the ID from the URL
-		req.RequestURI = e.url
-
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
-		if e.reservation.RoomID > 0 {
-			session.Put(ctx, "reservation", e.reservation)
-		}
 
-		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler := http.HandlerFunc(Repo.PostReservationLookup)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
@@ -557,216 +847,3090 @@ func TestChooseRoom(t *testing.T) {
 				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
 			}
 		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
 	}
 }
 
-// bookRoomTests is the data for the BookRoom handler tests
-var bookRoomTests = []struct {
+// guestReservationTests is the data for the GuestReservation handler test
+var guestReservationTests = []struct {
 	name               string
-	url                string
+	lookupCode         string
+	lookupEmail        string
+	guestID            int
 	expectedStatusCode int
+	expectedHTML       string
 }{
 	{
-		name:               "database-works",
-		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=1",
+		name:               "booked-from-account",
+		guestID:            1,
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "Back to My Bookings",
+	},
+	{
+		name:               "booked-from-another-account",
+		guestID:            2,
 		expectedStatusCode: http.StatusSeeOther,
 	},
 	{
-		name:               "database-fails",
-		url:                "/book-room?s=2040-01-01&e=2040-01-02&id=4",
+		name:               "looked-up",
+		lookupCode:         "ABCDEFGHJK",
+		lookupEmail:        "john@smith.com",
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "ABCDEFGHJK",
+	},
+	{
+		name:               "not-looked-up",
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "lookup-no-longer-matches",
+		lookupCode:         "ABCDEFGHJK",
+		lookupEmail:        "jack@nimble.com",
 		expectedStatusCode: http.StatusSeeOther,
 	},
 }
 
-// TestBookRoom tests the BookRoom handler
-func TestBookRoom(t *testing.T) {
-	reservation := models.Reservation{
-		RoomID: 1,
-		Room: models.Room{
-			ID:       1,
-			RoomName: "General's Quarters",
-		},
-	}
-
-	for _, e := range bookRoomTests {
-		req, _ := http.NewRequest("GET", e.url, nil)
+// TestGuestReservation tests the GuestReservation handler
+func TestGuestReservation(t *testing.T) {
+	for _, e := range guestReservationTests {
+		req, _ := http.NewRequest("GET", "/reservations/ABCDEFGHJK", nil)
 		ctx := getCtx(req)
-		req = req.WithContext(ctx)
 
-		rr := httptest.NewRecorder()
-		session.Put(ctx, "reservation", reservation)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("code", "ABCDEFGHJK")
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
 
-		handler := http.HandlerFunc(Repo.BookRoom)
+		if e.lookupCode != "" {
+			session.Put(ctx, "lookup_code", e.lookupCode)
+			session.Put(ctx, "lookup_email", e.lookupEmail)
+		}
+		if e.guestID > 0 {
+			session.Put(ctx, "guest_id", e.guestID)
+		}
 
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GuestReservation)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
-			t.Errorf("%s failed: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
 		}
 	}
 }
 
-// loginTests is the data for the Login handler tests
-var loginTests = []struct {
+// guestCancelReservationTests is the data for the guest cancellation handler tests
+var guestCancelReservationTests = []struct {
 	name               string
-	email              string
+	code               string
+	signed             bool
 	expectedStatusCode int
-	expectedHTML       string
 	expectedLocation   string
+	expectedHTML       string
 }{
 	{
-		"valid-credentials",
-		"me@here.ca",
-		http.StatusSeeOther,
-		"",
-		"/",
+		name:               "valid-link",
+		code:               "ABCDEFGHJK",
+		signed:             true,
+		expectedStatusCode: http.StatusOK,
+		expectedHTML:       "full refund",
 	},
 	{
-		"invalid-credentials",
-		"jack@nimble.com",
-		http.StatusSeeOther,
-		"",
-		"/user/login",
+		name:               "bad-signature",
+		code:               "ABCDEFGHJK",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/lookup",
 	},
 	{
-		"invalid-data",
-		"j",
-		http.StatusOK,
+		name:               "unknown-reservation",
+		code:               "ZZZZZZZZZZ",
+		signed:             true,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/lookup",
+	},
+	{
+		name:               "stay-already-started",
+		code:               "PASTSTAYXX",
+		signed:             true,
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/reservations/lookup?code=PASTSTAYXX",
+	},
+}
+
+// TestGuestCancelReservation tests the GuestCancelReservation and PostGuestCancelReservation handlers
+func TestGuestCancelReservation(t *testing.T) {
+	for _, e := range guestCancelReservationTests {
+		for _, method := range []string{"GET", "POST"} {
+			signature := "nope"
+			if e.signed {
+				signature = helpers.SignValue("cancel:" + e.code)
+			}
+
+			url := fmt.Sprintf("/reservations/%s/cancel?sig=%s", e.code, signature)
+			req, _ := http.NewRequest(method, url, strings.NewReader("reason=plans+changed"))
+			req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			ctx := getCtx(req)
+
+			rctx := chi.NewRouteContext()
+			rctx.URLParams.Add("code", e.code)
+			ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+			req = req.WithContext(ctx)
+
+			rr := httptest.NewRecorder()
+			handler := http.HandlerFunc(Repo.GuestCancelReservation)
+			if method == "POST" {
+				handler = http.HandlerFunc(Repo.PostGuestCancelReservation)
+			}
+			handler.ServeHTTP(rr, req)
+
+			expectedStatusCode := e.expectedStatusCode
+			expectedLocation := e.expectedLocation
+			if method == "POST" && e.expectedStatusCode == http.StatusOK {
+				expectedStatusCode = http.StatusSeeOther
+				expectedLocation = fmt.Sprintf("/reservations/%s", e.code)
+			}
+
+			if rr.Code != expectedStatusCode {
+				t.Errorf("%s %s returned wrong response code: got %d, wanted %d", method, e.name, rr.Code, expectedStatusCode)
+			}
+
+			if expectedLocation != "" {
+				actualLoc, _ := rr.Result().Location()
+				if actualLoc.String() != expectedLocation {
+					t.Errorf("failed %s %s: expected location %s, but got location %s", method, e.name, expectedLocation, actualLoc.String())
+				}
+			}
+
+			if method == "GET" && e.expectedHTML != "" {
+				html := rr.Body.String()
+				if !strings.Contains(html, e.expectedHTML) {
+					t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+				}
+			}
+		}
+	}
+}
+
+// chooseRoomTests is the data for ChooseRoom handler tests, /choose-room/{id}
+var chooseRoomTests = []struct {
+	name               string
+	reservation        models.Reservation
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "reservation-in-session",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+			Room: models.Room{
+				ID:       1,
+				RoomName: "General's Quarters",
+			},
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/make-reservation",
+	},
+	{
+		name:               "reservation-not-in-session",
+		reservation:        models.Reservation{},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:               "malformed-url",
+		reservation:        models.Reservation{},
+		url:                "/choose-room/fish",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name: "room-taken-before-hold",
+		reservation: models.Reservation{
+			RoomID:    1,
+			HoldID:    7,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/3",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "hold-fails",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/99",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name: "archived-room",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/97",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "broken-stay-rule",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2045, 7, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2045, 7, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "party-too-big-for-room",
+		reservation: models.Reservation{
+			RoomID:    1,
+			Adults:    3,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "departure-before-arrival",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "arrival-in-the-past",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2020, 1, 2, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name: "stay-too-long",
+		reservation: models.Reservation{
+			RoomID:    1,
+			StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+			EndDate:   time.Date(2052, 1, 1, 0, 0, 0, 0, time.UTC),
+		},
+		url:                "/choose-room/1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+}
+
+// TestChooseRoom tests the ChooseRoom handler
+func TestChooseRoom(t *testing.T) {
+	for _, e := range chooseRoomTests {
+		req, _ := http.NewRequest("POST", e.url, nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		// set the RequestURI on the request so that we can grab the ID from the URL
+		req.RequestURI = e.url
+
+		rr := httptest.NewRecorder()
+		if e.reservation.RoomID > 0 {
+			session.Put(ctx, "reservation", e.reservation)
+		}
+
+		handler := http.HandlerFunc(Repo.ChooseRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedLocation == "/make-reservation" {
+			res, _ := session.Get(ctx, "reservation").(models.Reservation)
+			if res.HoldID == 0 {
+				t.Errorf("failed %s: expected the room to be held", e.name)
+			}
+		}
+	}
+}
+
+// postChooseRoomsTests is the data for the PostChooseRooms handler tests
+var postChooseRoomsTests = []struct {
+	name             string
+	postedData       url.Values
+	expectedLocation string
+}{
+	{
+		name:             "no-rooms",
+		postedData:       url.Values{},
+		expectedLocation: "/search",
+	},
+	{
+		name:             "same-room-twice",
+		postedData:       url.Values{"room_id": {"1", "1"}},
+		expectedLocation: "/search",
+	},
+	{
+		name:             "one-room",
+		postedData:       url.Values{"room_id": {"1"}},
+		expectedLocation: "/make-reservation",
+	},
+	{
+		name:             "several-rooms",
+		postedData:       url.Values{"room_id": {"1", "2"}},
+		expectedLocation: "/make-group-reservation",
+	},
+	{
+		name:             "invalid-room",
+		postedData:       url.Values{"room_id": {"1", "x"}},
+		expectedLocation: "/search",
+	},
+}
+
+// TestPostChooseRooms tests the PostChooseRooms handler
+func TestPostChooseRooms(t *testing.T) {
+	reservation := models.Reservation{
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, e := range postChooseRoomsTests {
+		req, _ := http.NewRequest("POST", "/choose-rooms", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "reservation", reservation)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostChooseRooms)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+// removeFromCartTests is the data for the RemoveFromCart handler tests
+var removeFromCartTests = []struct {
+	name             string
+	cart             []int
+	roomID           string
+	expectedLocation string
+}{
+	{"leaves-group", []int{1, 2, 3}, "3", "/make-group-reservation"},
+	{"leaves-one-room", []int{1, 2}, "2", "/make-reservation"},
+	{"leaves-nothing", []int{1}, "1", "/search"},
+	{"invalid-room", []int{1, 2}, "x", "/"},
+}
+
+// TestRemoveFromCart tests the RemoveFromCart handler
+func TestRemoveFromCart(t *testing.T) {
+	reservation := models.Reservation{
+		StartDate: time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:   time.Date(2050, 1, 2, 0, 0, 0, 0, time.UTC),
+	}
+
+	for _, e := range removeFromCartTests {
+		req, _ := http.NewRequest("POST", "/cart/remove/"+e.roomID, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+
+		session.Put(ctx, "cart", e.cart)
+		session.Put(ctx, "reservation", reservation)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.RemoveFromCart)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+	}
+}
+
+// groupReservationTests is the data for the GroupReservation handler tests
+var groupReservationTests = []struct {
+	name               string
+	cart               []int
+	expectedStatusCode int
+	expectedHTML       string
+}{
+	{"rooms-in-cart", []int{1, 2}, http.StatusOK, `Book All Rooms`},
+	{"empty-cart", nil, http.StatusSeeOther, ""},
+	{"room-not-found", []int{1, 4}, http.StatusSeeOther, ""},
+}
+
+// TestGroupReservation tests the GroupReservation handler
+func TestGroupReservation(t *testing.T) {
+	for _, e := range groupReservationTests {
+		req, _ := http.NewRequest("GET", "/make-group-reservation", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: time.Now().AddDate(0, 1, 0),
+			EndDate:   time.Now().AddDate(0, 1, 2),
+			Adults:    2,
+		})
+		if e.cart != nil {
+			session.Put(ctx, "cart", e.cart)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.GroupReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedHTML != "" && !strings.Contains(rr.Body.String(), e.expectedHTML) {
+			t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+		}
+	}
+}
+
+// TestShareParty tests sharing a party out across the rooms in a cart
+func TestShareParty(t *testing.T) {
+	lines := []models.Reservation{
+		{Room: models.Room{MaxAdults: 2, MaxChildren: 1}},
+		{Room: models.Room{MaxAdults: 2, MaxChildren: 2}},
+	}
+
+	if !shareParty(lines, 3, 3) {
+		t.Fatal("expected 3 adults and 3 children to fit")
+	}
+
+	if lines[0].Adults != 2 || lines[0].Children != 1 || lines[1].Adults != 1 || lines[1].Children != 2 {
+		t.Errorf("expected 2+1 and 1+2 but got %d+%d and %d+%d", lines[0].Adults, lines[0].Children, lines[1].Adults, lines[1].Children)
+	}
+
+	if shareParty(lines, 1, 3) {
+		t.Error("expected a room without an adult to be refused")
+	}
+
+	if shareParty(lines, 3, 5) {
+		t.Error("expected 8 guests not to fit in 7 places")
+	}
+}
+
+// postGroupReservationTests is the data for the PostGroupReservation handler tests
+var postGroupReservationTests = []struct {
+	name                 string
+	cart                 []int
+	adults               int
+	start                time.Time
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{
+		name: "valid-data",
+		cart: []int{1, 2},
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/group-reservation-summary",
+	},
+	{
+		name: "invalid-data",
+		cart: []int{1, 2},
+		postedData: url.Values{
+			"first_name": {"J"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusOK,
+	},
+	{
+		name: "room-taken-meanwhile",
+		cart: []int{1, 2},
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"taken@here.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search",
+	},
+	{
+		name: "database-fails",
+		cart: []int{1, 2},
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"fail@here.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/",
+	},
+	{
+		name: "room-not-found",
+		cart: []int{1, 4},
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/",
+	},
+	{
+		name: "empty-cart",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search",
+	},
+	{
+		name:   "party-too-big-for-rooms",
+		cart:   []int{1, 2},
+		adults: 5,
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search",
+	},
+	{
+		name:   "room-without-an-adult",
+		cart:   []int{1, 2},
+		adults: 1,
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search",
+	},
+	{
+		name:  "broken-stay-rule",
+		cart:  []int{1, 2},
+		start: time.Date(2045, 7, 1, 0, 0, 0, 0, time.UTC),
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search",
+	},
+	{
+		name: "room-gone-before-checkout",
+		cart: []int{1, 3},
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/search",
+	},
+}
+
+// TestPostGroupReservation tests the PostGroupReservation handler
+func TestPostGroupReservation(t *testing.T) {
+	for _, e := range postGroupReservationTests {
+		req, _ := http.NewRequest("POST", "/make-group-reservation", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		adults := e.adults
+		if adults == 0 {
+			adults = 2
+		}
+
+		start := e.start
+		if start.IsZero() {
+			start = time.Now().AddDate(0, 1, 0)
+		}
+
+		session.Put(ctx, "reservation", models.Reservation{
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 2),
+			Adults:    adults,
+		})
+		if e.cart != nil {
+			session.Put(ctx, "cart", e.cart)
+		}
+
+		rr := httptest.NewRecorder()
+		handler := http.HandlerFunc(Repo.PostGroupReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedResponseCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// postWaitlistTests is the data for the PostWaitlist handler tests
+var postWaitlistTests = []struct {
+	name               string
+	postedData         url.Values
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name: "valid-data",
+		postedData: url.Values{
+			"start":      {"2050-01-01"},
+			"end":        {"2050-01-03"},
+			"room_id":    {"1"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name: "missing-name",
+		postedData: url.Values{
+			"start": {"2050-01-01"},
+			"end":   {"2050-01-03"},
+			"email": {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "departure-before-arrival",
+		postedData: url.Values{
+			"start":      {"2050-01-03"},
+			"end":        {"2050-01-01"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedStatusCode: http.StatusOK,
+	},
+	{
+		name: "database-fails",
+		postedData: url.Values{
+			"start":      {"2050-01-01"},
+			"end":        {"2050-01-03"},
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"fail@here.com"},
+		},
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+}
+
+// TestPostWaitlist tests the PostWaitlist handler
+func TestPostWaitlist(t *testing.T) {
+	for _, e := range postWaitlistTests {
+		req, _ := http.NewRequest("POST", "/waitlist", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostWaitlist)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// waitlistBookTests is the data for the WaitlistBook handler tests
+var waitlistBookTests = []struct {
+	name             string
+	entryID          int
+	expires          time.Duration
+	signed           bool
+	expectedLocation string
+}{
+	{"valid-link", 1, time.Hour, true, "/make-reservation"},
+	{"expired-link", 1, -time.Hour, true, "/search"},
+	{"bad-signature", 1, time.Hour, false, "/"},
+	{"missing-entry", 5, time.Hour, true, "/"},
+}
+
+// TestPassOnLapsedWaitlistOffers tests passing a lapsed waitlist offer on to the next guest
+func TestPassOnLapsedWaitlistOffers(t *testing.T) {
+	done := make(chan struct{})
+	go func() {
+		Repo.PassOnLapsedWaitlistOffers()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("expected lapsed offers to be passed on without blocking")
+	}
+}
+
+// TestWaitlistBook tests the WaitlistBook handler
+func TestWaitlistBook(t *testing.T) {
+	for _, e := range waitlistBookTests {
+		link := waitlistBookURL(e.entryID, 1, time.Now().Add(e.expires))
+		if !e.signed {
+			link = fmt.Sprintf("/waitlist/%d/book?room=1&exp=%d&sig=forged", e.entryID, time.Now().Add(e.expires).Unix())
+		}
+
+		req, _ := http.NewRequest("GET", link, nil)
+		ctx := getCtx(req)
+
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", strconv.Itoa(e.entryID))
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.WaitlistBook)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s returned wrong response code: got %d, wanted %d", e.name, rr.Code, http.StatusSeeOther)
+		}
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if e.expectedLocation == "/make-reservation" {
+			res, ok := session.Get(ctx, "reservation").(models.Reservation)
+			if !ok || res.Email != "john@smith.com" || res.RoomID != 1 {
+				t.Errorf("failed %s: expected reservation for the waitlisted guest in session", e.name)
+			}
+		}
+	}
+}
+
+// bookRoomTests is the data for the BookRoom handler tests
+var bookRoomTests = []struct {
+	name               string
+	url                string
+	expectedStatusCode int
+	expectedLocation   string
+}{
+	{
+		name:               "database-works",
+		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "database-fails",
+		url:                "/book-room?s=2040-01-01&e=2040-01-02&id=4",
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "with-guests",
+		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=1&a=2&c=1",
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "invalid-guests",
+		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=1&a=0",
+		expectedStatusCode: http.StatusSeeOther,
+	},
+	{
+		name:               "archived-room",
+		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=97",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name:               "broken-stay-rule",
+		url:                "/book-room?s=2045-07-01&e=2045-07-02&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name:               "party-too-big-for-room",
+		url:                "/book-room?s=2050-01-01&e=2050-01-02&id=1&a=3",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name:               "invalid-start-date",
+		url:                "/book-room?s=invalid&e=2050-01-02&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:               "invalid-end-date",
+		url:                "/book-room?s=2050-01-01&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/",
+	},
+	{
+		name:               "departure-before-arrival",
+		url:                "/book-room?s=2050-01-02&e=2050-01-01&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name:               "arrival-in-the-past",
+		url:                "/book-room?s=2020-01-01&e=2020-01-02&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+	{
+		name:               "stay-too-long",
+		url:                "/book-room?s=2050-01-01&e=2052-01-01&id=1",
+		expectedStatusCode: http.StatusSeeOther,
+		expectedLocation:   "/search",
+	},
+}
+
+// TestBookRoom tests the BookRoom handler
+func TestBookRoom(t *testing.T) {
+	reservation := models.Reservation{
+		RoomID: 1,
+		Room: models.Room{
+			ID:       1,
+			RoomName: "General's Quarters",
+		},
+	}
+
+	for _, e := range bookRoomTests {
+		// the room, dates and guests are posted as a form
+		path, query, _ := strings.Cut(e.url, "?")
+		req, _ := http.NewRequest("POST", path, strings.NewReader(query))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+		rr := httptest.NewRecorder()
+		session.Put(ctx, "reservation", reservation)
+
+		handler := http.HandlerFunc(Repo.BookRoom)
+
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("%s failed: returned wrong response code: got %d, wanted %d", e.name, rr.Code, e.expectedStatusCode)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// loginTests is the data for the Login handler tests
+var loginTests = []struct {
+	name               string
+	email              string
+	expectedStatusCode int
+	expectedHTML       string
+	expectedLocation   string
+}{
+	{
+		"valid-credentials",
+		"me@here.ca",
+		http.StatusSeeOther,
+		"",
+		"/",
+	},
+	{
+		"invalid-credentials",
+		"jack@nimble.com",
+		http.StatusSeeOther,
+		"",
+		"/user/login",
+	},
+	{
+		"invalid-data",
+		"j",
+		http.StatusOK,
 		`action="/user/login"`,
 		"",
 	},
 }
 
-func TestLogin(t *testing.T) {
-	// range through all tests
-	for _, e := range loginTests {
+func TestLogin(t *testing.T) {
+	// range through all tests
+	for _, e := range loginTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "password")
+
+		// create request
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedStatusCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			// get the URL from test
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		// checking for expected values in HTML
+		if e.expectedHTML != "" {
+			// read the response body into a string
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostShowReservationTests = []struct {
+	name                 string
+	url                  string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "valid-data-from-new",
+		url:  "/admin/reservations/new/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-new",
+		expectedHTML:         "",
+	},
+	{
+		name: "valid-data-from-all",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+		expectedHTML:         "",
+	},
+	{
+		name: "valid-data-from-cal",
+		url:  "/admin/reservations/cal/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"phone":      {"555-555-5555"},
+			"year":       {"2022"},
+			"month":      {"01"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?m=01&y=2022",
+		expectedHTML:         "",
+	},
+	{
+		name: "change-stay",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"john@smith.com"},
+			"start_date":   {"2050-01-01"},
+			"end_date":     {"2050-01-03"},
+			"room_id":      {"1"},
+			"notify_guest": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-all",
+	},
+	{
+		name: "departure-before-arrival",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"start_date": {"2050-01-03"},
+			"end_date":   {"2050-01-01"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Departure must be after arrival",
+	},
+	{
+		name: "invalid-arrival",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"start_date": {"soon"},
+			"end_date":   {"2050-01-03"},
+			"room_id":    {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid date",
+	},
+	{
+		name: "room-taken",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-03"},
+			"room_id":    {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The room is already booked or blocked on some of these nights",
+	},
+	{
+		name: "missing-room",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+			"start_date": {"2050-01-01"},
+			"end_date":   {"2050-01-03"},
+			"room_id":    {"5"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name: "invalid-email",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"John"},
+			"last_name":  {"Smith"},
+			"email":      {"john"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid email address",
+	},
+	{
+		name: "database-fails",
+		url:  "/admin/reservations/all/1/show",
+		postedData: url.Values{
+			"first_name": {"fail"},
+			"last_name":  {"Smith"},
+			"email":      {"john@smith.com"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostShowReservation tests the AdminPostReservation handler
+func TestAdminPostShowReservation(t *testing.T) {
+	for _, e := range adminPostShowReservationTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest("POST", "/user/login", strings.NewReader(e.postedData.Encode()))
+		} else {
+			req, _ = http.NewRequest("POST", "/user/login", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.RequestURI = e.url
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			// get the URL from test
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		// checking for expected values in HTML
+		if e.expectedHTML != "" {
+			// read the response body into a string
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostReservationCalendarTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+	blocks               int
+	reservations         int
+}{
+	{
+		name: "cal",
+		postedData: url.Values{
+			"year":  {time.Now().Format("2006")},
+			"month": {time.Now().Format("01")},
+			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+	},
+	{
+		name:                 "cal-blocks",
+		postedData:           url.Values{},
+		expectedResponseCode: http.StatusSeeOther,
+		blocks:               1,
+	},
+	{
+		name:                 "cal-res",
+		postedData:           url.Values{},
+		expectedResponseCode: http.StatusSeeOther,
+		reservations:         1,
+	},
+}
+
+func TestPostReservationCalendar(t *testing.T) {
+	for _, e := range adminPostReservationCalendarTests {
+		var req *http.Request
+		if e.postedData != nil {
+			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
+		} else {
+			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
+		}
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		now := time.Now()
+		bm := make(map[string]int)
+		rm := make(map[string]int)
+
+		currentYear, currentMonth, _ := now.Date()
+		currentLocation := now.Location()
+
+		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
+		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+
+		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
+			rm[d.Format("2006-01-2")] = 0
+			bm[d.Format("2006-01-2")] = 0
+		}
+
+		if e.blocks > 0 {
+			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
+		}
+
+		if e.reservations > 0 {
+			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
+		}
+
+		session.Put(ctx, "block_map_1", bm)
+		session.Put(ctx, "reservation_map_1", rm)
+
+		// set the header
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		// call the handler
+		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+	}
+}
+
+var adminProcessReservationTests = []struct {
+	name                 string
+	queryParams          string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{
+		name:                 "process-reservation",
+		queryParams:          "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+	},
+	{
+		name:                 "process-reservation-back-to-cal",
+		queryParams:          "?y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+	},
+}
+
+func TestAdminProcessReservation(t *testing.T) {
+	for _, e := range adminProcessReservationTests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+var adminDeleteReservationTests = []struct {
+	name                 string
+	queryParams          string
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{
+		name:                 "delete-reservation",
+		queryParams:          "",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+	},
+	{
+		name:                 "delete-reservation-back-to-cal",
+		queryParams:          "?y=2021&m=12",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "",
+	},
+}
+
+func TestAdminDeleteReservation(t *testing.T) {
+	for _, e := range adminDeleteReservationTests {
+		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != http.StatusSeeOther {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+	}
+}
+
+var adminPostRoomRateTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "valid-rate",
+		postedData: url.Values{
+			"room_id":      {"1"},
+			"name":         {"Summer"},
+			"start_date":   {"2050-06-01"},
+			"end_date":     {"2050-08-31"},
+			"nightly_rate": {"180"},
+			"weekend_rate": {"210.50"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rates",
+	},
+	{
+		name: "last-night-before-first",
+		postedData: url.Values{
+			"room_id":      {"1"},
+			"name":         {"Summer"},
+			"start_date":   {"2050-08-31"},
+			"end_date":     {"2050-06-01"},
+			"nightly_rate": {"180"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The last night must not be before the first",
+	},
+	{
+		name: "invalid-price",
+		postedData: url.Values{
+			"room_id":      {"1"},
+			"name":         {"Summer"},
+			"start_date":   {"2050-06-01"},
+			"end_date":     {"2050-08-31"},
+			"nightly_rate": {"lots"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid price",
+	},
+}
+
+// TestAdminPostRoomRate tests the AdminPostRoomRate handler
+func TestAdminPostRoomRate(t *testing.T) {
+	for _, e := range adminPostRoomRateTests {
+		req, _ := http.NewRequest("POST", "/admin/rates", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomRate)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostStayRuleTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "valid-rule",
+		postedData: url.Values{
+			"room_id":             {"1"},
+			"name":                {"Festival"},
+			"start_date":          {"2050-07-01"},
+			"end_date":            {"2050-07-07"},
+			"min_nights":          {"3"},
+			"closed_to_departure": {"0"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/stay-rules",
+	},
+	{
+		name: "restricts-nothing",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"name":       {"Festival"},
+			"start_date": {"2050-07-01"},
+			"end_date":   {"2050-07-07"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The rule doesn&#39;t restrict anything",
+	},
+	{
+		name: "max-below-min",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"name":       {"Festival"},
+			"start_date": {"2050-07-01"},
+			"end_date":   {"2050-07-07"},
+			"min_nights": {"3"},
+			"max_nights": {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The maximum must not be less than the minimum",
+	},
+	{
+		name: "last-day-before-first",
+		postedData: url.Values{
+			"room_id":    {"1"},
+			"name":       {"Festival"},
+			"start_date": {"2050-07-07"},
+			"end_date":   {"2050-07-01"},
+			"min_nights": {"3"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The last day must not be before the first",
+	},
+	{
+		name: "database-fails",
+		postedData: url.Values{
+			"room_id":    {"99"},
+			"name":       {"Festival"},
+			"start_date": {"2050-07-01"},
+			"end_date":   {"2050-07-07"},
+			"min_nights": {"3"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostStayRule tests the AdminPostStayRule handler
+func TestAdminPostStayRule(t *testing.T) {
+	for _, e := range adminPostStayRuleTests {
+		req, _ := http.NewRequest("POST", "/admin/stay-rules", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostStayRule)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+var adminPostRoomTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "new-room",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":    {"Captain's Cabin"},
+			"base_rate":    {"$120.00"},
+			"weekend_rate": {"150"},
+			"max_adults":   {"2"},
+			"max_children": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+	{
+		name: "edit-room",
+		id:   "1",
+		postedData: url.Values{
+			"room_name":  {"General's Quarters"},
+			"base_rate":  {"90"},
+			"max_adults": {"2"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms",
+	},
+	{
+		name: "invalid-price",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":  {"Captain's Cabin"},
+			"base_rate":  {"lots"},
+			"max_adults": {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Invalid price",
+	},
+	{
+		name: "no-adults",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":  {"Captain's Cabin"},
+			"base_rate":  {"120"},
+			"max_adults": {"0"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "A room must sleep at least one adult",
+	},
+	{
+		name: "missing-name",
+		id:   "new",
+		postedData: url.Values{
+			"base_rate":  {"120"},
+			"max_adults": {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field cannot be blank",
+	},
+	{
+		name: "bad-slug",
+		id:   "new",
+		postedData: url.Values{
+			"room_name":  {"Captain's Cabin"},
+			"slug":       {"Captain's Cabin"},
+			"base_rate":  {"120"},
+			"max_adults": {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Use only lowercase letters, numbers and dashes",
+	},
+	{
+		name: "slug-taken",
+		id:   "1",
+		postedData: url.Values{
+			"room_name":  {"Captain's Cabin"},
+			"slug":       {"taken"},
+			"base_rate":  {"120"},
+			"max_adults": {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Another room already uses this address",
+	},
+	{
+		name: "database-fails",
+		id:   "1",
+		postedData: url.Values{
+			"room_name":  {"Failing Room"},
+			"base_rate":  {"120"},
+			"max_adults": {"2"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostRoom tests the AdminPostRoom handler
+func TestAdminPostRoom(t *testing.T) {
+	for _, e := range adminPostRoomTests {
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoom)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+// testPNG returns a small png image
+func testPNG(t *testing.T) []byte {
+	var buf bytes.Buffer
+
+	err := png.Encode(&buf, image.NewRGBA(image.Rect(0, 0, 40, 20)))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+var adminPostRoomPhotoTests = []struct {
+	name                 string
+	roomID               string
+	fileName             string
+	photo                []byte
+	expectedResponseCode int
+	expectedLocation     string
+	expectedError        string
+}{
+	{
+		name:                 "png",
+		roomID:               "1",
+		fileName:             "view.png",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/photos",
+	},
+	{
+		name:                 "missing-room",
+		roomID:               "4",
+		fileName:             "view.png",
+		expectedResponseCode: http.StatusNotFound,
+	},
+	{
+		name:                 "not-an-image",
+		roomID:               "1",
+		fileName:             "notes.txt",
+		photo:                []byte("these are not the photos you are looking for"),
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/photos",
+		expectedError:        "Only JPEG and PNG photos can be uploaded",
+	},
+	{
+		name:                 "no-file",
+		roomID:               "1",
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/photos",
+		expectedError:        "Choose a photo to upload",
+	},
+	{
+		name:                 "database-fails",
+		roomID:               "2",
+		fileName:             "view.png",
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostRoomPhoto tests the AdminPostRoomPhoto handler
+func TestAdminPostRoomPhoto(t *testing.T) {
+	for _, e := range adminPostRoomPhotoTests {
+		var body bytes.Buffer
+		mw := multipart.NewWriter(&body)
+		_ = mw.WriteField("caption", "The view")
+		if e.fileName != "" {
+			photo := e.photo
+			if photo == nil {
+				photo = testPNG(t)
+			}
+			fw, _ := mw.CreateFormFile("photo", e.fileName)
+			_, _ = fw.Write(photo)
+		}
+		mw.Close()
+
+		req, _ := http.NewRequest("POST", "/admin/rooms/"+e.roomID+"/photos", &body)
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.roomID)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", mw.FormDataContentType())
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhoto)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if msg := session.PopString(ctx, "error"); msg != e.expectedError {
+			t.Errorf("failed %s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
+	}
+}
+
+var adminPostRoomPhotoDetailsTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+}{
+	{
+		name:                 "valid",
+		id:                   "1",
+		postedData:           url.Values{"caption": {"The view"}, "sort_order": {"2"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/photos",
+	},
+	{
+		name:                 "invalid-position",
+		id:                   "1",
+		postedData:           url.Values{"caption": {"The view"}, "sort_order": {"first"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/rooms/1/photos",
+	},
+	{
+		name:                 "missing-photo",
+		id:                   "5",
+		postedData:           url.Values{"caption": {"The view"}, "sort_order": {"2"}},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name:                 "database-fails",
+		id:                   "1",
+		postedData:           url.Values{"caption": {"fail"}, "sort_order": {"2"}},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostRoomPhotoDetails tests the AdminPostRoomPhotoDetails handler
+func TestAdminPostRoomPhotoDetails(t *testing.T) {
+	for _, e := range adminPostRoomPhotoDetailsTests {
+		req, _ := http.NewRequest("POST", "/admin/room-photos/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostRoomPhotoDetails)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+	}
+}
+
+// TestPhoto tests that stored photos are served with cache headers
+func TestPhoto(t *testing.T) {
+	fileName, err := media.SavePhoto(app.Storage, testPNG(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ts := httptest.NewTLSServer(getRoutes())
+	defer ts.Close()
+
+	resp, err := ts.Client().Get(ts.URL + "/photos/thumb/" + fileName)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("expected code %d but got %d", http.StatusOK, resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); ct != "image/jpeg" {
+		t.Errorf("expected image/jpeg but got %s", ct)
+	}
+
+	if cc := resp.Header.Get("Cache-Control"); !strings.Contains(cc, "max-age") {
+		t.Errorf("expected a cache header but got %q", cc)
+	}
+}
+
+// TestReservationFilter tests reading the admin reservation list query string
+func TestReservationFilter(t *testing.T) {
+	f := reservationFilter(url.Values{})
+	if f.Sort != "arrival" || !f.Desc || f.Page != 1 || f.Processed != -1 || f.PerPage != reservationsPerPage {
+		t.Errorf("unexpected defaults %+v", f)
+	}
+
+	f = reservationFilter(url.Values{
+		"q":         {" smith "},
+		"room":      {"2"},
+		"from":      {"2050-01-01"},
+		"to":        {"2050-01-31"},
+		"processed": {"0"},
+		"sort":      {"name"},
+		"dir":       {"asc"},
+		"page":      {"3"},
+	})
+	if f.Search != "smith" || f.RoomID != 2 || f.Processed != 0 || f.Sort != "name" || f.Desc || f.Page != 3 {
+		t.Errorf("unexpected filter %+v", f)
+	}
+	if f.From.Format("2006-01-02") != "2050-01-01" || f.To.Format("2006-01-02") != "2050-01-31" {
+		t.Errorf("unexpected dates %s to %s", f.From, f.To)
+	}
+
+	f = reservationFilter(url.Values{"sort": {"id; drop table reservations"}, "page": {"-4"}})
+	if f.Sort != "arrival" || f.Page != 1 {
+		t.Errorf("expected unknown sorts and pages to fall back to the defaults, got %+v", f)
+	}
+}
+
+// TestReservationListRemembersQuery tests that staff return to the list as they left it
+func TestReservationListRemembersQuery(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/reservations-all?page=2&q=smith", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminAllReservations)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK {
+		t.Fatalf("expected code %d but got %d", http.StatusOK, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "page 2 of 3") {
+		t.Error("expected page 2 of 3 to be shown")
+	}
+
+	if back := Repo.reservationListURL(ctx, "all"); back != "/admin/reservations-all?page=2&q=smith" {
+		t.Errorf("expected to return to the second page of the search but got %s", back)
+	}
+
+	if back := Repo.reservationListURL(ctx, "new"); back != "/admin/reservations-new" {
+		t.Errorf("expected the new list without a query but got %s", back)
+	}
+
+	req, _ = http.NewRequest("GET", "/admin/process-reservation/all/?id=1", nil)
+	rctx := chi.NewRouteContext()
+	rctx.URLParams.Add("src", "all")
+	req = req.WithContext(context.WithValue(ctx, chi.RouteCtxKey, rctx))
+	rr = httptest.NewRecorder()
+
+	handler = http.HandlerFunc(Repo.AdminProcessReservation)
+	handler.ServeHTTP(rr, req)
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/admin/reservations-all?page=2&q=smith" {
+		t.Errorf("expected to be sent back to the list as it was but got %s", actualLoc.String())
+	}
+}
+
+// gets the context
+func getCtx(req *http.Request) context.Context {
+	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
+	if err != nil {
+		log.Println(err)
+	}
+	return ctx
+}
+
+var adminPostBlockTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "new-block",
+		id:   "new",
+		postedData: url.Values{
+			"room":   {"1", "2"},
+			"start":  {"2050-02-10"},
+			"end":    {"2050-02-14"},
+			"reason": {"Painting"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2050&m=2",
+	},
+	{
+		name: "no-rooms",
+		id:   "new",
+		postedData: url.Values{
+			"start": {"2050-02-10"},
+			"end":   {"2050-02-14"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Choose at least one room",
+	},
+	{
+		name: "last-night-first",
+		id:   "new",
+		postedData: url.Values{
+			"room":  {"1"},
+			"start": {"2050-02-10"},
+			"end":   {"2050-02-09"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The last night can&#39;t be before the first",
+	},
+	{
+		name: "room-booked",
+		id:   "new",
+		postedData: url.Values{
+			"room":  {"1", "3"},
+			"start": {"2050-02-10"},
+			"end":   {"2050-02-14"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "These dates run into a reservation or another block",
+	},
+	{
+		name: "database-fails",
+		id:   "new",
+		postedData: url.Values{
+			"room":  {"99"},
+			"start": {"2050-02-10"},
+			"end":   {"2050-02-14"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name: "shorten-block",
+		id:   "1",
+		postedData: url.Values{
+			"start":  {"2050-01-02"},
+			"end":    {"2050-01-02"},
+			"reason": {"Painting the bathroom"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations-calendar?y=2050&m=1",
+	},
+	{
+		name: "edit-runs-into-reservation",
+		id:   "1",
+		postedData: url.Values{
+			"start":  {"2050-01-01"},
+			"end":    {"2050-01-10"},
+			"reason": {"taken"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "These dates run into a reservation or another block",
+	},
+	{
+		name: "edit-fails",
+		id:   "1",
+		postedData: url.Values{
+			"start":  {"2050-01-01"},
+			"end":    {"2050-01-03"},
+			"reason": {"fail"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name: "edit-missing-block",
+		id:   "5",
+		postedData: url.Values{
+			"start": {"2050-01-01"},
+			"end":   {"2050-01-03"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostBlock tests the AdminPostBlock handler
+func TestAdminPostBlock(t *testing.T) {
+	for _, e := range adminPostBlockTests {
+		req, _ := http.NewRequest("POST", "/admin/blocks/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostBlock)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+// TestTimelineRange tests the first day and length of each timeline view
+func TestTimelineRange(t *testing.T) {
+	day := time.Date(2050, 5, 19, 15, 4, 0, 0, time.UTC) // a Thursday
+
+	tests := []struct {
+		view          string
+		expectedStart string
+		expectedDays  int
+		expectedPrev  string
+		expectedNext  string
+	}{
+		{"week", "2050-05-16", 7, "2050-05-09", "2050-05-23"},
+		{"month", "2050-05-01", 31, "2050-04-01", "2050-06-01"},
+		{"quarter", "2050-04-01", 91, "2050-01-01", "2050-07-01"},
+	}
+
+	for _, e := range tests {
+		start, days, prev, next := timelineRange(e.view, day)
+
+		if start.Format("2006-01-02") != e.expectedStart || days != e.expectedDays {
+			t.Errorf("%s: expected %s for %d days but got %s for %d days", e.view, e.expectedStart, e.expectedDays, start.Format("2006-01-02"), days)
+		}
+
+		if prev.Format("2006-01-02") != e.expectedPrev || next.Format("2006-01-02") != e.expectedNext {
+			t.Errorf("%s: expected %s and %s either side but got %s and %s", e.view, e.expectedPrev, e.expectedNext, prev.Format("2006-01-02"), next.Format("2006-01-02"))
+		}
+	}
+}
+
+// TestTimelineRows tests that restrictions are clipped to the range and drawn on their room's row
+func TestTimelineRows(t *testing.T) {
+	start := time.Date(2050, 5, 1, 0, 0, 0, 0, time.UTC)
+	rooms := []models.Room{{ID: 1, RoomName: "One"}, {ID: 2, RoomName: "Two"}}
+
+	restrictions := []models.RoomRestriction{
+		{
+			ID:            1,
+			RoomID:        1,
+			ReservationID: 7,
+			StartDate:     start.AddDate(0, 0, 2),
+			EndDate:       start.AddDate(0, 0, 5),
+			Reservation:   models.Reservation{FirstName: "John", LastName: "Smith", Processed: 1},
+		},
+		{
+			ID:            2,
+			RoomID:        2,
+			RestrictionID: models.RestrictionOwnerBlock,
+			StartDate:     start.AddDate(0, 0, -3),
+			EndDate:       start.AddDate(0, 0, 2),
+			Reason:        "Painting",
+		},
+		{
+			ID:            3,
+			RoomID:        2,
+			RestrictionID: models.RestrictionHold,
+			StartDate:     start.AddDate(0, 0, 6),
+			EndDate:       start.AddDate(0, 0, 9),
+		},
+		{
+			ID:        4,
+			RoomID:    5,
+			StartDate: start,
+			EndDate:   start.AddDate(0, 0, 1),
+		},
+	}
+
+	rows := timelineRows(rooms, restrictions, start, 7)
+
+	if len(rows) != 2 || len(rows[0].Bars) != 1 || len(rows[1].Bars) != 2 {
+		t.Fatalf("expected one bar for room 1 and two for room 2 but got %+v", rows)
+	}
+
+	stay := rows[0].Bars[0]
+	if stay.Column != 3 || stay.Span != 3 || stay.Kind != "reservation" || stay.Label != "John Smith" {
+		t.Errorf("unexpected stay bar %+v", stay)
+	}
+	if stay.URL != "/admin/reservations/cal/?id=7&y=2050&m=05" {
+		t.Errorf("unexpected stay link %s", stay.URL)
+	}
+
+	block := rows[1].Bars[0]
+	if block.Column != 1 || block.Span != 2 || block.Kind != "block" || block.Label != "Painting" || block.URL != "/admin/blocks/2" {
+		t.Errorf("expected the block to be clipped to the start of the range but got %+v", block)
+	}
+
+	hold := rows[1].Bars[1]
+	if hold.Column != 7 || hold.Span != 1 || hold.Kind != "hold" {
+		t.Errorf("expected the hold to be clipped to the end of the range but got %+v", hold)
+	}
+}
+
+// makeReservationData returns the posted data of a valid staff booking, changed by set
+func makeReservationData(set url.Values) url.Values {
+	data := url.Values{
+		"room_id":    {"1"},
+		"start_date": {"2050-01-01"},
+		"end_date":   {"2050-01-03"},
+		"adults":     {"2"},
+		"children":   {"0"},
+		"source":     {"phone"},
+		"first_name": {"John"},
+		"last_name":  {"Smith"},
+		"email":      {"john@smith.com"},
+		"send_email": {"1"},
+	}
+
+	for k, v := range set {
+		data[k] = v
+	}
+
+	return data
+}
+
+var adminPostMakeReservationTests = []struct {
+	name                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name:                 "phone-booking",
+		postedData:           makeReservationData(nil),
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/?id=1",
+	},
+	{
+		name:                 "walk-in-without-email",
+		postedData:           makeReservationData(url.Values{"source": {"walk-in"}, "email": {""}}),
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/?id=1",
+	},
+	{
+		name:                 "unknown-source",
+		postedData:           makeReservationData(url.Values{"source": {"web"}}),
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Choose how the guest booked",
+	},
+	{
+		name:                 "departure-before-arrival",
+		postedData:           makeReservationData(url.Values{"end_date": {"2049-12-30"}}),
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Departure must be after arrival",
+	},
+	{
+		name:                 "too-many-guests",
+		postedData:           makeReservationData(url.Values{"adults": {"3"}}),
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This room sleeps up to 2 adults and 2 children",
+	},
+	{
+		name:                 "breaks-stay-rule",
+		postedData:           makeReservationData(url.Values{"start_date": {"2045-07-02"}, "end_date": {"2045-07-03"}}),
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This stay breaks a stay rule",
+	},
+	{
+		name: "override-without-reason",
+		postedData: makeReservationData(url.Values{"start_date": {"2045-07-02"}, "end_date": {"2045-07-03"},
+			"override_rules": {"1"}}),
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Say why the stay rule is being overridden",
+	},
+	{
+		name: "override-with-reason",
+		postedData: makeReservationData(url.Values{"start_date": {"2045-07-02"}, "end_date": {"2045-07-03"},
+			"override_rules": {"1"}, "override_reason": {"Regular guest"}}),
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/reservations/all/?id=1",
+	},
+	{
+		name:                 "room-taken",
+		postedData:           makeReservationData(url.Values{"last_name": {"Taken"}}),
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "The room is already booked or blocked on some of these nights",
+	},
+	{
+		name:                 "missing-room",
+		postedData:           makeReservationData(url.Values{"room_id": {"5"}}),
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name:                 "stay-rules-fail",
+		postedData:           makeReservationData(url.Values{"start_date": {"2061-01-01"}, "end_date": {"2061-01-03"}}),
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostMakeReservation tests the AdminPostMakeReservation handler
+func TestAdminPostMakeReservation(t *testing.T) {
+	for _, e := range adminPostMakeReservationTests {
+		req, _ := http.NewRequest("POST", "/admin/make-reservation", strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostMakeReservation)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+// TestLoginStoresRole tests that logging in remembers the user's role for the session
+func TestLoginStoresRole(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("email", "me@here.ca")
+	postedData.Add("password", "password")
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostShowLogin)
+	handler.ServeHTTP(rr, req)
+
+	if level := session.GetInt(ctx, "access_level"); level != int(models.RoleOwner) {
+		t.Errorf("expected access level %d in the session but got %d", models.RoleOwner, level)
+	}
+}
+
+// TestForbidden tests the page shown to users whose role doesn't allow a request
+func TestForbidden(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/admin/delete-room", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "access_level", int(models.RoleFrontDesk))
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.Forbidden)
+	handler.ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusForbidden {
+		t.Errorf("expected code %d but got %d", http.StatusForbidden, rr.Code)
+	}
+
+	if !strings.Contains(rr.Body.String(), "Front Desk") {
+		t.Error("expected the page to name the user's role")
+	}
+}
+
+// adminPostUserTests is the data for the AdminPostUser handler tests
+var adminPostUserTests = []struct {
+	name                 string
+	id                   string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "invite",
+		id:   "new",
+		postedData: url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"john@smith.com"},
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/users",
+	},
+	{
+		name: "invite-missing-name",
+		id:   "new",
+		postedData: url.Values{
+			"last_name":    {"Smith"},
+			"email":        {"john@smith.com"},
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field cannot be blank",
+	},
+	{
+		name: "invite-unknown-role",
+		id:   "new",
+		postedData: url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"john@smith.com"},
+			"access_level": {"9"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Choose a role",
+	},
+	{
+		name: "invite-email-taken",
+		id:   "new",
+		postedData: url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"taken@here.ca"},
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Another user already has this email",
+	},
+	{
+		name: "invite-fails",
+		id:   "new",
+		postedData: url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"fail@here.ca"},
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name: "edit",
+		id:   "2",
+		postedData: url.Values{
+			"first_name":   {"Jane"},
+			"last_name":    {"Doe"},
+			"email":        {"jane@here.ca"},
+			"access_level": {"2"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/admin/users",
+	},
+	{
+		name: "demote-last-owner",
+		id:   "1",
+		postedData: url.Values{
+			"first_name":   {"Sam"},
+			"last_name":    {"Wade"},
+			"email":        {"me@here.ca"},
+			"access_level": {"2"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "There must be at least one active owner",
+	},
+	{
+		name: "edit-fails",
+		id:   "2",
+		postedData: url.Values{
+			"first_name":   {"fail"},
+			"last_name":    {"Doe"},
+			"email":        {"jane@here.ca"},
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+	{
+		name: "edit-missing-user",
+		id:   "5",
+		postedData: url.Values{
+			"first_name":   {"John"},
+			"last_name":    {"Smith"},
+			"email":        {"john@smith.com"},
+			"access_level": {"1"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestAdminPostUser tests the AdminPostUser handler
+func TestAdminPostUser(t *testing.T) {
+	for _, e := range adminPostUserTests {
+		req, _ := http.NewRequest("POST", "/admin/users/"+e.id, strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		rctx := chi.NewRouteContext()
+		rctx.URLParams.Add("id", e.id)
+		ctx = context.WithValue(ctx, chi.RouteCtxKey, rctx)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.AdminPostUser)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+// TestAdminDeactivateSelf tests that owners can't lock themselves out
+func TestAdminDeactivateSelf(t *testing.T) {
+	postedData := url.Values{"id": {"2"}, "active": {"0"}}
+	req, _ := http.NewRequest("POST", "/admin/activate-user", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	session.Put(ctx, "user_id", 2)
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.AdminActivateUser)
+	handler.ServeHTTP(rr, req)
+
+	if msg := session.GetString(ctx, "error"); msg != "You can't deactivate yourself" {
+		t.Errorf("expected an error in the session but got %q", msg)
+	}
+}
+
+// postUserInviteTests is the data for the PostUserInvite handler tests
+var postUserInviteTests = []struct {
+	name                 string
+	user                 models.User
+	expires              time.Duration
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name:                 "sets-password",
+		user:                 models.User{ID: 2},
+		expires:              time.Hour,
+		postedData:           url.Values{"password": {"correct horse"}, "confirm_password": {"correct horse"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/user/login",
+	},
+	{
+		name:                 "short-password",
+		user:                 models.User{ID: 2},
+		expires:              time.Hour,
+		postedData:           url.Values{"password": {"short"}, "confirm_password": {"short"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field must be at least 8 chars long",
+	},
+	{
+		name:                 "passwords-differ",
+		user:                 models.User{ID: 2},
+		expires:              time.Hour,
+		postedData:           url.Values{"password": {"correct horse"}, "confirm_password": {"battery staple"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Passwords don&#39;t match",
+	},
+	{
+		name:                 "expired",
+		user:                 models.User{ID: 2},
+		expires:              -time.Hour,
+		postedData:           url.Values{"password": {"correct horse"}, "confirm_password": {"correct horse"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/user/login",
+	},
+	{
+		// the link was signed before the password was set, so it no longer matches
+		name:                 "already-accepted",
+		user:                 models.User{ID: 1},
+		expires:              time.Hour,
+		postedData:           url.Values{"password": {"correct horse"}, "confirm_password": {"correct horse"}},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/user/login",
+	},
+}
+
+// TestPostUserInvite tests the PostUserInvite handler
+func TestPostUserInvite(t *testing.T) {
+	for _, e := range postUserInviteTests {
+		req, _ := http.NewRequest("POST", userInviteURL(e.user, time.Now().Add(e.expires)), strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostUserInvite)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+
+		if e.name == "sets-password" {
+			if msg := session.GetString(ctx, "flash"); msg == "" {
+				t.Errorf("failed %s: expected a flash message", e.name)
+			}
+		}
+	}
+}
+
+// postForgotPasswordTests is the data for the PostForgotPassword handler tests
+var postForgotPasswordTests = []struct {
+	name                 string
+	email                string
+	expectedResponseCode int
+}{
+	{"known-email", "me@here.ca", http.StatusSeeOther},
+	{"unknown-email", "nobody@here.ca", http.StatusSeeOther},
+	{"invalid-email", "nobody", http.StatusOK},
+	{"lookup-fails", "fail@here.ca", http.StatusInternalServerError},
+}
+
+// TestPostForgotPassword tests the PostForgotPassword handler
+func TestPostForgotPassword(t *testing.T) {
+	for _, e := range postForgotPasswordTests {
 		postedData := url.Values{}
 		postedData.Add("email", e.email)
-		postedData.Add("password", "password")
 
-		// create request
-		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		req, _ := http.NewRequest("POST", "/user/forgot-password", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
 
-		// set the header
+		handler := http.HandlerFunc(Repo.PostForgotPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		// known and unknown emails get the same reply
+		if rr.Code == http.StatusSeeOther {
+			if msg := session.GetString(ctx, "flash"); !strings.HasPrefix(msg, "If that email belongs to an account") {
+				t.Errorf("failed %s: expected the same flash for every email but got %q", e.name, msg)
+			}
+		}
+	}
+}
+
+// postResetPasswordTests is the data for the PostResetPassword handler tests
+var postResetPasswordTests = []struct {
+	name                 string
+	token                string
+	password             string
+	confirmPassword      string
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{"resets", "valid-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/login", ""},
+	{"short-password", "valid-token", "short", "short", http.StatusOK, "", "This field must be at least 8 chars long"},
+	{"passwords-differ", "valid-token", "correct horse", "battery staple", http.StatusOK, "", "Passwords don&#39;t match"},
+	{"used-token", "used-token", "correct horse", "correct horse", http.StatusSeeOther, "/user/forgot-password", ""},
+	{"reset-fails", "valid-token", "failing password", "failing password", http.StatusInternalServerError, "", ""},
+}
+
+// TestPostResetPassword tests the PostResetPassword handler
+func TestPostResetPassword(t *testing.T) {
+	for _, e := range postResetPasswordTests {
+		postedData := url.Values{}
+		postedData.Add("password", e.password)
+		postedData.Add("confirm_password", e.confirmPassword)
+
+		req, _ := http.NewRequest("POST", "/user/reset-password?token="+e.token, strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.PostShowLogin)
+		handler := http.HandlerFunc(Repo.PostResetPassword)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+// TestLocalPath tests that only paths on this site are accepted as places to return to
+func TestLocalPath(t *testing.T) {
+	tests := []struct {
+		next     string
+		expected string
+	}{
+		{"/make-reservation", "/make-reservation"},
+		{"", "/account/bookings"},
+		{"https://evil.example.com", "/account/bookings"},
+		{"//evil.example.com", "/account/bookings"},
+		{"/\\evil.example.com", "/account/bookings"},
+	}
+
+	for _, e := range tests {
+		if got := localPath(e.next, "/account/bookings"); got != e.expected {
+			t.Errorf("localPath(%q): expected %q but got %q", e.next, e.expected, got)
+		}
+	}
+}
+
+// postGuestRegisterTests is the data for the PostGuestRegister handler tests
+var postGuestRegisterTests = []struct {
+	name                 string
+	next                 string
+	postedData           url.Values
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+}{
+	{
+		name: "registers",
+		postedData: url.Values{
+			"first_name":       {"John"},
+			"last_name":        {"Smith"},
+			"email":            {"john@smith.com"},
+			"password":         {"correct horse"},
+			"confirm_password": {"correct horse"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/account/bookings",
+	},
+	{
+		name: "registers-while-booking",
+		next: "/make-reservation",
+		postedData: url.Values{
+			"first_name":       {"John"},
+			"last_name":        {"Smith"},
+			"email":            {"john@smith.com"},
+			"password":         {"correct horse"},
+			"confirm_password": {"correct horse"},
+		},
+		expectedResponseCode: http.StatusSeeOther,
+		expectedLocation:     "/make-reservation",
+	},
+	{
+		name: "passwords-differ",
+		postedData: url.Values{
+			"first_name":       {"John"},
+			"last_name":        {"Smith"},
+			"email":            {"john@smith.com"},
+			"password":         {"correct horse"},
+			"confirm_password": {"battery staple"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Passwords don&#39;t match",
+	},
+	{
+		name: "email-taken",
+		postedData: url.Values{
+			"first_name":       {"John"},
+			"last_name":        {"Smith"},
+			"email":            {"taken@here.ca"},
+			"password":         {"correct horse"},
+			"confirm_password": {"correct horse"},
+		},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "There is already an account with this email",
+	},
+	{
+		name: "insert-fails",
+		postedData: url.Values{
+			"first_name":       {"John"},
+			"last_name":        {"Smith"},
+			"email":            {"fail@here.ca"},
+			"password":         {"correct horse"},
+			"confirm_password": {"correct horse"},
+		},
+		expectedResponseCode: http.StatusInternalServerError,
+	},
+}
+
+// TestPostGuestRegister tests the PostGuestRegister handler
+func TestPostGuestRegister(t *testing.T) {
+	for _, e := range postGuestRegisterTests {
+		req, _ := http.NewRequest("POST", "/account/register?next="+url.QueryEscape(e.next), strings.NewReader(e.postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestRegister)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
+		if e.expectedLocation != "" {
+			actualLoc, _ := rr.Result().Location()
+			if actualLoc.String() != e.expectedLocation {
+				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+			}
+
+			if session.GetInt(ctx, "guest_id") != 1 {
+				t.Errorf("failed %s: expected the new guest to be logged in", e.name)
+			}
+		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
+	}
+}
+
+// postGuestLoginTests is the data for the PostGuestLogin handler tests
+var postGuestLoginTests = []struct {
+	name             string
+	email            string
+	password         string
+	next             string
+	expectedLocation string
+	expectedGuestID  int
+}{
+	{"logs-in", "john@smith.com", "password", "", "/account/bookings", 1},
+	{"logs-in-while-booking", "john@smith.com", "password", "/make-reservation", "/make-reservation", 1},
+	{"wrong-password", "john@smith.com", "wrong", "", "/account/login?next=", 0},
+	{"unsafe-next", "john@smith.com", "password", "//evil.example.com", "/account/bookings", 1},
+}
+
+// TestPostGuestLogin tests the PostGuestLogin handler
+func TestPostGuestLogin(t *testing.T) {
+	for _, e := range postGuestLoginTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", e.password)
+
+		req, _ := http.NewRequest("POST", "/account/login?next="+url.QueryEscape(e.next), strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostGuestLogin)
+		handler.ServeHTTP(rr, req)
+
+		actualLoc, _ := rr.Result().Location()
+		if actualLoc.String() != e.expectedLocation {
+			t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
+		}
+
+		if id := session.GetInt(ctx, "guest_id"); id != e.expectedGuestID {
+			t.Errorf("failed %s: expected guest %d in the session but got %d", e.name, e.expectedGuestID, id)
+		}
+	}
+}
+
+// TestGuestBookings tests that a guest's stays are split into upcoming and past
+func TestGuestBookings(t *testing.T) {
+	tests := []struct {
+		name               string
+		guestID            int
+		expectedStatusCode int
+		expectedHTML       []string
+	}{
+		{"lists-bookings", 1, http.StatusOK, []string{"UPCOMING22", "PASTSTAY11", "/reservations/UPCOMING22/cancel?sig="}},
+		{"lookup-fails", 2, http.StatusInternalServerError, nil},
+		{"logged-out", 0, http.StatusSeeOther, nil},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/account/bookings", nil)
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		if e.guestID > 0 {
+			session.Put(ctx, "guest_id", e.guestID)
+		}
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.GuestBookings)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedStatusCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedStatusCode, rr.Code)
 		}
 
+		html := rr.Body.String()
+		for _, want := range e.expectedHTML {
+			if !strings.Contains(html, want) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, want)
+			}
+		}
+
+		if strings.Contains(html, "/reservations/PASTSTAY11/cancel") {
+			t.Errorf("failed %s: past stays shouldn't offer a cancel link", e.name)
+		}
+	}
+}
+
+// testTOTPSecret is the two-factor secret the test repository gives user 3
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+// currentTOTPCode returns the code user 3's authenticator app shows now
+func currentTOTPCode() string {
+	code, _ := totp.CodeAt(testTOTPSecret, totp.Step(time.Now()))
+	return code
+}
+
+// TestLoginAsksForSecondFactor tests that users with two-factor login aren't logged in by their password alone
+func TestLoginAsksForSecondFactor(t *testing.T) {
+	postedData := url.Values{}
+	postedData.Add("email", "totp@here.ca")
+	postedData.Add("password", "password")
+
+	req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	rr := httptest.NewRecorder()
+
+	handler := http.HandlerFunc(Repo.PostShowLogin)
+	handler.ServeHTTP(rr, req)
+
+	actualLoc, _ := rr.Result().Location()
+	if actualLoc.String() != "/user/two-factor" {
+		t.Errorf("expected location /user/two-factor but got %s", actualLoc.String())
+	}
+
+	if session.Exists(ctx, "user_id") {
+		t.Error("expected the user not to be logged in yet")
+	}
+
+	if id := session.GetInt(ctx, "pending_user_id"); id != 3 {
+		t.Errorf("expected pending user 3 in the session but got %d", id)
+	}
+}
+
+// postTwoFactorTests is the data for the PostTwoFactor handler tests
+var postTwoFactorTests = []struct {
+	name                 string
+	pendingUserID        int
+	code                 string
+	remember             bool
+	expectedResponseCode int
+	expectedLocation     string
+	expectedHTML         string
+	expectedLogin        bool
+}{
+	{"app-code", 3, currentTOTPCode(), false, http.StatusSeeOther, "/", "", true},
+	{"remember-device", 3, currentTOTPCode(), true, http.StatusSeeOther, "/", "", true},
+	{"recovery-code", 3, "abcde-fghjk", false, http.StatusSeeOther, "/", "", true},
+	{"wrong-code", 3, "000000", false, http.StatusOK, "", "That code isn&#39;t right", false},
+	{"missing-code", 3, "", false, http.StatusOK, "", "This field cannot be blank", false},
+	{"no-pending-login", 0, "123456", false, http.StatusSeeOther, "/user/login", "", false},
+	{"missing-user", 5, "123456", false, http.StatusInternalServerError, "", "", false},
+}
+
+// TestPostTwoFactor tests the PostTwoFactor handler
+func TestPostTwoFactor(t *testing.T) {
+	for _, e := range postTwoFactorTests {
+		postedData := url.Values{}
+		postedData.Add("code", e.code)
+		if e.remember {
+			postedData.Add("remember", "1")
+		}
+
+		req, _ := http.NewRequest("POST", "/user/two-factor", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		if e.pendingUserID != 0 {
+			session.Put(ctx, "pending_user_id", e.pendingUserID)
+		}
+		rr := httptest.NewRecorder()
+
+		handler := http.HandlerFunc(Repo.PostTwoFactor)
+		handler.ServeHTTP(rr, req)
+
+		if rr.Code != e.expectedResponseCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		}
+
 		if e.expectedLocation != "" {
-			// get the URL from test
 			actualLoc, _ := rr.Result().Location()
 			if actualLoc.String() != e.expectedLocation {
 				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
 			}
 		}
 
-		// checking for expected values in HTML
 		if e.expectedHTML != "" {
-			// read the response body into a string
 			html := rr.Body.String()
 			if !strings.Contains(html, e.expectedHTML) {
 				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
 			}
 		}
+
+		if loggedIn := session.Exists(ctx, "user_id"); loggedIn != e.expectedLogin {
+			t.Errorf("failed %s: expected logged in to be %t", e.name, e.expectedLogin)
+		}
+
+		if remembered := len(rr.Result().Cookies()) > 0; remembered != e.remember {
+			t.Errorf("failed %s: expected remember device cookie to be %t", e.name, e.remember)
+		}
 	}
 }
 
-var adminPostShowReservationTests = []struct {
-	name                 string
-	url                  string
-	postedData           url.Values
-	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
-}{
-	{
-		name: "valid-data-from-new",
-		url:  "/admin/reservations/new/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-new",
-		expectedHTML:         "",
-	},
-	{
-		name: "valid-data-from-all",
-		url:  "/admin/reservations/all/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-all",
-		expectedHTML:         "",
-	},
-	{
-		name: "valid-data-from-cal",
-		url:  "/admin/reservations/cal/1/show",
-		postedData: url.Values{
-			"first_name": {"John"},
-			"last_name":  {"Smith"},
-			"email":      {"john@smith.com"},
-			"phone":      {"555-555-5555"},
-			"year":       {"2022"},
-			"month":      {"01"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "/admin/reservations-calendar?m=01&y=2022",
-		expectedHTML:         "",
-	},
-}
+// TestRememberedDevice tests that a remembered device skips the two-factor step until it expires
+func TestRememberedDevice(t *testing.T) {
+	u, _ := Repo.DB.GetUserByID(3)
 
-// TestAdminPostShowReservation tests the AdminPostReservation handler
-func TestAdminPostShowReservation(t *testing.T) {
-	for _, e := range adminPostShowReservationTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/user/login", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/user/login", nil)
+	tests := []struct {
+		name     string
+		user     models.User
+		expires  time.Time
+		expected bool
+	}{
+		{"remembered", u, time.Now().Add(time.Hour), true},
+		{"expired", u, time.Now().Add(-time.Hour), false},
+		{"new-secret", models.User{ID: u.ID, TOTPSecret: "NEWSECRET"}, time.Now().Add(time.Hour), false},
+	}
+
+	for _, e := range tests {
+		req, _ := http.NewRequest("GET", "/user/login", nil)
+		req.AddCookie(&http.Cookie{Name: rememberDeviceCookie(u), Value: rememberDeviceValue(u, e.expires)})
+
+		if got := deviceRemembered(req, e.user); got != e.expected {
+			t.Errorf("failed %s: expected %t but got %t", e.name, e.expected, got)
 		}
+	}
+}
+
+// TestAdminPostTwoFactor tests the AdminPostTwoFactor handler
+func TestAdminPostTwoFactor(t *testing.T) {
+	tests := []struct {
+		name                 string
+		userID               int
+		action               string
+		code                 string
+		expectedResponseCode int
+		expectedHTML         string
+	}{
+		{"enable", 1, "enable", currentTOTPCode(), http.StatusOK, "Save these recovery codes"},
+		{"enable-wrong-code", 1, "enable", "000000", http.StatusOK, "That code isn&#39;t right"},
+		{"enable-fails", 3, "enable", currentTOTPCode(), http.StatusInternalServerError, ""},
+		{"new-recovery-codes", 3, "recovery-codes", "abcde-fghjk", http.StatusOK, "Save these recovery codes"},
+		{"disable-wrong-code", 3, "disable", "000000", http.StatusOK, "That code isn&#39;t right"},
+		{"unknown-action", 3, "explode", "000000", http.StatusBadRequest, ""},
+	}
+
+	for _, e := range tests {
+		postedData := url.Values{}
+		postedData.Add("action", e.action)
+		postedData.Add("code", e.code)
+
+		req, _ := http.NewRequest("POST", "/admin/two-factor", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-		req.RequestURI = e.url
-
-		// set the header
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", e.userID)
+		session.Put(ctx, "totp_setup_secret", testTOTPSecret)
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostShowReservation)
+		handler := http.HandlerFunc(Repo.AdminPostTwoFactor)
 		handler.ServeHTTP(rr, req)
 
 		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
 
-		if e.expectedLocation != "" {
-			// get the URL from test
-			actualLoc, _ := rr.Result().Location()
-			if actualLoc.String() != e.expectedLocation {
-				t.Errorf("failed %s: expected location %s, but got location %s", e.name, e.expectedLocation, actualLoc.String())
-			}
-		}
-
-		// checking for expected values in HTML
 		if e.expectedHTML != "" {
-			// read the response body into a string
 			html := rr.Body.String()
 			if !strings.Contains(html, e.expectedHTML) {
 				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
@@ -775,169 +3939,228 @@ func TestAdminPostShowReservation(t *testing.T) {
 	}
 }
 
-var adminPostReservationCalendarTests = []struct {
-	name                 string
-	postedData           url.Values
-	expectedResponseCode int
-	expectedLocation     string
-	expectedHTML         string
-	blocks               int
-	reservations         int
-}{
-	{
-		name: "cal",
-		postedData: url.Values{
-			"year":  {time.Now().Format("2006")},
-			"month": {time.Now().Format("01")},
-			fmt.Sprintf("add_block_1_%s", time.Now().AddDate(0, 0, 2).Format("2006-01-2")): {"1"},
-		},
-		expectedResponseCode: http.StatusSeeOther,
-	},
-	{
-		name:                 "cal-blocks",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		blocks:               1,
-	},
-	{
-		name:                 "cal-res",
-		postedData:           url.Values{},
-		expectedResponseCode: http.StatusSeeOther,
-		reservations:         1,
-	},
-}
+// TestLoginBackoff tests that the wait between failed logins doubles up to a limit
+func TestLoginBackoff(t *testing.T) {
+	tests := []struct {
+		failures int
+		expected time.Duration
+	}{
+		{0, 0},
+		{loginBackoffAfter - 1, 0},
+		{loginBackoffAfter, time.Second},
+		{loginBackoffAfter + 1, 2 * time.Second},
+		{loginBackoffAfter + 4, 16 * time.Second},
+		{loginBackoffAfter + 12, loginMaxBackoff},
+		{1000, loginMaxBackoff},
+	}
 
-func TestPostReservationCalendar(t *testing.T) {
-	for _, e := range adminPostReservationCalendarTests {
-		var req *http.Request
-		if e.postedData != nil {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", strings.NewReader(e.postedData.Encode()))
-		} else {
-			req, _ = http.NewRequest("POST", "/admin/reservations-calendar", nil)
+	for _, e := range tests {
+		if got := loginBackoff(e.failures); got != e.expected {
+			t.Errorf("%d failures: expected %s but got %s", e.failures, e.expected, got)
 		}
-		ctx := getCtx(req)
-		req = req.WithContext(ctx)
+	}
+}
 
-		now := time.Now()
-		bm := make(map[string]int)
-		rm := make(map[string]int)
+// TestClientIP tests that the client's address is only taken from a header when a proxy is configured
+func TestClientIP(t *testing.T) {
+	req, _ := http.NewRequest("POST", "/user/login", nil)
+	req.RemoteAddr = "10.0.0.2:4000"
+	req.Header.Set("X-Forwarded-For", "192.0.2.7, 198.51.100.1")
 
-		currentYear, currentMonth, _ := now.Date()
-		currentLocation := now.Location()
+	if ip := Repo.clientIP(req); ip != "10.0.0.2" {
+		t.Errorf("expected the connection's address 10.0.0.2 without a proxy but got %s", ip)
+	}
 
-		firstOfMonth := time.Date(currentYear, currentMonth, 1, 0, 0, 0, 0, currentLocation)
-		lastOfMonth := firstOfMonth.AddDate(0, 1, -1)
+	app.ClientIPHeader = "X-Forwarded-For"
+	defer func() { app.ClientIPHeader = "" }()
 
-		for d := firstOfMonth; d.After(lastOfMonth) == false; d = d.AddDate(0, 0, 1) {
-			rm[d.Format("2006-01-2")] = 0
-			bm[d.Format("2006-01-2")] = 0
-		}
+	if ip := Repo.clientIP(req); ip != "198.51.100.1" {
+		t.Errorf("expected the address the proxy added, 198.51.100.1, but got %s", ip)
+	}
 
-		if e.blocks > 0 {
-			bm[firstOfMonth.Format("2006-01-2")] = e.blocks
-		}
+	req.Header.Del("X-Forwarded-For")
+	if ip := Repo.clientIP(req); ip != "10.0.0.2" {
+		t.Errorf("expected the connection's address 10.0.0.2 without the header but got %s", ip)
+	}
+}
 
-		if e.reservations > 0 {
-			rm[lastOfMonth.Format("2006-01-2")] = e.reservations
-		}
+// postShowLoginThrottleTests is the data for the login throttling tests
+var postShowLoginThrottleTests = []struct {
+	name          string
+	email         string
+	remoteAddr    string
+	expectedError string
+	expectedCode  int
+}{
+	{"wrong-password", "unknown@here.ca", "198.51.100.1:4000", "Invalid login credentials", http.StatusSeeOther},
+	{"account-backing-off", "slow@here.ca", "198.51.100.1:4000", "Too many failed logins. Try again in", http.StatusSeeOther},
+	{"ip-backing-off", "unknown@here.ca", "203.0.113.9:4000", "Too many failed logins. Try again in", http.StatusSeeOther},
+	{"locked", "locked@here.ca", "198.51.100.1:4000", lockedMessage, http.StatusSeeOther},
+	{"failures-unavailable", "broken@here.ca", "198.51.100.1:4000", "", http.StatusInternalServerError},
+	{"user-lookup-fails", "fail@here.ca", "198.51.100.1:4000", "", http.StatusInternalServerError},
+}
 
-		session.Put(ctx, "block_map_1", bm)
-		session.Put(ctx, "reservation_map_1", rm)
+// TestPostShowLoginThrottle tests that repeated failed logins have to wait, and locked accounts can't log in
+func TestPostShowLoginThrottle(t *testing.T) {
+	for _, e := range postShowLoginThrottleTests {
+		postedData := url.Values{}
+		postedData.Add("email", e.email)
+		postedData.Add("password", "guess")
 
-		// set the header
+		req, _ := http.NewRequest("POST", "/user/login", strings.NewReader(postedData.Encode()))
+		ctx := getCtx(req)
+		req = req.WithContext(ctx)
 		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		req.RemoteAddr = e.remoteAddr
 		rr := httptest.NewRecorder()
 
-		// call the handler
-		handler := http.HandlerFunc(Repo.AdminPostReservationsCalendar)
+		handler := http.HandlerFunc(Repo.PostShowLogin)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != e.expectedResponseCode {
-			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
+		if rr.Code != e.expectedCode {
+			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedCode, rr.Code)
 		}
 
+		if msg := session.GetString(ctx, "error"); !strings.HasPrefix(msg, e.expectedError) {
+			t.Errorf("failed %s: expected error %q but got %q", e.name, e.expectedError, msg)
+		}
 	}
 }
 
-var adminProcessReservationTests = []struct {
+// TestLoginLockout tests that the failed login that reaches the limit locks the account
+func TestLoginLockout(t *testing.T) {
+	u, _ := Repo.DB.GetUserByEmail("jane@here.ca")
+
+	req, _ := http.NewRequest("POST", "/user/login", nil)
+	req.RemoteAddr = "198.51.100.1:4000"
+
+	err := Repo.loginFailed(req, u.Email, &u)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !u.Locked() {
+		t.Error("expected the account to be locked")
+	}
+
+	if !u.LockedUntil.After(time.Now().Add(loginLockoutFor - time.Minute)) {
+		t.Errorf("expected the account to be locked for %s but it's locked until %s", loginLockoutFor, u.LockedUntil)
+	}
+}
+
+// adminPostAPITokenTests is the data for the AdminPostAPIToken handler tests
+var adminPostAPITokenTests = []struct {
 	name                 string
-	queryParams          string
+	postedData           url.Values
 	expectedResponseCode int
-	expectedLocation     string
+	expectedHTML         string
 }{
 	{
-		name:                 "process-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		name:                 "makes-token",
+		postedData:           url.Values{"name": {"Reports"}, "scope": {"read"}, "expires_days": {"30"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Copy your new token now",
 	},
 	{
-		name:                 "process-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
+		name:                 "missing-name",
+		postedData:           url.Values{"name": {""}, "scope": {"write"}, "expires_days": {"30"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "This field cannot be blank",
+	},
+	{
+		name:                 "unknown-scope",
+		postedData:           url.Values{"name": {"Reports"}, "scope": {"admin"}, "expires_days": {"30"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Choose read or write",
+	},
+	{
+		name:                 "never-expires",
+		postedData:           url.Values{"name": {"Reports"}, "scope": {"read"}, "expires_days": {"0"}},
+		expectedResponseCode: http.StatusOK,
+		expectedHTML:         "Choose when the token expires",
+	},
+	{
+		name:                 "insert-fails",
+		postedData:           url.Values{"name": {"fail"}, "scope": {"read"}, "expires_days": {"30"}},
+		expectedResponseCode: http.StatusInternalServerError,
 	},
 }
 
-func TestAdminProcessReservation(t *testing.T) {
-	for _, e := range adminProcessReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+// TestAdminPostAPIToken tests the AdminPostAPIToken handler
+func TestAdminPostAPIToken(t *testing.T) {
+	for _, e := range adminPostAPITokenTests {
+		req, _ := http.NewRequest("POST", "/admin/api-tokens", strings.NewReader(e.postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", 2)
+		session.Put(ctx, "access_level", int(models.RoleFrontDesk))
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminProcessReservation)
+		handler := http.HandlerFunc(Repo.AdminPostAPIToken)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
+
+		if e.expectedHTML != "" {
+			html := rr.Body.String()
+			if !strings.Contains(html, e.expectedHTML) {
+				t.Errorf("failed %s: expected to find %s but did not", e.name, e.expectedHTML)
+			}
+		}
 	}
 }
 
-var adminDeleteReservationTests = []struct {
-	name                 string
-	queryParams          string
-	expectedResponseCode int
-	expectedLocation     string
-}{
-	{
-		name:                 "delete-reservation",
-		queryParams:          "",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-	{
-		name:                 "delete-reservation-back-to-cal",
-		queryParams:          "?y=2021&m=12",
-		expectedResponseCode: http.StatusSeeOther,
-		expectedLocation:     "",
-	},
-}
+// TestAdminRevokeAPIToken tests that users can revoke their own API tokens, and owners anyone's
+func TestAdminRevokeAPIToken(t *testing.T) {
+	tests := []struct {
+		name                 string
+		userID               int
+		role                 models.Role
+		tokenID              int
+		expectedResponseCode int
+	}{
+		{"own-token", 2, models.RoleFrontDesk, 2, http.StatusSeeOther},
+		{"owner-revokes-any", 1, models.RoleOwner, 2, http.StatusSeeOther},
+		{"someone-elses-token", 2, models.RoleFrontDesk, 1, http.StatusForbidden},
+	}
 
-func TestAdminDeleteReservation(t *testing.T) {
-	for _, e := range adminDeleteReservationTests {
-		req, _ := http.NewRequest("GET", fmt.Sprintf("/admin/process-reservation/cal/1/do%s", e.queryParams), nil)
+	for _, e := range tests {
+		postedData := url.Values{"id": {strconv.Itoa(e.tokenID)}}
+		req, _ := http.NewRequest("POST", "/admin/revoke-api-token", strings.NewReader(postedData.Encode()))
 		ctx := getCtx(req)
 		req = req.WithContext(ctx)
-
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		session.Put(ctx, "user_id", e.userID)
+		session.Put(ctx, "access_level", int(e.role))
 		rr := httptest.NewRecorder()
 
-		handler := http.HandlerFunc(Repo.AdminDeleteReservation)
+		handler := http.HandlerFunc(Repo.AdminRevokeAPIToken)
 		handler.ServeHTTP(rr, req)
 
-		if rr.Code != http.StatusSeeOther {
+		if rr.Code != e.expectedResponseCode {
 			t.Errorf("failed %s: expected code %d, but got %d", e.name, e.expectedResponseCode, rr.Code)
 		}
 	}
 }
 
-// gets the context
-func getCtx(req *http.Request) context.Context {
-	ctx, err := session.Load(req.Context(), req.Header.Get("X-Session"))
-	if err != nil {
-		log.Println(err)
+// TestTokenUser tests that a request made with an API token acts as the token's user
+func TestTokenUser(t *testing.T) {
+	req, _ := http.NewRequest("GET", "/admin/dashboard", nil)
+	ctx := getCtx(req)
+	req = req.WithContext(ctx)
+	session.Put(ctx, "user_id", 1)
+	session.Put(ctx, "access_level", int(models.RoleOwner))
+
+	req = helpers.WithTokenUser(req, models.User{ID: 2, AccessLevel: int(models.RoleReadOnly)})
+
+	if id := helpers.UserID(req); id != 2 {
+		t.Errorf("expected user 2 but got %d", id)
+	}
+
+	if role := helpers.UserRole(req); role != models.RoleReadOnly {
+		t.Errorf("expected role %s but got %s", models.RoleReadOnly, role)
 	}
-	return ctx
 }
@@ -16,6 +16,8 @@ import (
 	"github.com/go-chi/chi/middleware"
 	"github.com/justinas/nosurf"
 	"github.com/sindrishtepani/bookings/internal/config"
+	"github.com/sindrishtepani/bookings/internal/helpers"
+	"github.com/sindrishtepani/bookings/internal/media"
 	"github.com/sindrishtepani/bookings/internal/models"
 	"github.com/sindrishtepani/bookings/internal/render"
 )
@@ -25,10 +27,11 @@ var session *scs.SessionManager
 var pathToTemplates = "./../../templates"
 
 var functions = template.FuncMap{
-	"humanDate":  render.HumanDate,
-	"formatDate": render.FormatDate,
-	"iterate":    render.Iterate,
-	"add":        render.Add,
+	"humanDate":   render.HumanDate,
+	"formatDate":  render.FormatDate,
+	"iterate":     render.Iterate,
+	"add":         render.Add,
+	"formatPrice": render.FormatPrice,
 }
 
 func TestMain(m *testing.M) {
@@ -38,9 +41,12 @@ func TestMain(m *testing.M) {
 	gob.Register(models.Restriction{})
 	gob.Register(models.RoomRestriction{})
 	gob.Register(map[string]int{})
+	gob.Register([]int{})
+	gob.Register(models.ReservationGroup{})
 
 	// change this to true when in production
 	app.InProduction = false
+	app.RememberDeviceFor = 30 * 24 * time.Hour
 
 	infoLog := log.New(os.Stdout, "INFO\t", log.Ldate|log.Ltime)
 	app.InfoLog = infoLog
@@ -55,6 +61,14 @@ func TestMain(m *testing.M) {
 	session.Cookie.Secure = app.InProduction
 
 	app.Session = session
+	app.SigningKey = "test-signing-key"
+	app.HoldTTL = 15 * time.Minute
+
+	uploadDir, err := os.MkdirTemp("", "bookings-uploads")
+	if err != nil {
+		log.Fatalln(err)
+	}
+	app.Storage = media.NewLocalStorage(uploadDir)
 
 	mailChan := make(chan models.MailData)
 	app.MailChan = mailChan
@@ -75,7 +89,11 @@ func TestMain(m *testing.M) {
 	NewHandler(repo)
 
 	render.NewRenderer(&app)
-	os.Exit(m.Run())
+	helpers.NewHelpers(&app)
+
+	code := m.Run()
+	os.RemoveAll(uploadDir)
+	os.Exit(code)
 }
 
 func listenForMail() {
@@ -95,34 +113,104 @@ func getRoutes() http.Handler {
 
 	mux.Get("/", Repo.Home)
 	mux.Get("/about", Repo.About)
-	mux.Get("/generals-quarters", Repo.Generals)
-	mux.Get("/majors-suite", Repo.Majors)
+	mux.Get("/rooms", Repo.Rooms)
+	mux.Get("/rooms/{slug}", Repo.Room)
+	mux.Get("/photos/{size}/{name}", Repo.Photo)
+	mux.Get("/generals-quarters", Repo.LegacyRoom)
+	mux.Get("/majors-suite", Repo.LegacyRoom)
 
 	mux.Get("/search", Repo.Availability)
 	mux.Post("/search", Repo.PostAvailability)
 	mux.Post("/search-json", Repo.AvailabilityJSON)
+	mux.Post("/choose-rooms", Repo.PostChooseRooms)
+	mux.Post("/cart/remove/{id}", Repo.RemoveFromCart)
+
+	mux.Get("/waitlist", Repo.Waitlist)
+	mux.Post("/waitlist", Repo.PostWaitlist)
+	mux.Get("/waitlist/{id}/book", Repo.WaitlistBook)
 
 	mux.Get("/contact", Repo.Contact)
 
 	mux.Get("/make-reservation", Repo.Reservation)
 	mux.Post("/make-reservation", Repo.PostReservation)
 	mux.Get("/reservation-summary", Repo.ReservationSummary)
+	mux.Get("/make-group-reservation", Repo.GroupReservation)
+	mux.Post("/make-group-reservation", Repo.PostGroupReservation)
+	mux.Get("/group-reservation-summary", Repo.GroupReservationSummary)
+	mux.Get("/reservations/lookup", Repo.ReservationLookup)
+	mux.Post("/reservations/lookup", Repo.PostReservationLookup)
+	mux.Get("/reservations/{code}", Repo.GuestReservation)
+	mux.Get("/reservations/{code}/cancel", Repo.GuestCancelReservation)
+	mux.Post("/reservations/{code}/cancel", Repo.PostGuestCancelReservation)
+
+	mux.Get("/account/register", Repo.GuestRegister)
+	mux.Post("/account/register", Repo.PostGuestRegister)
+	mux.Get("/account/login", Repo.GuestLogin)
+	mux.Post("/account/login", Repo.PostGuestLogin)
+	mux.Get("/account/logout", Repo.GuestLogout)
+	mux.Get("/account/bookings", Repo.GuestBookings)
 
 	mux.Get("/user/login", Repo.ShowLogin)
 	mux.Post("/user/login", Repo.PostShowLogin)
+	mux.Get("/user/two-factor", Repo.TwoFactor)
+	mux.Post("/user/two-factor", Repo.PostTwoFactor)
 	mux.Get("/user/logout", Repo.Logout)
+	mux.Get("/user/forgot-password", Repo.ForgotPassword)
+	mux.Post("/user/forgot-password", Repo.PostForgotPassword)
+	mux.Get("/user/reset-password", Repo.ResetPassword)
+	mux.Post("/user/reset-password", Repo.PostResetPassword)
+	mux.Get("/user/invite", Repo.UserInvite)
+	mux.Post("/user/invite", Repo.PostUserInvite)
 
 	mux.Get("/admin/dashboard", Repo.AdminDashboard)
+	mux.Get("/admin/two-factor", Repo.AdminTwoFactor)
+	mux.Post("/admin/two-factor", Repo.AdminPostTwoFactor)
+	mux.Get("/admin/api-tokens", Repo.AdminAPITokens)
+	mux.Post("/admin/api-tokens", Repo.AdminPostAPIToken)
+	mux.Post("/admin/revoke-api-token", Repo.AdminRevokeAPIToken)
 
 	mux.Get("/admin/reservations-new", Repo.AdminNewReservations)
 	mux.Get("/admin/reservations-all", Repo.AdminAllReservations)
+	mux.Get("/admin/make-reservation", Repo.AdminMakeReservation)
+	mux.Post("/admin/make-reservation", Repo.AdminPostMakeReservation)
 	mux.Get("/admin/reservations-calendar", Repo.AdminReservationsCalendar)
 	mux.Post("/admin/reservations-calendar", Repo.AdminPostReservationsCalendar)
+	mux.Get("/admin/reservations-timeline", Repo.AdminReservationsTimeline)
+	mux.Get("/admin/blocks/{id}", Repo.AdminShowBlock)
+	mux.Post("/admin/blocks/{id}", Repo.AdminPostBlock)
+	mux.Post("/admin/delete-block", Repo.AdminDeleteBlock)
 
 	mux.Get("/admin/reservations/{src}/", Repo.AdminShowReservation)
 	mux.Post("/admin/reservations/{src}/", Repo.AdminPostShowReservation)
 	mux.Get("/admin/process-reservation/{src}/", Repo.AdminProcessReservation)
 	mux.Get("/admin/delete-reservation/{src}/", Repo.AdminDeleteReservation)
+	mux.Get("/admin/groups/{id}", Repo.AdminShowGroup)
+
+	mux.Get("/admin/rates", Repo.AdminRoomRates)
+	mux.Post("/admin/rates", Repo.AdminPostRoomRate)
+	mux.Post("/admin/delete-rate", Repo.AdminDeleteRoomRate)
+
+	mux.Get("/admin/rooms", Repo.AdminRooms)
+	mux.Get("/admin/rooms/{id}", Repo.AdminShowRoom)
+	mux.Post("/admin/rooms/{id}", Repo.AdminPostRoom)
+	mux.Get("/admin/rooms/{id}/photos", Repo.AdminRoomPhotos)
+	mux.Post("/admin/rooms/{id}/photos", Repo.AdminPostRoomPhoto)
+	mux.Post("/admin/room-photos/{id}", Repo.AdminPostRoomPhotoDetails)
+	mux.Post("/admin/delete-room-photo", Repo.AdminDeleteRoomPhoto)
+	mux.Post("/admin/archive-room", Repo.AdminArchiveRoom)
+	mux.Post("/admin/delete-room", Repo.AdminDeleteRoom)
+
+	mux.Get("/admin/stay-rules", Repo.AdminStayRules)
+	mux.Post("/admin/stay-rules", Repo.AdminPostStayRule)
+	mux.Post("/admin/delete-stay-rule", Repo.AdminDeleteStayRule)
+
+	mux.Get("/admin/users", Repo.AdminUsers)
+	mux.Get("/admin/users/{id}", Repo.AdminShowUser)
+	mux.Post("/admin/users/{id}", Repo.AdminPostUser)
+	mux.Post("/admin/activate-user", Repo.AdminActivateUser)
+	mux.Post("/admin/resend-invite", Repo.AdminResendInvite)
+	mux.Post("/admin/unlock-user", Repo.AdminUnlockUser)
+	mux.Post("/admin/reset-two-factor", Repo.AdminResetTwoFactor)
 
 	fileServer := http.FileServer(http.Dir("./static/"))
 	mux.Handle("/static/*", http.StripPrefix("/static", fileServer))
@@ -1,11 +1,21 @@
 package helpers
 
 import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"math"
 	"net/http"
 	"runtime/debug"
+	"strconv"
+	"strings"
 
 	"github.com/sindrishtepani/bookings/internal/config"
+	"github.com/sindrishtepani/bookings/internal/models"
 )
 
 var app *config.AppConfig
@@ -29,5 +39,137 @@ func ServerError(w http.ResponseWriter, err error) {
 }
 
 func IsAuthenticated(r *http.Request) bool {
+	if _, ok := TokenUser(r); ok {
+		return true
+	}
+
 	return app.Session.Exists(r.Context(), "user_id")
 }
+
+// UserID returns the id of the logged in user, or of the user whose API token made the request
+func UserID(r *http.Request) int {
+	if u, ok := TokenUser(r); ok {
+		return u.ID
+	}
+
+	return app.Session.GetInt(r.Context(), "user_id")
+}
+
+// UserRole returns the role of the logged in user, or of the user whose API token made the request
+func UserRole(r *http.Request) models.Role {
+	if u, ok := TokenUser(r); ok {
+		return u.Role()
+	}
+
+	return models.RoleFromAccessLevel(app.Session.GetInt(r.Context(), "access_level"))
+}
+
+// tokenUserKey is the context key for the user whose API token made a request
+type tokenUserKey struct{}
+
+// WithTokenUser returns a copy of r made by u with an API token rather than a session. u's access
+// level is the one the token allows
+func WithTokenUser(r *http.Request, u models.User) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), tokenUserKey{}, u))
+}
+
+// TokenUser returns the user whose API token made the request, if it was made with one
+func TokenUser(r *http.Request) (models.User, bool) {
+	u, ok := r.Context().Value(tokenUserKey{}).(models.User)
+	return u, ok
+}
+
+// confirmationCodeAlphabet leaves out characters that are easy to confuse (0/O, 1/I)
+const confirmationCodeAlphabet = "ABCDEFGHJKLMNPQRSTUVWXYZ23456789"
+
+// NewConfirmationCode returns a random, non-sequential code guests use to identify a reservation
+func NewConfirmationCode() (string, error) {
+	b := make([]byte, 10)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	for i := range b {
+		b[i] = confirmationCodeAlphabet[int(b[i])%len(confirmationCodeAlphabet)]
+	}
+
+	return string(b), nil
+}
+
+// NewToken returns a random token that is safe to put in a URL. Only its HashToken hash is stored
+func NewToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+// HashToken returns the hash of token that is stored in place of the token itself
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+
+	return hex.EncodeToString(sum[:])
+}
+
+// PasswordStamp returns the value kept in a user's session to tie it to their current password
+// hash, so changing the password ends every session started with the old one
+func PasswordStamp(hashedPassword string) string {
+	return SignValue("session:" + hashedPassword)
+}
+
+// ValidPasswordStamp reports whether stamp was made by PasswordStamp for hashedPassword
+func ValidPasswordStamp(hashedPassword, stamp string) bool {
+	return ValidSignature("session:"+hashedPassword, stamp)
+}
+
+// SignValue returns a signature for value made with the app's signing key, for use in emailed links
+func SignValue(value string) string {
+	mac := hmac.New(sha256.New, []byte(app.SigningKey))
+	mac.Write([]byte(value))
+
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// ValidSignature reports whether signature was made by SignValue for value
+func ValidSignature(value, signature string) bool {
+	return hmac.Equal([]byte(SignValue(value)), []byte(signature))
+}
+
+// ParsePrice parses a dollar amount such as "120" or "120.50" into cents
+func ParsePrice(s string) (int, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "$")
+	if s == "" {
+		return 0, nil
+	}
+
+	dollars, err := strconv.ParseFloat(s, 64)
+	if err != nil || dollars < 0 {
+		return 0, fmt.Errorf("invalid price %q", s)
+	}
+
+	return int(math.Round(dollars * 100)), nil
+}
+
+// Slugify turns a name such as "General's Quarters" into a url slug such as "generals-quarters"
+func Slugify(s string) string {
+	var b strings.Builder
+	dash := false
+
+	for _, c := range strings.ToLower(s) {
+		switch {
+		case c >= 'a' && c <= 'z', c >= '0' && c <= '9':
+			if dash && b.Len() > 0 {
+				b.WriteByte('-')
+			}
+			b.WriteRune(c)
+			dash = false
+		case c == '\'':
+		default:
+			dash = true
+		}
+	}
+
+	return b.String()
+}
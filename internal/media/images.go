@@ -0,0 +1,176 @@
+package media
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"image"
+	"image/color"
+	"image/draw"
+	"image/jpeg"
+	_ "image/png"
+	"net/http"
+)
+
+// MaxPhotoSize is the largest photo, in bytes, that can be uploaded
+const MaxPhotoSize = 10 << 20
+
+// maxPixels guards against small files that decode into huge images
+const maxPixels = 50_000_000
+
+// ErrUnsupportedImage is returned for uploads that aren't JPEG or PNG images
+var ErrUnsupportedImage = errors.New("only JPEG and PNG images can be uploaded")
+
+// ErrImageTooLarge is returned for uploads bigger than MaxPhotoSize or maxPixels
+var ErrImageTooLarge = errors.New("image is too large")
+
+// Size is a width, in pixels, photos are resized to
+type Size struct {
+	Name  string
+	Width int
+}
+
+// Sizes are the sizes every uploaded photo is stored in
+var Sizes = []Size{
+	{Name: "thumb", Width: 320},
+	{Name: "medium", Width: 960},
+	{Name: "large", Width: 1920},
+}
+
+// ValidSize reports whether name is one of Sizes
+func ValidSize(name string) bool {
+	for _, s := range Sizes {
+		if s.Name == name {
+			return true
+		}
+	}
+
+	return false
+}
+
+// PhotoPath returns the storage name of a photo in the given size
+func PhotoPath(size, fileName string) string {
+	return size + "/" + fileName
+}
+
+// SavePhoto checks that data is a JPEG or PNG image, resizes it into every one of Sizes and
+// saves them as JPEGs. It returns the file name the sizes are saved under
+func SavePhoto(s Storage, data []byte) (string, error) {
+	if len(data) > MaxPhotoSize {
+		return "", ErrImageTooLarge
+	}
+
+	switch http.DetectContentType(data) {
+	case "image/jpeg", "image/png":
+	default:
+		return "", ErrUnsupportedImage
+	}
+
+	cfg, _, err := image.DecodeConfig(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrUnsupportedImage
+	}
+
+	if cfg.Width*cfg.Height > maxPixels {
+		return "", ErrImageTooLarge
+	}
+
+	img, _, err := image.Decode(bytes.NewReader(data))
+	if err != nil {
+		return "", ErrUnsupportedImage
+	}
+
+	key := make([]byte, 16)
+	_, err = rand.Read(key)
+	if err != nil {
+		return "", err
+	}
+	fileName := hex.EncodeToString(key) + ".jpg"
+
+	// JPEG has no transparency, so flatten the image onto white first
+	flat := image.NewRGBA(img.Bounds())
+	draw.Draw(flat, flat.Bounds(), image.White, image.Point{}, draw.Src)
+	draw.Draw(flat, flat.Bounds(), img, img.Bounds().Min, draw.Over)
+
+	// resize from the largest size down, each from the one before, which is much quicker than
+	// scaling the original every time
+	var src image.Image = flat
+	for i := len(Sizes) - 1; i >= 0; i-- {
+		size := Sizes[i]
+		src = Resize(src, size.Width)
+
+		var buf bytes.Buffer
+		err = jpeg.Encode(&buf, src, &jpeg.Options{Quality: 85})
+		if err != nil {
+			return "", err
+		}
+
+		err = s.Save(PhotoPath(size.Name, fileName), buf.Bytes())
+		if err != nil {
+			return "", fmt.Errorf("saving %s photo: %w", size.Name, err)
+		}
+	}
+
+	return fileName, nil
+}
+
+// DeletePhoto removes every size of a photo from storage
+func DeletePhoto(s Storage, fileName string) error {
+	for _, size := range Sizes {
+		err := s.Delete(PhotoPath(size.Name, fileName))
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Resize scales img down to width, keeping its aspect ratio, by averaging the source pixels that
+// fall into each destination pixel. Images no wider than width are copied unscaled
+func Resize(img image.Image, width int) image.Image {
+	b := img.Bounds()
+	if b.Dx() <= width {
+		width = b.Dx()
+	}
+
+	height := b.Dy() * width / b.Dx()
+	if height < 1 {
+		height = 1
+	}
+
+	dst := image.NewRGBA(image.Rect(0, 0, width, height))
+
+	for y := 0; y < height; y++ {
+		y0 := b.Min.Y + y*b.Dy()/height
+		y1 := b.Min.Y + (y+1)*b.Dy()/height
+
+		for x := 0; x < width; x++ {
+			x0 := b.Min.X + x*b.Dx()/width
+			x1 := b.Min.X + (x+1)*b.Dx()/width
+
+			var r, g, bl, a, n uint64
+			for sy := y0; sy < y1; sy++ {
+				for sx := x0; sx < x1; sx++ {
+					cr, cg, cb, ca := img.At(sx, sy).RGBA()
+					r += uint64(cr)
+					g += uint64(cg)
+					bl += uint64(cb)
+					a += uint64(ca)
+					n++
+				}
+			}
+
+			dst.SetRGBA(x, y, color.RGBA{
+				R: uint8(r / n >> 8),
+				G: uint8(g / n >> 8),
+				B: uint8(bl / n >> 8),
+				A: uint8(a / n >> 8),
+			})
+		}
+	}
+
+	return dst
+}
@@ -0,0 +1,109 @@
+package media
+
+import (
+	"bytes"
+	"errors"
+	"image"
+	"image/color"
+	"image/png"
+	"io"
+	"testing"
+)
+
+func testPNG(t *testing.T, width, height int) []byte {
+	img := image.NewRGBA(image.Rect(0, 0, width, height))
+	for y := 0; y < height; y++ {
+		for x := 0; x < width; x++ {
+			img.Set(x, y, color.RGBA{R: 200, G: 100, B: 50, A: 255})
+		}
+	}
+
+	var buf bytes.Buffer
+	err := png.Encode(&buf, img)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return buf.Bytes()
+}
+
+func TestResize(t *testing.T) {
+	img := image.NewRGBA(image.Rect(0, 0, 400, 200))
+
+	b := Resize(img, 100).Bounds()
+	if b.Dx() != 100 || b.Dy() != 50 {
+		t.Errorf("expected 100x50 but got %dx%d", b.Dx(), b.Dy())
+	}
+
+	b = Resize(img, 1000).Bounds()
+	if b.Dx() != 400 || b.Dy() != 200 {
+		t.Errorf("expected small images not to be scaled up, but got %dx%d", b.Dx(), b.Dy())
+	}
+}
+
+func TestSavePhoto(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+
+	fileName, err := SavePhoto(s, testPNG(t, 1000, 500))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range Sizes {
+		f, err := s.Open(PhotoPath(size.Name, fileName))
+		if err != nil {
+			t.Errorf("expected %s to be saved: %s", size.Name, err)
+			continue
+		}
+
+		data, _ := io.ReadAll(f)
+		f.Close()
+
+		cfg, format, err := image.DecodeConfig(bytes.NewReader(data))
+		if err != nil || format != "jpeg" {
+			t.Errorf("expected %s to be a jpeg, got %q: %v", size.Name, format, err)
+			continue
+		}
+
+		want := size.Width
+		if want > 1000 {
+			want = 1000
+		}
+		if cfg.Width != want {
+			t.Errorf("expected %s to be %d wide but got %d", size.Name, want, cfg.Width)
+		}
+	}
+
+	err = DeletePhoto(s, fileName)
+	if err != nil {
+		t.Error(err)
+	}
+
+	_, err = s.Open(PhotoPath("thumb", fileName))
+	if err == nil {
+		t.Error("expected photo to be deleted")
+	}
+}
+
+func TestSavePhotoRejectsOtherFiles(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+
+	_, err := SavePhoto(s, []byte("<html><body>not an image</body></html>"))
+	if !errors.Is(err, ErrUnsupportedImage) {
+		t.Errorf("expected ErrUnsupportedImage but got %v", err)
+	}
+
+	_, err = SavePhoto(s, make([]byte, MaxPhotoSize+1))
+	if !errors.Is(err, ErrImageTooLarge) {
+		t.Errorf("expected ErrImageTooLarge but got %v", err)
+	}
+}
+
+func TestLocalStorageStaysInDir(t *testing.T) {
+	s := NewLocalStorage(t.TempDir())
+
+	err := s.Save("../escaped.jpg", []byte("x"))
+	if err == nil {
+		t.Error("expected names leaving the storage directory to be refused")
+	}
+}
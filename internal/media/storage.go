@@ -0,0 +1,84 @@
+package media
+
+import (
+	"errors"
+	"io"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// File is a stored file opened for reading
+type File interface {
+	io.ReadSeekCloser
+	Stat() (fs.FileInfo, error)
+}
+
+// Storage keeps uploaded files under slash separated names such as "thumb/abc.jpg"
+type Storage interface {
+	Save(name string, data []byte) error
+	Open(name string) (File, error)
+	Delete(name string) error
+}
+
+// LocalStorage stores files in a directory on the local filesystem
+type LocalStorage struct {
+	Dir string
+}
+
+// NewLocalStorage returns storage that keeps files under dir
+func NewLocalStorage(dir string) *LocalStorage {
+	return &LocalStorage{Dir: dir}
+}
+
+// Save writes data to name, creating directories as needed
+func (s *LocalStorage) Save(name string, data []byte) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.MkdirAll(filepath.Dir(p), 0755)
+	if err != nil {
+		return err
+	}
+
+	return os.WriteFile(p, data, 0644)
+}
+
+// Open opens name for reading
+func (s *LocalStorage) Open(name string) (File, error) {
+	p, err := s.path(name)
+	if err != nil {
+		return nil, err
+	}
+
+	return os.Open(p)
+}
+
+// Delete removes name. Deleting a file that doesn't exist is not an error
+func (s *LocalStorage) Delete(name string) error {
+	p, err := s.path(name)
+	if err != nil {
+		return err
+	}
+
+	err = os.Remove(p)
+	if errors.Is(err, fs.ErrNotExist) {
+		return nil
+	}
+
+	return err
+}
+
+// path maps name to a path inside the storage directory, refusing names that would leave it
+func (s *LocalStorage) path(name string) (string, error) {
+	clean := path.Clean("/" + name)
+	if clean == "/" || strings.Contains(name, "..") {
+		return "", fs.ErrInvalid
+	}
+
+	return filepath.Join(s.Dir, filepath.FromSlash(clean)), nil
+}
@@ -1,6 +1,8 @@
 package models
 
 import (
+	"fmt"
+	"strings"
 	"time"
 )
 
@@ -11,17 +13,285 @@ type User struct {
 	Email       string
 	Password    string
 	AccessLevel int
+	// Active is 0 once the user has been deactivated and can no longer log in
+	Active int
+	// TOTPSecret is shared with the user's authenticator app, TOTPEnabled is 1 once they've
+	// confirmed a code from it and must give one to log in
+	TOTPSecret  string
+	TOTPEnabled int
+	// LockedUntil is set when too many failed logins lock the account, and is zero otherwise
+	LockedUntil time.Time
 	CreatedAt   time.Time
 	UpdatedAt   time.Time
 }
 
+// LoginFailures counts the recent failed logins for an email address and from an IP address
+type LoginFailures struct {
+	Account int
+	IP      int
+	// Last is when the most recent of them happened
+	Last time.Time
+}
+
+// Invited reports whether the user has yet to accept their invite and set a password
+func (u User) Invited() bool {
+	return u.Password == ""
+}
+
+// Role is what a user may do in the admin. It is stored as the user's access level
+type Role int
+
+// Roles, each allowed everything the ones before it are
+const (
+	RoleReadOnly Role = iota
+	RoleFrontDesk
+	RoleManager
+	RoleOwner
+)
+
+// RoleFromAccessLevel returns the role stored as level. Levels that aren't a role are read-only
+func RoleFromAccessLevel(level int) Role {
+	if level < int(RoleReadOnly) || level > int(RoleOwner) {
+		return RoleReadOnly
+	}
+
+	return Role(level)
+}
+
+// ParseRole returns the role named s, such as "front-desk" or "Front Desk"
+func ParseRole(s string) (Role, error) {
+	name := strings.ToLower(strings.NewReplacer("-", " ", "_", " ").Replace(strings.TrimSpace(s)))
+
+	for r := RoleReadOnly; r <= RoleOwner; r++ {
+		if strings.ToLower(r.String()) == name {
+			return r, nil
+		}
+	}
+
+	return RoleReadOnly, fmt.Errorf("unknown role %q", s)
+}
+
+// String returns the name of the role
+func (r Role) String() string {
+	switch r {
+	case RoleFrontDesk:
+		return "Front Desk"
+	case RoleManager:
+		return "Manager"
+	case RoleOwner:
+		return "Owner"
+	default:
+		return "Read Only"
+	}
+}
+
+// Locked reports whether too many failed logins have locked the user out for now
+func (u User) Locked() bool {
+	return u.LockedUntil.After(time.Now())
+}
+
+// Role returns the user's role
+func (u User) Role() Role {
+	return RoleFromAccessLevel(u.AccessLevel)
+}
+
+// API token scopes. A read token can only look, a write token can do whatever its user's role allows
+const (
+	APIScopeRead  = "read"
+	APIScopeWrite = "write"
+)
+
+// APIToken lets a script use the admin as a staff user by sending it in an Authorization header.
+// Only a hash of the token is stored, it is shown to the user once when they make it
+type APIToken struct {
+	ID         int
+	UserID     int
+	Name       string
+	Scope      string
+	ExpiresAt  time.Time
+	LastUsedAt time.Time
+	CreatedAt  time.Time
+	UpdatedAt  time.Time
+	User       User
+}
+
+// Expired reports whether the token can no longer be used
+func (t APIToken) Expired() bool {
+	return !t.ExpiresAt.After(time.Now())
+}
+
+// Guest is a guest who has registered so their bookings are kept together. Guests can't use the admin
+type Guest struct {
+	ID        int
+	FirstName string
+	LastName  string
+	Email     string
+	Phone     string
+	Password  string
+	CreatedAt time.Time
+	UpdatedAt time.Time
+}
+
 type Room struct {
+	ID          int
+	RoomName    string
+	BaseRate    int
+	WeekendRate int
+	MaxAdults   int
+	MaxChildren int
+	Archived    int
+	Slug        string
+	Description string
+	Amenities   string
+	Image       string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+}
+
+// AmenityList returns the room's amenities, which are stored one per line
+func (r Room) AmenityList() []string {
+	var list []string
+	for _, a := range strings.Split(r.Amenities, "\n") {
+		if a = strings.TrimSpace(a); a != "" {
+			list = append(list, a)
+		}
+	}
+
+	return list
+}
+
+// Sleeps reports whether a party of adults and children fits in the room. Children can take adult
+// places but adults can't take children's
+func (r Room) Sleeps(adults, children int) bool {
+	return adults <= r.MaxAdults && adults+children <= r.MaxAdults+r.MaxChildren
+}
+
+// PriceFrom returns the lowest regular nightly price of the room
+func (r Room) PriceFrom() int {
+	if r.WeekendRate > 0 && r.WeekendRate < r.BaseRate {
+		return r.WeekendRate
+	}
+
+	return r.BaseRate
+}
+
+// RoomPhoto is an uploaded photo of a room, stored in every one of the media sizes under FileName
+type RoomPhoto struct {
 	ID        int
-	RoomName  string
+	RoomID    int
+	FileName  string
+	Caption   string
+	SortOrder int
 	CreatedAt time.Time
 	UpdatedAt time.Time
 }
 
+// URL returns the url the photo is served from in the given size
+func (p RoomPhoto) URL(size string) string {
+	return "/photos/" + size + "/" + p.FileName
+}
+
+// StayRule restricts stays in a room from StartDate through EndDate. MinNights and MaxNights of 0 mean no
+// limit. ClosedToArrival and ClosedToDeparture are bitmasks of the weekdays, 1 << time.Weekday, guests can't
+// arrive or leave on
+type StayRule struct {
+	ID                int
+	RoomID            int
+	Name              string
+	StartDate         time.Time
+	EndDate           time.Time
+	MinNights         int
+	MaxNights         int
+	ClosedToArrival   int
+	ClosedToDeparture int
+	CreatedAt         time.Time
+	UpdatedAt         time.Time
+	Room              Room
+}
+
+// covers reports whether d falls between the rule's first and last day
+func (r StayRule) covers(d time.Time) bool {
+	return !d.Before(r.StartDate) && !d.After(r.EndDate)
+}
+
+// ArrivalClosedOn reports whether guests can't arrive on weekday d while the rule is in effect
+func (r StayRule) ArrivalClosedOn(d time.Weekday) bool {
+	return r.ClosedToArrival&(1<<d) != 0
+}
+
+// DepartureClosedOn reports whether guests can't leave on weekday d while the rule is in effect
+func (r StayRule) DepartureClosedOn(d time.Weekday) bool {
+	return r.ClosedToDeparture&(1<<d) != 0
+}
+
+// Violation returns why a stay from start to end breaks the rule, or an empty string if it doesn't.
+// Length of stay and arrival rules apply to stays arriving while the rule is in effect, departure
+// rules to stays leaving while it is
+func (r StayRule) Violation(start, end time.Time) string {
+	nights := int(end.Sub(start).Hours()+12) / 24
+
+	if r.covers(start) {
+		if r.MinNights > 0 && nights < r.MinNights {
+			return fmt.Sprintf("stays arriving on %s must be at least %d nights", start.Format("Mon, Jan 2"), r.MinNights)
+		}
+
+		if r.MaxNights > 0 && nights > r.MaxNights {
+			return fmt.Sprintf("stays arriving on %s can be at most %d nights", start.Format("Mon, Jan 2"), r.MaxNights)
+		}
+
+		if r.ArrivalClosedOn(start.Weekday()) {
+			return fmt.Sprintf("arrivals are not possible on %s", start.Format("Mon, Jan 2"))
+		}
+	}
+
+	if r.covers(end) && r.DepartureClosedOn(end.Weekday()) {
+		return fmt.Sprintf("departures are not possible on %s", end.Format("Mon, Jan 2"))
+	}
+
+	return ""
+}
+
+// RoomRate is a seasonal override of a room's nightly rates, for the nights from StartDate through EndDate
+type RoomRate struct {
+	ID          int
+	RoomID      int
+	Name        string
+	StartDate   time.Time
+	EndDate     time.Time
+	NightlyRate int
+	WeekendRate int
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+}
+
+// NightPrice is the price of a single night of a stay
+type NightPrice struct {
+	Date     time.Time
+	Price    int
+	RateName string
+}
+
+// StayPrice holds the per-night breakdown and total price of a stay
+type StayPrice struct {
+	Nights []NightPrice
+	Total  int
+}
+
+// Restriction ids, matching the rows seeded in the restrictions table
+const (
+	RestrictionReservation = 1
+	RestrictionOwnerBlock  = 2
+	RestrictionHold        = 3
+)
+
+// Reservation sources, recording whether the guest booked online or staff booked for them
+const (
+	SourceWeb    = "web"
+	SourcePhone  = "phone"
+	SourceWalkIn = "walk-in"
+)
+
 type Restriction struct {
 	ID           int
 	Restrictions string
@@ -30,32 +300,172 @@ type Restriction struct {
 }
 
 type Reservation struct {
-	ID        int
-	FirstName string
-	LastName  string
-	Email     string
-	Phone     string
-	StartDate time.Time
-	EndDate   time.Time
-	RoomID    int
-	CreatedAt time.Time
-	UpdatedAt time.Time
-	Room      Room
+	ID                 int
+	FirstName          string
+	LastName           string
+	Email              string
+	Phone              string
+	StartDate          time.Time
+	EndDate            time.Time
+	RoomID             int
+	CreatedAt          time.Time
+	UpdatedAt          time.Time
+	Room               Room
+	Processed          int
+	ConfirmationCode   string
+	Cancelled          int
+	CancelledAt        time.Time
+	CancellationReason string
+	RefundPercent      int
+	TotalPrice         int
+	Adults             int
+	Children           int
+	GroupID            int
+	// Source is how the reservation was made, one of the Source constants
+	Source string
+	// OverrideReason is why staff booked a stay that breaks a stay rule
+	OverrideReason string
+	// GuestID is the guest account the reservation was booked from, 0 if booked without one
+	GuestID int
+	// HoldID is the room restriction holding the room while the guest checks out, it is not stored
+	HoldID int
+}
+
+// ReservationFilter selects, orders and pages the reservations listed in the admin
+type ReservationFilter struct {
+	// Search matches guest names, emails and phone numbers
+	Search string
+	RoomID int
+	// From and To select reservations staying any night between them, zero times leave a side open
+	From time.Time
+	To   time.Time
+	// Processed is 0 or 1 to list only new or processed reservations, -1 for both
 	Processed int
+	// Sort is "arrival", "created" or "name"
+	Sort    string
+	Desc    bool
+	Page    int
+	PerPage int
 }
 
-type RoomRestriction struct {
+// Pagination describes one page of a longer list
+type Pagination struct {
+	Page    int
+	PerPage int
+	Total   int
+}
+
+// Pages returns the number of pages, at least one
+func (p Pagination) Pages() int {
+	if p.PerPage < 1 || p.Total == 0 {
+		return 1
+	}
+
+	return (p.Total + p.PerPage - 1) / p.PerPage
+}
+
+// HasPrev reports whether there is a page before this one
+func (p Pagination) HasPrev() bool {
+	return p.Page > 1
+}
+
+// HasNext reports whether there is a page after this one
+func (p Pagination) HasNext() bool {
+	return p.Page < p.Pages()
+}
+
+// WaitlistEntry is a guest waiting for a room to free up for their dates. A RoomID of 0 means any room will do
+type WaitlistEntry struct {
 	ID            int
+	FirstName     string
+	LastName      string
+	Email         string
 	StartDate     time.Time
 	EndDate       time.Time
 	RoomID        int
-	ReservationID int
-	RestrictionID int
+	Adults        int
+	Children      int
+	NotifiedAt    time.Time
+	OfferedRoomID int
 	CreatedAt     time.Time
 	UpdatedAt     time.Time
 	Room          Room
-	Reservation   Reservation
-	Restriction   Restriction
+}
+
+// ReservationGroup is a booking of several rooms for the same dates made in one checkout
+type ReservationGroup struct {
+	ID           int
+	FirstName    string
+	LastName     string
+	Email        string
+	Phone        string
+	TotalPrice   int
+	CreatedAt    time.Time
+	UpdatedAt    time.Time
+	Reservations []Reservation
+}
+
+// CancellationPolicy decides how much of a booking is refunded when a guest cancels
+type CancellationPolicy struct {
+	ID                   int
+	Name                 string
+	Description          string
+	FreeCancellationDays int
+	LateRefundPercent    int
+	CreatedAt            time.Time
+	UpdatedAt            time.Time
+}
+
+// RefundPercent returns the percentage refunded when a stay starting on arrival is cancelled at now
+func (p CancellationPolicy) RefundPercent(arrival, now time.Time) int {
+	today := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, time.UTC)
+	daysBefore := int(arrival.Sub(today).Hours() / 24)
+
+	if daysBefore >= p.FreeCancellationDays {
+		return 100
+	}
+
+	return p.LateRefundPercent
+}
+
+type RoomRestriction struct {
+	ID            int
+	StartDate     time.Time
+	EndDate       time.Time
+	RoomID        int
+	ReservationID int
+	RestrictionID int
+	ExpiresAt     time.Time
+	// Reason is why an owner block was put in place
+	Reason      string
+	CreatedAt   time.Time
+	UpdatedAt   time.Time
+	Room        Room
+	Reservation Reservation
+	Restriction Restriction
+}
+
+// Nights returns how many nights the restriction covers
+func (rr RoomRestriction) Nights() int {
+	return int(rr.EndDate.Sub(rr.StartDate).Hours() / 24)
+}
+
+// TimelineRow is a room's row on the admin timeline
+type TimelineRow struct {
+	Room Room
+	Bars []TimelineBar
+}
+
+// TimelineBar is a stay, block or hold drawn across the nights it covers on the admin timeline
+type TimelineBar struct {
+	// Column is the first night of the range the bar covers, counting from 1, and Span how many it covers
+	Column int
+	Span   int
+	// Kind is "reservation", "new-reservation", "block" or "hold"
+	Kind  string
+	Label string
+	Title string
+	URL   string
 }
 
 // MailData holds an email message
@@ -66,3 +476,51 @@ type MailData struct {
 	Content  string
 	Template string
 }
+
+// DailyOccupancy is how many of the bookable rooms are reserved on the night of Date
+type DailyOccupancy struct {
+	Date     time.Time
+	Occupied int
+	Rooms    int
+}
+
+// MonthlyRevenue is the total price of the reservations arriving in Month
+type MonthlyRevenue struct {
+	Month   time.Time
+	Revenue int
+}
+
+// DashboardStats are the figures shown on the admin dashboard. Averages cover the reservations made in
+// the last StatsWindow days
+type DashboardStats struct {
+	Arrivals        int
+	Departures      int
+	InHouse         int
+	NewReservations int
+	AvgLengthOfStay float64
+	AvgLeadTime     float64
+	RevenueNext30   int
+	Occupancy       []DailyOccupancy
+	Revenue         []MonthlyRevenue
+}
+
+// StatsWindow is how many days back the dashboard averages look
+const StatsWindow = 90
+
+// OccupancyPercent returns the share of room nights reserved over the first days of s.Occupancy
+func (s DashboardStats) OccupancyPercent(days int) float64 {
+	var occupied, rooms int
+	for i, d := range s.Occupancy {
+		if i == days {
+			break
+		}
+		occupied += d.Occupied
+		rooms += d.Rooms
+	}
+
+	if rooms == 0 {
+		return 0
+	}
+
+	return float64(occupied) * 100 / float64(rooms)
+}
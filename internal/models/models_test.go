@@ -0,0 +1,184 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestStayRuleViolation(t *testing.T) {
+	date := func(s string) time.Time {
+		d, _ := time.Parse("2006-01-02", s)
+		return d
+	}
+
+	// 2045-07-01 is a Saturday
+	rule := StayRule{
+		StartDate:         date("2045-07-01"),
+		EndDate:           date("2045-07-07"),
+		MinNights:         2,
+		MaxNights:         5,
+		ClosedToArrival:   1 << time.Monday,
+		ClosedToDeparture: 1 << time.Sunday,
+	}
+
+	tests := []struct {
+		name     string
+		start    string
+		end      string
+		expected string
+	}{
+		{"allowed", "2045-07-01", "2045-07-04", ""},
+		{"too short", "2045-07-04", "2045-07-05", "stays arriving on Tue, Jul 4 must be at least 2 nights"},
+		{"too long", "2045-07-01", "2045-07-08", "stays arriving on Sat, Jul 1 can be at most 5 nights"},
+		{"closed to arrival", "2045-07-03", "2045-07-05", "arrivals are not possible on Mon, Jul 3"},
+		{"departure after rule", "2045-07-04", "2045-07-09", ""},
+		{"departure on closed day", "2045-06-30", "2045-07-02", "departures are not possible on Sun, Jul 2"},
+		{"arrival before rule", "2045-06-29", "2045-06-30", ""},
+		{"arrival after rule", "2045-07-10", "2045-07-11", ""},
+	}
+
+	for _, e := range tests {
+		got := rule.Violation(date(e.start), date(e.end))
+		if got != e.expected {
+			t.Errorf("%s: expected %q but got %q", e.name, e.expected, got)
+		}
+	}
+}
+
+func TestRoleFromAccessLevel(t *testing.T) {
+	tests := []struct {
+		level        int
+		expectedRole Role
+		expectedName string
+	}{
+		{0, RoleReadOnly, "Read Only"},
+		{1, RoleFrontDesk, "Front Desk"},
+		{2, RoleManager, "Manager"},
+		{3, RoleOwner, "Owner"},
+		{4, RoleReadOnly, "Read Only"},
+		{-1, RoleReadOnly, "Read Only"},
+	}
+
+	for _, e := range tests {
+		role := User{AccessLevel: e.level}.Role()
+		if role != e.expectedRole || role.String() != e.expectedName {
+			t.Errorf("access level %d: expected %s but got %s", e.level, e.expectedName, role)
+		}
+	}
+}
+
+func TestParseRole(t *testing.T) {
+	tests := []struct {
+		name     string
+		expected Role
+		valid    bool
+	}{
+		{"owner", RoleOwner, true},
+		{"Front Desk", RoleFrontDesk, true},
+		{"front-desk", RoleFrontDesk, true},
+		{" manager ", RoleManager, true},
+		{"read_only", RoleReadOnly, true},
+		{"janitor", RoleReadOnly, false},
+	}
+
+	for _, e := range tests {
+		role, err := ParseRole(e.name)
+		if role != e.expected || (err == nil) != e.valid {
+			t.Errorf("%q: expected %s (valid %t) but got %s (%v)", e.name, e.expected, e.valid, role, err)
+		}
+	}
+}
+
+func TestRoomAmenityList(t *testing.T) {
+	room := Room{Amenities: "Ocean view\r\n\n  Queen bed  \n"}
+
+	list := room.AmenityList()
+	if len(list) != 2 || list[0] != "Ocean view" || list[1] != "Queen bed" {
+		t.Errorf("expected [Ocean view Queen bed] but got %q", list)
+	}
+}
+
+func TestRoomPriceFrom(t *testing.T) {
+	if p := (Room{BaseRate: 10000}).PriceFrom(); p != 10000 {
+		t.Errorf("expected 10000 without a weekend rate but got %d", p)
+	}
+
+	if p := (Room{BaseRate: 10000, WeekendRate: 8000}).PriceFrom(); p != 8000 {
+		t.Errorf("expected the lower weekend rate 8000 but got %d", p)
+	}
+
+	if p := (Room{BaseRate: 10000, WeekendRate: 12000}).PriceFrom(); p != 10000 {
+		t.Errorf("expected the lower base rate 10000 but got %d", p)
+	}
+}
+
+func TestRoomSleeps(t *testing.T) {
+	room := Room{MaxAdults: 2, MaxChildren: 2}
+
+	if !room.Sleeps(2, 2) {
+		t.Error("expected 2 adults and 2 children to fit")
+	}
+
+	if !room.Sleeps(1, 3) {
+		t.Error("expected a child to fit in an adult's place")
+	}
+
+	if room.Sleeps(3, 0) {
+		t.Error("expected a third adult not to fit")
+	}
+
+	if room.Sleeps(2, 3) {
+		t.Error("expected a fifth guest not to fit")
+	}
+}
+
+func TestRoomRestrictionNights(t *testing.T) {
+	start := time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC)
+
+	if n := (RoomRestriction{StartDate: start, EndDate: start.AddDate(0, 0, 1)}).Nights(); n != 1 {
+		t.Errorf("expected 1 night but got %d", n)
+	}
+
+	if n := (RoomRestriction{StartDate: start, EndDate: start.AddDate(0, 0, 5)}).Nights(); n != 5 {
+		t.Errorf("expected 5 nights but got %d", n)
+	}
+}
+
+func TestDashboardStatsOccupancyPercent(t *testing.T) {
+	s := DashboardStats{
+		Occupancy: []DailyOccupancy{
+			{Occupied: 2, Rooms: 2},
+			{Occupied: 1, Rooms: 2},
+			{Occupied: 0, Rooms: 2},
+			{Occupied: 0, Rooms: 2},
+		},
+	}
+
+	if p := s.OccupancyPercent(2); p != 75 {
+		t.Errorf("expected 75%% over 2 days but got %v", p)
+	}
+
+	if p := s.OccupancyPercent(30); p != 37.5 {
+		t.Errorf("expected 37.5%% over the days there are but got %v", p)
+	}
+
+	if p := (DashboardStats{}).OccupancyPercent(7); p != 0 {
+		t.Errorf("expected 0%% without rooms but got %v", p)
+	}
+}
+
+func TestPagination(t *testing.T) {
+	p := Pagination{Page: 1, PerPage: 25, Total: 51}
+	if p.Pages() != 3 || p.HasPrev() || !p.HasNext() {
+		t.Errorf("expected page 1 of 3 with only a next page, got %d pages, prev %v, next %v", p.Pages(), p.HasPrev(), p.HasNext())
+	}
+
+	p.Page = 3
+	if !p.HasPrev() || p.HasNext() {
+		t.Errorf("expected the last page to have only a previous page")
+	}
+
+	if (Pagination{Page: 1, PerPage: 25}).Pages() != 1 {
+		t.Errorf("expected an empty list to have one page")
+	}
+}
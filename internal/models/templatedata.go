@@ -14,4 +14,8 @@ type TemplateData struct {
 	Error           string
 	Form            *forms.Form
 	IsAuthenticated int
+	// Role is the logged in user's role
+	Role Role
+	// IsGuest is 1 when a guest is logged in to their account
+	IsGuest int
 }
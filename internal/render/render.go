@@ -12,14 +12,16 @@ import (
 
 	"github.com/justinas/nosurf"
 	"github.com/sindrishtepani/bookings/internal/config"
+	"github.com/sindrishtepani/bookings/internal/helpers"
 	"github.com/sindrishtepani/bookings/internal/models"
 )
 
 var functions = template.FuncMap{
-	"humanDate":  HumanDate,
-	"formatDate": FormatDate,
-	"iterate":    Iterate,
-	"add":        Add,
+	"humanDate":   HumanDate,
+	"formatDate":  FormatDate,
+	"iterate":     Iterate,
+	"add":         Add,
+	"formatPrice": FormatPrice,
 }
 
 var app *config.AppConfig
@@ -56,13 +58,22 @@ func Add(a, b int) int {
 	return a + b
 }
 
+// FormatPrice formats an amount in cents as dollars, e.g. $120.00
+func FormatPrice(cents int) string {
+	return fmt.Sprintf("$%d.%02d", cents/100, cents%100)
+}
+
 func AddDefaultData(td *models.TemplateData, r *http.Request) *models.TemplateData {
 	td.Flash = app.Session.PopString(r.Context(), "flash")
 	td.Error = app.Session.PopString(r.Context(), "error")
 	td.Warning = app.Session.PopString(r.Context(), "warning")
 	td.CSRFToken = nosurf.Token(r)
-	if app.Session.Exists(r.Context(), "user_id") {
+	if helpers.IsAuthenticated(r) {
 		td.IsAuthenticated = 1
+		td.Role = helpers.UserRole(r)
+	}
+	if app.Session.Exists(r.Context(), "guest_id") {
+		td.IsGuest = 1
 	}
 
 	return td
@@ -4,6 +4,7 @@ import (
 	"net/http"
 	"testing"
 
+	"github.com/sindrishtepani/bookings/internal/helpers"
 	"github.com/sindrishtepani/bookings/internal/models"
 )
 
@@ -25,6 +26,29 @@ func TestAddDefaultData(t *testing.T) {
 	}
 }
 
+func TestAddDefaultDataTokenUser(t *testing.T) {
+	var td models.TemplateData
+
+	r, err := getSession()
+	if err != nil {
+		t.Error(err)
+	}
+
+	// the role comes from the API token, not from the access level in the session
+	session.Put(r.Context(), "user_id", 1)
+	session.Put(r.Context(), "access_level", int(models.RoleOwner))
+	r = helpers.WithTokenUser(r, models.User{ID: 1, AccessLevel: int(models.RoleReadOnly)})
+
+	result := AddDefaultData(&td, r)
+
+	if result.IsAuthenticated != 1 {
+		t.Error("token user not shown as logged in")
+	}
+	if result.Role != models.RoleReadOnly {
+		t.Errorf("expected role %d but got %d", models.RoleReadOnly, result.Role)
+	}
+}
+
 func TestTemplate(t *testing.T) {
 	pathToTemplates = "./../../templates"
 
@@ -83,3 +107,13 @@ func TestCreateTemplateCache(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestFormatPrice(t *testing.T) {
+	if FormatPrice(12050) != "$120.50" {
+		t.Errorf("expected $120.50 but got %s", FormatPrice(12050))
+	}
+
+	if FormatPrice(5) != "$0.05" {
+		t.Errorf("expected $0.05 but got %s", FormatPrice(5))
+	}
+}
@@ -10,6 +10,7 @@ import (
 
 	"github.com/alexedwards/scs/v2"
 	"github.com/sindrishtepani/bookings/internal/config"
+	"github.com/sindrishtepani/bookings/internal/helpers"
 	"github.com/sindrishtepani/bookings/internal/models"
 )
 
@@ -37,6 +38,7 @@ func TestMain(m *testing.M) {
 
 	testApp.Session = session
 	app = &testApp
+	helpers.NewHelpers(app)
 
 	os.Exit(m.Run())
 }
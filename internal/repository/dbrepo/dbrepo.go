@@ -4,9 +4,18 @@ import (
 	"database/sql"
 
 	"github.com/sindrishtepani/bookings/internal/config"
+	"github.com/sindrishtepani/bookings/internal/models"
 	"github.com/sindrishtepani/bookings/internal/repository"
 )
 
+// defaultCancellationPolicy applies to rooms that have no cancellation policy set
+var defaultCancellationPolicy = models.CancellationPolicy{
+	Name:                 "Standard",
+	Description:          "Free cancellation until the day before arrival.",
+	FreeCancellationDays: 1,
+	LateRefundPercent:    0,
+}
+
 type postgresDBRepo struct {
 	App *config.AppConfig
 	DB  *sql.DB
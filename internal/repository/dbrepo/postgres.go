@@ -2,15 +2,89 @@ package dbrepo
 
 import (
 	"context"
+	"database/sql"
 	"errors"
+	"fmt"
+	"strings"
 	"time"
 
+	"github.com/jackc/pgconn"
 	"github.com/sindrishtepani/bookings/internal/models"
+	"github.com/sindrishtepani/bookings/internal/repository"
 	"golang.org/x/crypto/bcrypt"
 )
 
-func (m *postgresDBRepo) AllUsers() bool {
-	return true
+// AllUsers returns all staff users, ordered by name
+func (m *postgresDBRepo) AllUsers() ([]models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var users []models.User
+
+	query := `select id, first_name, last_name, email, password, access_level, active, totp_secret,
+				totp_enabled, locked_until, created_at, updated_at
+				from users order by last_name, first_name`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return users, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var u models.User
+		var lockedUntil sql.NullTime
+		err := rows.Scan(
+			&u.ID,
+			&u.FirstName,
+			&u.LastName,
+			&u.Email,
+			&u.Password,
+			&u.AccessLevel,
+			&u.Active,
+			&u.TOTPSecret,
+			&u.TOTPEnabled,
+			&lockedUntil,
+			&u.CreatedAt,
+			&u.UpdatedAt,
+		)
+		if err != nil {
+			return users, err
+		}
+		u.LockedUntil = lockedUntil.Time
+		users = append(users, u)
+	}
+
+	if err = rows.Err(); err != nil {
+		return users, err
+	}
+
+	return users, nil
+}
+
+// InsertUser inserts an invited user, who has no password until they accept the invite
+func (m *postgresDBRepo) InsertUser(u models.User) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into users (first_name, last_name, email, password, access_level, active, created_at, updated_at)
+		values ($1, $2, $3, '', $4, 1, $5, $6) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.AccessLevel,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, translateEmailError(err)
+	}
+
+	return newID, nil
 }
 
 func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error) {
@@ -20,8 +94,8 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 	var newID int
 
 	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
-		end_date, room_id, created_at, updated_at)
-		values ($1, $2, $3, $4, $5, $6, $7, $8, $9) returning id`
+		end_date, room_id, confirmation_code, total_price, adults, children, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13) returning id`
 
 	err := m.DB.QueryRowContext(ctx,
 		stmt,
@@ -32,6 +106,10 @@ func (m *postgresDBRepo) InsertReservation(res models.Reservation) (int, error)
 		res.StartDate,
 		res.EndDate,
 		res.RoomID,
+		res.ConfirmationCode,
+		res.TotalPrice,
+		res.Adults,
+		res.Children,
 		time.Now(),
 		time.Now(),
 	).Scan(&newID)
@@ -69,6 +147,380 @@ func (m *postgresDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// BookRoom inserts a reservation and its room restriction in a single transaction,
+// re-checking availability while holding a lock on the room
+func (m *postgresDBRepo) BookRoom(res models.Reservation) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	newID, err := bookRoomTx(ctx, tx, res)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, translateOverlapError(err)
+	}
+
+	return newID, nil
+}
+
+// BookRooms inserts a reservation group and one reservation, with its own room restriction,
+// for each room in the group. Either every room is booked or none are.
+func (m *postgresDBRepo) BookRooms(group models.ReservationGroup) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var groupID int
+
+	stmt := `insert into reservation_groups (first_name, last_name, email, phone, total_price,
+		created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		group.FirstName,
+		group.LastName,
+		group.Email,
+		group.Phone,
+		group.TotalPrice,
+		time.Now(),
+		time.Now(),
+	).Scan(&groupID)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, res := range group.Reservations {
+		res.GroupID = groupID
+		if _, err := bookRoomTx(ctx, tx, res); err != nil {
+			return 0, err
+		}
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, translateOverlapError(err)
+	}
+
+	return groupID, nil
+}
+
+// GetReservationGroupByID returns a reservation group with all of its reservations
+func (m *postgresDBRepo) GetReservationGroupByID(id int) (models.ReservationGroup, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var group models.ReservationGroup
+
+	query := `select id, first_name, last_name, email, phone, total_price, created_at, updated_at
+				from reservation_groups where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&group.ID,
+		&group.FirstName,
+		&group.LastName,
+		&group.Email,
+		&group.Phone,
+		&group.TotalPrice,
+		&group.CreatedAt,
+		&group.UpdatedAt,
+	)
+	if err != nil {
+		return group, err
+	}
+
+	query = `select r.id, r.start_date, r.end_date, r.room_id, r.processed, r.confirmation_code,
+				r.cancelled, r.total_price, r.adults, r.children, r.group_id, rm.id, rm.room_name
+				from reservations r
+				left join rooms rm on (r.room_id = rm.id)
+				where r.group_id = $1
+				order by rm.room_name`
+
+	rows, err := m.DB.QueryContext(ctx, query, id)
+	if err != nil {
+		return group, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(
+			&res.ID,
+			&res.StartDate,
+			&res.EndDate,
+			&res.RoomID,
+			&res.Processed,
+			&res.ConfirmationCode,
+			&res.Cancelled,
+			&res.TotalPrice,
+			&res.Adults,
+			&res.Children,
+			&res.GroupID,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return group, err
+		}
+		res.FirstName = group.FirstName
+		res.LastName = group.LastName
+		res.Email = group.Email
+		res.Phone = group.Phone
+		group.Reservations = append(group.Reservations, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return group, err
+	}
+
+	return group, nil
+}
+
+// bookRoomTx locks the room, checks availability and inserts the reservation and
+// room restriction using tx
+func bookRoomTx(ctx context.Context, tx *sql.Tx, res models.Reservation) (int, error) {
+	// lock the room row so that concurrent bookings for the same room are serialized
+	var roomID int
+	err := tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, res.RoomID).Scan(&roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	// holds that have run out no longer keep the room from being booked
+	err = deleteExpiredHoldsTx(ctx, tx, res.RoomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+
+	// the guest's own hold doesn't count against them
+	query := `select count(id) from room_restrictions
+	where room_id = $1 and $2 < end_date and $3 > start_date and id <> $4`
+
+	err = tx.QueryRowContext(ctx, query, res.RoomID, res.StartDate, res.EndDate, res.HoldID).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	var newID int
+
+	// reservations booked on their own don't belong to a group, nor those booked without an account to a guest
+	groupID := sql.NullInt64{Int64: int64(res.GroupID), Valid: res.GroupID > 0}
+	guestID := sql.NullInt64{Int64: int64(res.GuestID), Valid: res.GuestID > 0}
+
+	source := res.Source
+	if source == "" {
+		source = models.SourceWeb
+	}
+
+	stmt := `insert into reservations (first_name, last_name, email, phone, start_date,
+		end_date, room_id, confirmation_code, total_price, adults, children, group_id,
+		source, override_reason, guest_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11, $12, $13, $14, $15, $16, $17) returning id`
+
+	err = tx.QueryRowContext(ctx,
+		stmt,
+		res.FirstName,
+		res.LastName,
+		res.Email,
+		res.Phone,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		res.ConfirmationCode,
+		res.TotalPrice,
+		res.Adults,
+		res.Children,
+		groupID,
+		source,
+		res.OverrideReason,
+		guestID,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	if res.HoldID > 0 {
+		// turn the guest's hold into the reservation's restriction
+		stmt = `update room_restrictions set restriction_id = $1, reservation_id = $2, start_date = $3,
+			end_date = $4, expires_at = null, updated_at = $5
+			where id = $6 and room_id = $7 and restriction_id = $8`
+
+		result, err := tx.ExecContext(ctx, stmt,
+			models.RestrictionReservation,
+			newID,
+			res.StartDate,
+			res.EndDate,
+			time.Now(),
+			res.HoldID,
+			res.RoomID,
+			models.RestrictionHold,
+		)
+		if err != nil {
+			return 0, translateOverlapError(err)
+		}
+
+		converted, err := result.RowsAffected()
+		if err != nil {
+			return 0, err
+		}
+
+		if converted > 0 {
+			return newID, nil
+		}
+	}
+
+	stmt = `insert into room_restrictions (start_date, end_date, room_id,
+		reservation_id, restriction_id, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7)`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		res.StartDate,
+		res.EndDate,
+		res.RoomID,
+		newID,
+		models.RestrictionReservation,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return 0, translateOverlapError(err)
+	}
+
+	return newID, nil
+}
+
+// deleteExpiredHoldsTx removes the holds on roomID that have run out using tx
+func deleteExpiredHoldsTx(ctx context.Context, tx *sql.Tx, roomID int) error {
+	query := `delete from room_restrictions where room_id = $1 and restriction_id = $2 and expires_at < $3`
+
+	_, err := tx.ExecContext(ctx, query, roomID, models.RestrictionHold, time.Now())
+
+	return err
+}
+
+// HoldRoom keeps a room free for the given dates for ttl while a guest fills in the reservation form.
+// It returns the id of the hold's room restriction, or ErrRoomUnavailable if the room is taken
+func (m *postgresDBRepo) HoldRoom(roomID int, start, end time.Time, ttl time.Duration) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	var lockedID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, roomID).Scan(&lockedID)
+	if err != nil {
+		return 0, err
+	}
+
+	err = deleteExpiredHoldsTx(ctx, tx, roomID)
+	if err != nil {
+		return 0, err
+	}
+
+	var numRows int
+
+	query := `select count(id) from room_restrictions
+	where room_id = $1 and $2 < end_date and $3 > start_date`
+
+	err = tx.QueryRowContext(ctx, query, roomID, start, end).Scan(&numRows)
+	if err != nil {
+		return 0, err
+	}
+
+	if numRows > 0 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	var holdID int
+
+	stmt := `insert into room_restrictions (start_date, end_date, room_id, restriction_id,
+		expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err = tx.QueryRowContext(ctx, stmt,
+		start,
+		end,
+		roomID,
+		models.RestrictionHold,
+		time.Now().Add(ttl),
+		time.Now(),
+		time.Now(),
+	).Scan(&holdID)
+	if err != nil {
+		return 0, translateOverlapError(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, translateOverlapError(err)
+	}
+
+	return holdID, nil
+}
+
+// ReleaseHold removes a hold before it expires
+func (m *postgresDBRepo) ReleaseHold(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from room_restrictions where id = $1 and restriction_id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, id, models.RestrictionHold)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteExpiredHolds removes every hold that has run out and returns how many were removed
+func (m *postgresDBRepo) DeleteExpiredHolds() (int64, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from room_restrictions where restriction_id = $1 and expires_at < $2`
+
+	result, err := m.DB.ExecContext(ctx, query, models.RestrictionHold, time.Now())
+	if err != nil {
+		return 0, err
+	}
+
+	return result.RowsAffected()
+}
+
+// translateOverlapError turns a violation of the room_restrictions overlap
+// exclusion constraint into ErrRoomUnavailable
+func translateOverlapError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23P01" {
+		return repository.ErrRoomUnavailable
+	}
+
+	return err
+}
+
 // HasAvailabilityByDatesByRoomID returns true if availability exists and false if it doesn't
 func (m *postgresDBRepo) HasAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
@@ -78,9 +530,10 @@ func (m *postgresDBRepo) HasAvailabilityByDatesByRoomID(start, end time.Time, ro
 
 	query := `select count(id) from room_restrictions rr 
 	where room_id = $1 and $2 < end_date and $3 > start_date 
+	and (expires_at is null or expires_at > $4)
 	`
 
-	row := m.DB.QueryRowContext(ctx, query, roomID, start, end)
+	row := m.DB.QueryRowContext(ctx, query, roomID, start, end, time.Now())
 	err := row.Scan(&numRows)
 
 	if err != nil {
@@ -91,7 +544,8 @@ func (m *postgresDBRepo) HasAvailabilityByDatesByRoomID(start, end time.Time, ro
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for a given date range
-func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+// that can sleep the given number of guests. Children may use beds meant for adults but not the other way around.
+func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
@@ -99,21 +553,29 @@ func (m *postgresDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]
 
 	query := `select
 	r.id,
-	r.room_name
+	r.room_name,
+	r.base_rate,
+	r.weekend_rate,
+	r.max_adults,
+	r.max_children
 from
 	rooms r
 where
-	r.id not in (
+	r.archived = 0
+	and r.max_adults >= $3
+	and r.max_adults + r.max_children >= $3 + $4
+	and r.id not in (
 	select
 		room_id
 	from
 		room_restrictions rr
 	where
 		$1 < rr.end_date
-		and $2 > start_date)
+		and $2 > start_date
+		and (rr.expires_at is null or rr.expires_at > $5))
 		`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end)
+	rows, err := m.DB.QueryContext(ctx, query, start, end, adults, children, time.Now())
 	if err != nil {
 		return rooms, err
 	}
@@ -122,6 +584,10 @@ where
 		var room models.Room
 		err := rows.Scan(&room.ID,
 			&room.RoomName,
+			&room.BaseRate,
+			&room.WeekendRate,
+			&room.MaxAdults,
+			&room.MaxChildren,
 		)
 		if err != nil {
 			return rooms, err
@@ -146,13 +612,24 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 
 	var room models.Room
 
-	query := `select id, room_name, created_at, updated_at from rooms where id = $1`
+	query := `select id, room_name, base_rate, weekend_rate, max_adults, max_children, archived,
+				slug, description, amenities, image, created_at, updated_at
+				from rooms where id = $1`
 
 	row := m.DB.QueryRowContext(ctx, query, id)
 
 	err := row.Scan(
 		&room.ID,
 		&room.RoomName,
+		&room.BaseRate,
+		&room.WeekendRate,
+		&room.MaxAdults,
+		&room.MaxChildren,
+		&room.Archived,
+		&room.Slug,
+		&room.Description,
+		&room.Amenities,
+		&room.Image,
 		&room.CreatedAt,
 		&room.UpdatedAt,
 	)
@@ -165,243 +642,1967 @@ func (m *postgresDBRepo) GetRoomByID(id int) (models.Room, error) {
 
 }
 
-func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+// GetPriceForStay works out the nightly breakdown and total price of a stay in a room
+func (m *postgresDBRepo) GetPriceForStay(roomID int, start, end time.Time) (models.StayPrice, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `select id, first_name, last_name, email, password, access_level, created_at, updated_at`
-
-	row := m.DB.QueryRowContext(ctx, query, id)
+	var price models.StayPrice
 
-	var u models.User
+	room, err := m.GetRoomByID(roomID)
+	if err != nil {
+		return price, err
+	}
 
-	err := row.Scan(
-		&u.ID,
-		&u.FirstName,
-		&u.LastName,
-		&u.Email,
-		&u.Password,
-		&u.AccessLevel,
-		&u.CreatedAt,
-		&u.UpdatedAt,
-	)
+	// newer rates take precedence over older ones covering the same night
+	query := `select id, room_id, name, start_date, end_date, nightly_rate, weekend_rate, created_at, updated_at
+				from room_rates
+				where room_id = $1 and start_date < $3 and end_date >= $2
+				order by created_at desc`
 
+	rows, err := m.DB.QueryContext(ctx, query, roomID, start, end)
 	if err != nil {
-		return u, err
+		return price, err
 	}
+	defer rows.Close()
 
-	return u, nil
-}
+	var rates []models.RoomRate
 
-// UpdateUser updates a user type
-func (m *postgresDBRepo) UpdateUser(u models.User) error {
-	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
-	defer cancel()
+	for rows.Next() {
+		var rate models.RoomRate
+
+		err := rows.Scan(
+			&rate.ID,
+			&rate.RoomID,
+			&rate.Name,
+			&rate.StartDate,
+			&rate.EndDate,
+			&rate.NightlyRate,
+			&rate.WeekendRate,
+			&rate.CreatedAt,
+			&rate.UpdatedAt,
+		)
+		if err != nil {
+			return price, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return price, err
+	}
+
+	return priceStay(room, rates, start, end), nil
+}
+
+// AllRoomRates gets all seasonal rates, with their room
+func (m *postgresDBRepo) AllRoomRates() ([]models.RoomRate, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var rates []models.RoomRate
+
+	query := `select rr.id, rr.room_id, rr.name, rr.start_date, rr.end_date, rr.nightly_rate,
+				rr.weekend_rate, rr.created_at, rr.updated_at, r.id, r.room_name
+				from room_rates rr
+				left join rooms r on (rr.room_id = r.id)
+				order by rr.start_date asc, r.room_name asc`
+
+	rows, err := m.DB.QueryContext(ctx, query)
+	if err != nil {
+		return rates, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rate models.RoomRate
+
+		err := rows.Scan(
+			&rate.ID,
+			&rate.RoomID,
+			&rate.Name,
+			&rate.StartDate,
+			&rate.EndDate,
+			&rate.NightlyRate,
+			&rate.WeekendRate,
+			&rate.CreatedAt,
+			&rate.UpdatedAt,
+			&rate.Room.ID,
+			&rate.Room.RoomName,
+		)
+		if err != nil {
+			return rates, err
+		}
+
+		rates = append(rates, rate)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rates, err
+	}
+
+	return rates, nil
+}
+
+// InsertRoomRate inserts a seasonal rate for a room
+func (m *postgresDBRepo) InsertRoomRate(rate models.RoomRate) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into room_rates (room_id, name, start_date, end_date, nightly_rate, weekend_rate,
+				created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		rate.RoomID,
+		rate.Name,
+		rate.StartDate,
+		rate.EndDate,
+		rate.NightlyRate,
+		rate.WeekendRate,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRoomRate deletes a seasonal rate
+func (m *postgresDBRepo) DeleteRoomRate(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_rates where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// StayRulesForDates returns the stay rules in effect on any day from start through end, for every room
+func (m *postgresDBRepo) StayRulesForDates(start, end time.Time) ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select sr.id, sr.room_id, sr.name, sr.start_date, sr.end_date, sr.min_nights, sr.max_nights,
+				sr.closed_to_arrival, sr.closed_to_departure, sr.created_at, sr.updated_at, r.id, r.room_name
+				from stay_rules sr
+				left join rooms r on (sr.room_id = r.id)
+				where sr.start_date <= $2 and sr.end_date >= $1
+				order by sr.start_date asc`
+
+	return m.queryStayRules(ctx, query, start, end)
+}
+
+// AllStayRules returns every stay rule
+func (m *postgresDBRepo) AllStayRules() ([]models.StayRule, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select sr.id, sr.room_id, sr.name, sr.start_date, sr.end_date, sr.min_nights, sr.max_nights,
+				sr.closed_to_arrival, sr.closed_to_departure, sr.created_at, sr.updated_at, r.id, r.room_name
+				from stay_rules sr
+				left join rooms r on (sr.room_id = r.id)
+				order by sr.start_date asc, r.room_name asc`
+
+	return m.queryStayRules(ctx, query)
+}
+
+// queryStayRules runs a query selecting stay rules joined with their rooms
+func (m *postgresDBRepo) queryStayRules(ctx context.Context, query string, args ...interface{}) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rules, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var rule models.StayRule
+
+		err := rows.Scan(
+			&rule.ID,
+			&rule.RoomID,
+			&rule.Name,
+			&rule.StartDate,
+			&rule.EndDate,
+			&rule.MinNights,
+			&rule.MaxNights,
+			&rule.ClosedToArrival,
+			&rule.ClosedToDeparture,
+			&rule.CreatedAt,
+			&rule.UpdatedAt,
+			&rule.Room.ID,
+			&rule.Room.RoomName,
+		)
+		if err != nil {
+			return rules, err
+		}
+
+		rules = append(rules, rule)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rules, err
+	}
+
+	return rules, nil
+}
+
+// InsertStayRule inserts a stay rule for a room
+func (m *postgresDBRepo) InsertStayRule(rule models.StayRule) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into stay_rules (room_id, name, start_date, end_date, min_nights, max_nights,
+				closed_to_arrival, closed_to_departure, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10)`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		rule.RoomID,
+		rule.Name,
+		rule.StartDate,
+		rule.EndDate,
+		rule.MinNights,
+		rule.MaxNights,
+		rule.ClosedToArrival,
+		rule.ClosedToDeparture,
+		time.Now(),
+		time.Now(),
+	)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteStayRule deletes a stay rule
+func (m *postgresDBRepo) DeleteStayRule(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from stay_rules where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *postgresDBRepo) GetUserByID(id int) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, active, totp_secret,
+				totp_enabled, locked_until, created_at, updated_at
+				from users where id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+
+	var u models.User
+	var lockedUntil sql.NullTime
+
+	err := row.Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.Active,
+		&u.TOTPSecret,
+		&u.TOTPEnabled,
+		&lockedUntil,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+
+	if err != nil {
+		return u, err
+	}
+	u.LockedUntil = lockedUntil.Time
+
+	return u, nil
+}
+
+// GetUserByEmail returns the user with email
+func (m *postgresDBRepo) GetUserByEmail(email string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, password, access_level, active, locked_until,
+				created_at, updated_at
+				from users where email = $1`
+
+	var u models.User
+	var lockedUntil sql.NullTime
+
+	err := m.DB.QueryRowContext(ctx, query, email).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+		&u.Password,
+		&u.AccessLevel,
+		&u.Active,
+		&lockedUntil,
+		&u.CreatedAt,
+		&u.UpdatedAt,
+	)
+	if err != nil {
+		return u, err
+	}
+	u.LockedUntil = lockedUntil.Time
+
+	return u, nil
+}
+
+// UpdateUser updates a user's details, access level and active flag, revoking the API tokens of a user
+// being deactivated. It returns ErrLastOwner, and changes nothing, if the update would leave no active owner
+func (m *postgresDBRepo) UpdateUser(u models.User) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the active owners so two updates can't each see the other owner still there and
+	// together leave none
+	_, err = tx.ExecContext(ctx, `select id from users where access_level = $1 and active = 1 for update`,
+		int(models.RoleOwner))
+	if err != nil {
+		return err
+	}
 
-	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, updated_at=$5`
+	query := `update users set first_name = $1, last_name = $2, email = $3, access_level = $4, active = $5,
+				updated_at = $6 where id = $7`
 
-	_, err := m.DB.ExecContext(ctx, query,
+	_, err = tx.ExecContext(ctx, query,
 		u.FirstName,
 		u.LastName,
 		u.Email,
 		u.AccessLevel,
+		u.Active,
 		time.Now(),
+		u.ID,
 	)
+	if err != nil {
+		return translateEmailError(err)
+	}
+
+	if u.Active == 0 {
+		_, err = tx.ExecContext(ctx, `delete from api_tokens where user_id = $1`, u.ID)
+		if err != nil {
+			return err
+		}
+	}
 
+	var owners int
+	err = tx.QueryRowContext(ctx, `select count(id) from users where access_level = $1 and active = 1`,
+		int(models.RoleOwner)).Scan(&owners)
 	if err != nil {
 		return err
 	}
 
-	return nil
+	if owners == 0 {
+		return repository.ErrLastOwner
+	}
+
+	return tx.Commit()
 }
 
-// Authenticate auth's a user
-func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+// SetUserPassword hashes password and saves it as the user's password, revoking their API tokens as
+// a new password ends their sessions
+func (m *postgresDBRepo) SetUserPassword(id int, password string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var id int
-	var hashedPassword string
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
 
-	row := m.DB.QueryRowContext(ctx, "select id, password from users where email = $1", email)
-	err := row.Scan(&id, &hashedPassword)
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
+	_, err = tx.ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`,
+		hashedPassword, time.Now(), id)
 	if err != nil {
-		return id, "", err
+		return err
 	}
 
-	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	_, err = tx.ExecContext(ctx, `delete from api_tokens where user_id = $1`, id)
+	if err != nil {
+		return err
+	}
 
-	if err == bcrypt.ErrMismatchedHashAndPassword {
-		return 0, "", errors.New("incorrect password")
-	} else if err != nil {
-		return 0, "", err
+	return tx.Commit()
+}
+
+// hashPassword returns the bcrypt hash stored for password
+func hashPassword(password string) (string, error) {
+	hashedPassword, err := bcrypt.GenerateFromPassword([]byte(password), 12)
+	if err != nil {
+		return "", err
 	}
 
-	return id, hashedPassword, nil
+	return string(hashedPassword), nil
 }
 
-// AllReservations gets all reservations as a slice of Reservations
-func (m *postgresDBRepo) AllReservations() ([]models.Reservation, error) {
+// EnableTOTP turns on two-factor authentication for a user with secret, replacing any recovery
+// codes they had with new ones
+func (m *postgresDBRepo) EnableTOTP(userID int, secret string, recoveryCodeHashes []string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var reservations []models.Reservation
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `select r.id, r.first_name, r.last_name, 
-					 r.email, r.phone, r.start_date, 
-					 r.end_date, r.room_id, r.created_at, r.updated_at,
-					 r.processed, rm.id, rm.room_name
-				from reservations r
-				left join rooms rm on (r.room_id = rm.id)
-				order by r.start_date asc`
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = $1, totp_enabled = 1, totp_last_step = 0,
+		updated_at = $2 where id = $3`, secret, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodesTx(ctx, tx, userID, recoveryCodeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// DisableTOTP turns off two-factor authentication for a user and removes their recovery codes
+func (m *postgresDBRepo) DisableTOTP(userID int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	_, err = tx.ExecContext(ctx, `update users set totp_secret = '', totp_enabled = 0, totp_last_step = 0,
+		updated_at = $1 where id = $2`, time.Now(), userID)
+	if err != nil {
+		return err
+	}
+
+	err = replaceRecoveryCodesTx(ctx, tx, userID, nil)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// UseTOTPStep records that a user has logged in with the code for step. It returns false if they
+// already used that step's code, or a later one, so a code can't be replayed
+func (m *postgresDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update users set totp_last_step = $1 where id = $2 and totp_last_step < $1`,
+		step, userID)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n == 1, nil
+}
+
+// ReplaceRecoveryCodes replaces a user's recovery codes with new ones
+func (m *postgresDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	err = replaceRecoveryCodesTx(ctx, tx, userID, codeHashes)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// replaceRecoveryCodesTx deletes a user's recovery codes and inserts codeHashes in their place using tx
+func replaceRecoveryCodesTx(ctx context.Context, tx *sql.Tx, userID int, codeHashes []string) error {
+	_, err := tx.ExecContext(ctx, `delete from recovery_codes where user_id = $1`, userID)
+	if err != nil {
+		return err
+	}
+
+	for _, hash := range codeHashes {
+		_, err = tx.ExecContext(ctx, `insert into recovery_codes (user_id, code_hash, created_at, updated_at)
+			values ($1, $2, $3, $4)`, userID, hash, time.Now(), time.Now())
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// UseRecoveryCode uses up one of a user's recovery codes, returning false if they have no such unused code
+func (m *postgresDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	result, err := m.DB.ExecContext(ctx, `update recovery_codes set used_at = $1, updated_at = $1
+		where user_id = $2 and code_hash = $3 and used_at is null`, time.Now(), userID, codeHash)
+	if err != nil {
+		return false, err
+	}
+
+	n, err := result.RowsAffected()
+	if err != nil {
+		return false, err
+	}
+
+	return n > 0, nil
+}
+
+// CountRecoveryCodes returns how many unused recovery codes a user has left
+func (m *postgresDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var count int
+	err := m.DB.QueryRowContext(ctx, `select count(id) from recovery_codes where user_id = $1 and used_at is null`,
+		userID).Scan(&count)
+	if err != nil {
+		return 0, err
+	}
+
+	return count, nil
+}
+
+// RecentLoginFailures counts the failed logins since a time for an email address and from an IP address
+func (m *postgresDBRepo) RecentLoginFailures(email, ip string, since time.Time) (models.LoginFailures, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var f models.LoginFailures
+	var last sql.NullTime
+
+	query := `select count(id) filter (where email = $1), count(id) filter (where ip_address = $2), max(created_at)
+		from login_failures
+		where created_at > $3 and (email = $1 or ip_address = $2)`
+
+	err := m.DB.QueryRowContext(ctx, query, email, ip, since).Scan(&f.Account, &f.IP, &last)
+	if err != nil {
+		return f, err
+	}
+	f.Last = last.Time
+
+	return f, nil
+}
+
+// InsertLoginFailure records a failed login for an email address from an IP address
+func (m *postgresDBRepo) InsertLoginFailure(email, ip string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into login_failures (email, ip_address, created_at, updated_at) values ($1, $2, $3, $4)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, email, ip, time.Now(), time.Now())
+	return err
+}
+
+// DeleteLoginFailuresBefore forgets the failed logins made before a time
+func (m *postgresDBRepo) DeleteLoginFailuresBefore(t time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_failures where created_at < $1`, t)
+	return err
+}
+
+// ClearLoginFailures forgets the failed logins for an email address, once someone has logged in with it
+func (m *postgresDBRepo) ClearLoginFailures(email string) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from login_failures where email = $1`, email)
+	return err
+}
+
+// LockUser stops a user logging in until a time
+func (m *postgresDBRepo) LockUser(id int, until time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update users set locked_until = $1, updated_at = $2 where id = $3`,
+		until, time.Now(), id)
+	return err
+}
+
+// UnlockUser lets a locked user log in again straight away, forgetting their failed logins
+func (m *postgresDBRepo) UnlockUser(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var email string
+	err = tx.QueryRowContext(ctx, `update users set locked_until = null, updated_at = $1 where id = $2
+		returning email`, time.Now(), id).Scan(&email)
+	if err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from login_failures where email = $1`, strings.ToLower(email))
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// InsertAPIToken saves a new API token by the hash of its value
+func (m *postgresDBRepo) InsertAPIToken(t models.APIToken, tokenHash string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+
+	stmt := `insert into api_tokens (user_id, name, token_hash, scope, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		t.UserID,
+		t.Name,
+		tokenHash,
+		t.Scope,
+		t.ExpiresAt,
+		time.Now(),
+		time.Now(),
+	).Scan(&id)
+	if err != nil {
+		return 0, err
+	}
+
+	return id, nil
+}
+
+// rowScanner is a *sql.Row or *sql.Rows
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanAPIToken reads a row selecting an API token joined with its user
+func scanAPIToken(row rowScanner) (models.APIToken, error) {
+	var t models.APIToken
+	var lastUsedAt sql.NullTime
+
+	err := row.Scan(
+		&t.ID,
+		&t.UserID,
+		&t.Name,
+		&t.Scope,
+		&t.ExpiresAt,
+		&lastUsedAt,
+		&t.CreatedAt,
+		&t.UpdatedAt,
+		&t.User.FirstName,
+		&t.User.LastName,
+		&t.User.Email,
+	)
+	if err != nil {
+		return t, err
+	}
+	t.LastUsedAt = lastUsedAt.Time
+	t.User.ID = t.UserID
+
+	return t, nil
+}
+
+// GetAPITokenByID returns an API token
+func (m *postgresDBRepo) GetAPITokenByID(id int) (models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select t.id, t.user_id, t.name, t.scope, t.expires_at, t.last_used_at, t.created_at, t.updated_at,
+				u.first_name, u.last_name, u.email
+				from api_tokens t
+				left join users u on (t.user_id = u.id)
+				where t.id = $1`
+
+	return scanAPIToken(m.DB.QueryRowContext(ctx, query, id))
+}
+
+// AllAPITokens returns every user's API tokens, newest first
+func (m *postgresDBRepo) AllAPITokens() ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select t.id, t.user_id, t.name, t.scope, t.expires_at, t.last_used_at, t.created_at, t.updated_at,
+				u.first_name, u.last_name, u.email
+				from api_tokens t
+				left join users u on (t.user_id = u.id)
+				order by t.created_at desc`
+
+	return m.queryAPITokens(ctx, query)
+}
+
+// UserAPITokens returns a user's API tokens, newest first
+func (m *postgresDBRepo) UserAPITokens(userID int) ([]models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select t.id, t.user_id, t.name, t.scope, t.expires_at, t.last_used_at, t.created_at, t.updated_at,
+				u.first_name, u.last_name, u.email
+				from api_tokens t
+				left join users u on (t.user_id = u.id)
+				where t.user_id = $1
+				order by t.created_at desc`
+
+	return m.queryAPITokens(ctx, query, userID)
+}
+
+// queryAPITokens runs a query selecting API tokens joined with their users
+func (m *postgresDBRepo) queryAPITokens(ctx context.Context, query string, args ...interface{}) ([]models.APIToken, error) {
+	var tokens []models.APIToken
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return tokens, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		t, err := scanAPIToken(rows)
+		if err != nil {
+			return tokens, err
+		}
+		tokens = append(tokens, t)
+	}
+
+	if err = rows.Err(); err != nil {
+		return tokens, err
+	}
+
+	return tokens, nil
+}
+
+// UseAPIToken returns the unexpired API token with a hash, and notes that it was used. It returns
+// repository.ErrInvalidToken when there's no such token
+func (m *postgresDBRepo) UseAPIToken(tokenHash string) (models.APIToken, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `with t as (
+					update api_tokens set last_used_at = $1
+					where token_hash = $2 and expires_at > $1
+					returning *
+				)
+				select t.id, t.user_id, t.name, t.scope, t.expires_at, t.last_used_at, t.created_at, t.updated_at,
+				u.first_name, u.last_name, u.email
+				from t
+				left join users u on (t.user_id = u.id)`
+
+	t, err := scanAPIToken(m.DB.QueryRowContext(ctx, query, time.Now(), tokenHash))
+	if errors.Is(err, sql.ErrNoRows) {
+		return t, repository.ErrInvalidToken
+	}
+
+	return t, err
+}
+
+// DeleteAPIToken revokes an API token
+func (m *postgresDBRepo) DeleteAPIToken(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from api_tokens where id = $1`, id)
+	return err
+}
+
+// InsertPasswordResetToken saves the hash of a password reset token sent to a user
+func (m *postgresDBRepo) InsertPasswordResetToken(userID int, tokenHash string, expires time.Time) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `insert into password_reset_tokens (user_id, token_hash, expires_at, created_at, updated_at)
+		values ($1, $2, $3, $4, $5)`
+
+	_, err := m.DB.ExecContext(ctx, stmt, userID, tokenHash, expires, time.Now(), time.Now())
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// GetUserByResetToken returns the active user a password reset token was sent to, or ErrInvalidToken
+// if the token doesn't exist, has expired or was used
+func (m *postgresDBRepo) GetUserByResetToken(tokenHash string) (models.User, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var u models.User
+
+	query := `select u.id, u.first_name, u.last_name, u.email
+				from password_reset_tokens t
+				left join users u on (u.id = t.user_id)
+				where t.token_hash = $1 and t.used_at is null and t.expires_at > $2 and u.active = 1`
+
+	err := m.DB.QueryRowContext(ctx, query, tokenHash, time.Now()).Scan(
+		&u.ID,
+		&u.FirstName,
+		&u.LastName,
+		&u.Email,
+	)
+	if errors.Is(err, sql.ErrNoRows) {
+		return u, repository.ErrInvalidToken
+	}
+	if err != nil {
+		return u, err
+	}
+
+	return u, nil
+}
+
+// ResetPassword sets a new password for the user a password reset token was sent to and uses up
+// the token, along with any others sent to that user, and revokes their API tokens. It returns the
+// user's id, or ErrInvalidToken if the token doesn't exist, has expired or was used
+func (m *postgresDBRepo) ResetPassword(tokenHash, password string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	// lock the token so two requests can't both use it
+	var userID int
+	query := `select t.user_id from password_reset_tokens t
+				left join users u on (u.id = t.user_id)
+				where t.token_hash = $1 and t.used_at is null and t.expires_at > $2 and u.active = 1
+				for update of t`
+
+	err = tx.QueryRowContext(ctx, query, tokenHash, time.Now()).Scan(&userID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, repository.ErrInvalidToken
+	}
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update users set password = $1, updated_at = $2 where id = $3`,
+		hashedPassword, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `update password_reset_tokens set used_at = $1, updated_at = $1
+		where user_id = $2 and used_at is null`, time.Now(), userID)
+	if err != nil {
+		return 0, err
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from api_tokens where user_id = $1`, userID)
+	if err != nil {
+		return 0, err
+	}
+
+	if err = tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return userID, nil
+}
+
+// translateEmailError turns a unique violation on the users or guests email index into ErrEmailTaken
+func translateEmailError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" &&
+		(pgErr.ConstraintName == "users_email_idx" || pgErr.ConstraintName == "guests_email_idx") {
+		return repository.ErrEmailTaken
+	}
+
+	return err
+}
+
+// Authenticate auth's a user
+func (m *postgresDBRepo) Authenticate(email, testPassword string) (int, string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id, active int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password, active from users where email = $1", email)
+	err := row.Scan(&id, &hashedPassword, &active)
+
+	if err != nil {
+		return id, "", err
+	}
+
+	if active == 0 {
+		return 0, "", errors.New("user is deactivated")
+	}
+
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, "", errors.New("incorrect password")
+	} else if err != nil {
+		return 0, "", err
+	}
+
+	return id, hashedPassword, nil
+}
+
+// reservationSorts maps the sorts offered in the admin lists to their order by columns
+var reservationSorts = map[string][]string{
+	"arrival": {"r.start_date"},
+	"created": {"r.created_at"},
+	"name":    {"r.last_name", "r.first_name"},
+}
+
+// SearchReservations returns one page of the reservations matching f, and how many match in all
+func (m *postgresDBRepo) SearchReservations(f models.ReservationFilter) ([]models.Reservation, int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var reservations []models.Reservation
+
+	where := []string{"true"}
+	var args []interface{}
+	arg := func(v interface{}) string {
+		args = append(args, v)
+		return fmt.Sprintf("$%d", len(args))
+	}
+
+	if f.Search != "" {
+		escaped := strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(f.Search)
+		p := arg("%" + escaped + "%")
+		where = append(where, fmt.Sprintf(`((r.first_name || ' ' || r.last_name) ilike %[1]s
+			or r.email ilike %[1]s or r.phone ilike %[1]s)`, p))
+	}
+
+	if f.RoomID > 0 {
+		where = append(where, "r.room_id = "+arg(f.RoomID))
+	}
+
+	if !f.From.IsZero() {
+		where = append(where, "r.end_date > "+arg(f.From))
+	}
+
+	if !f.To.IsZero() {
+		where = append(where, "r.start_date <= "+arg(f.To))
+	}
+
+	if f.Processed >= 0 {
+		where = append(where, "r.processed = "+arg(f.Processed))
+	}
+
+	conditions := strings.Join(where, " and ")
+
+	var total int
+	err := m.DB.QueryRowContext(ctx, "select count(*) from reservations r where "+conditions, args...).Scan(&total)
+	if err != nil {
+		return reservations, 0, err
+	}
+
+	columns, ok := reservationSorts[f.Sort]
+	if !ok {
+		columns = reservationSorts["arrival"]
+	}
+
+	var order []string
+	for _, c := range append(columns, "r.id") {
+		if f.Desc {
+			c += " desc"
+		}
+		order = append(order, c)
+	}
+
+	if f.PerPage < 1 {
+		f.PerPage = 25
+	}
+	if f.Page < 1 {
+		f.Page = 1
+	}
+
+	query := `select r.id, r.first_name, r.last_name, 
+					 r.email, r.phone, r.start_date, 
+					 r.end_date, r.room_id, r.created_at, r.updated_at,
+					 r.processed, r.confirmation_code, r.cancelled, coalesce(r.group_id, 0),
+					 rm.id, rm.room_name
+				from reservations r
+				left join rooms rm on (r.room_id = rm.id)
+				where ` + conditions + `
+				order by ` + strings.Join(order, ", ") + `
+				limit ` + arg(f.PerPage) + ` offset ` + arg((f.Page-1)*f.PerPage)
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return reservations, 0, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var i models.Reservation
+
+		err := rows.Scan(
+			&i.ID,
+			&i.FirstName,
+			&i.LastName,
+			&i.Email,
+			&i.Phone,
+			&i.StartDate,
+			&i.EndDate,
+			&i.RoomID,
+			&i.CreatedAt,
+			&i.UpdatedAt,
+			&i.Processed,
+			&i.ConfirmationCode,
+			&i.Cancelled,
+			&i.GroupID,
+			&i.Room.ID,
+			&i.Room.RoomName,
+		)
+
+		if err != nil {
+			return reservations, 0, err
+		}
+
+		reservations = append(reservations, i)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, 0, err
+	}
+
+	return reservations, total, nil
+}
+
+// DashboardStats gathers the figures for the admin dashboard as of today. Cancelled reservations
+// are left out of all of them
+func (m *postgresDBRepo) DashboardStats(today time.Time) (models.DashboardStats, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var stats models.DashboardStats
+
+	query := `select
+		count(*) filter (where start_date = $1),
+		count(*) filter (where end_date = $1),
+		coalesce(sum(adults + children) filter (where start_date <= $1 and end_date > $1), 0),
+		count(*) filter (where processed = 0),
+		coalesce(sum(total_price) filter (where start_date >= $1 and start_date < $1::date + 30), 0),
+		coalesce(avg(end_date - start_date) filter (where created_at >= $1::date - $2::int), 0),
+		coalesce(avg(start_date - created_at::date) filter (where created_at >= $1::date - $2::int), 0)
+	from reservations
+	where cancelled = 0`
+
+	err := m.DB.QueryRowContext(ctx, query, today, models.StatsWindow).Scan(
+		&stats.Arrivals,
+		&stats.Departures,
+		&stats.InHouse,
+		&stats.NewReservations,
+		&stats.RevenueNext30,
+		&stats.AvgLengthOfStay,
+		&stats.AvgLeadTime,
+	)
+	if err != nil {
+		return stats, err
+	}
+
+	// nights reserved in each of the next 30 days, against the rooms that can be booked
+	query = `select d::date, count(r.id), (select count(id) from rooms where archived = 0)
+	from generate_series($1::date, $1::date + 29, interval '1 day') d
+	left join reservations r on r.cancelled = 0 and r.start_date <= d and r.end_date > d
+		and r.room_id in (select id from rooms where archived = 0)
+	group by d
+	order by d`
+
+	rows, err := m.DB.QueryContext(ctx, query, today)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var d models.DailyOccupancy
+		err = rows.Scan(&d.Date, &d.Occupied, &d.Rooms)
+		if err != nil {
+			return stats, err
+		}
+		stats.Occupancy = append(stats.Occupancy, d)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+
+	// revenue by month of arrival, for the last 12 months including this one
+	query = `select m::date, coalesce(sum(r.total_price), 0)
+	from generate_series(date_trunc('month', $1::date) - interval '11 months', date_trunc('month', $1::date),
+		interval '1 month') m
+	left join reservations r on r.cancelled = 0 and date_trunc('month', r.start_date) = m
+	group by m
+	order by m`
+
+	rows, err = m.DB.QueryContext(ctx, query, today)
+	if err != nil {
+		return stats, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var mr models.MonthlyRevenue
+		err = rows.Scan(&mr.Month, &mr.Revenue)
+		if err != nil {
+			return stats, err
+		}
+		stats.Revenue = append(stats.Revenue, mr)
+	}
+
+	if err = rows.Err(); err != nil {
+		return stats, err
+	}
+
+	return stats, nil
+}
+
+func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var res models.Reservation
+	var cancelledAt sql.NullTime
+
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+				r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.confirmation_code,
+				r.cancelled, r.cancelled_at, r.cancellation_reason, r.refund_percent, r.total_price,
+				r.adults, r.children, coalesce(r.group_id, 0), r.source, r.override_reason, rm.id, rm.room_name
+				from reservations r
+				left join rooms rm on (r.room_id = rm.id)
+				where r.id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, id)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.ConfirmationCode,
+		&res.Cancelled,
+		&cancelledAt,
+		&res.CancellationReason,
+		&res.RefundPercent,
+		&res.TotalPrice,
+		&res.Adults,
+		&res.Children,
+		&res.GroupID,
+		&res.Source,
+		&res.OverrideReason,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	if err != nil {
+		return res, err
+	}
+
+	res.CancelledAt = cancelledAt.Time
+
+	return res, nil
+}
+
+// GetReservationByCode gets a reservation by its confirmation code
+func (m *postgresDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var res models.Reservation
+	var cancelledAt sql.NullTime
+
+	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
+				r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, r.confirmation_code,
+				r.cancelled, r.cancelled_at, r.cancellation_reason, r.refund_percent, r.total_price,
+				r.adults, r.children, coalesce(r.group_id, 0), coalesce(r.guest_id, 0), rm.id, rm.room_name
+				from reservations r
+				left join rooms rm on (r.room_id = rm.id)
+				where r.confirmation_code = upper($1)`
+
+	row := m.DB.QueryRowContext(ctx, query, code)
+	err := row.Scan(
+		&res.ID,
+		&res.FirstName,
+		&res.LastName,
+		&res.Email,
+		&res.Phone,
+		&res.StartDate,
+		&res.EndDate,
+		&res.RoomID,
+		&res.CreatedAt,
+		&res.UpdatedAt,
+		&res.Processed,
+		&res.ConfirmationCode,
+		&res.Cancelled,
+		&cancelledAt,
+		&res.CancellationReason,
+		&res.RefundPercent,
+		&res.TotalPrice,
+		&res.Adults,
+		&res.Children,
+		&res.GroupID,
+		&res.GuestID,
+		&res.Room.ID,
+		&res.Room.RoomName,
+	)
+	if err != nil {
+		return res, err
+	}
+
+	res.CancelledAt = cancelledAt.Time
+
+	return res, nil
+}
+
+// UpdateReservation updates a reservation's guest, dates, room and total, and moves its room
+// restriction and its group's total along with it in the same transaction. It returns
+// ErrRoomUnavailable if the room is already booked or blocked on any of the new nights
+func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4,
+				start_date = $5, end_date = $6, room_id = $7, total_price = $8, updated_at = $9
+				where id = $10`
+
+	_, err = tx.ExecContext(ctx, query,
+		u.FirstName,
+		u.LastName,
+		u.Email,
+		u.Phone,
+		u.StartDate,
+		u.EndDate,
+		u.RoomID,
+		u.TotalPrice,
+		time.Now(),
+		u.ID,
+	)
+	if err != nil {
+		return err
+	}
+
+	// a group's total is the sum of its reservations, so it changes with any of them
+	query = `update reservation_groups set updated_at = $1,
+				total_price = (select coalesce(sum(total_price), 0) from reservations where group_id = reservation_groups.id)
+				where id = (select group_id from reservations where id = $2)`
+
+	_, err = tx.ExecContext(ctx, query, time.Now(), u.ID)
+	if err != nil {
+		return err
+	}
+
+	err = deleteExpiredHoldsTx(ctx, tx, u.RoomID)
+	if err != nil {
+		return err
+	}
+
+	// the restriction is moved rather than checked first, so the stay never clashes with itself
+	query = `update room_restrictions set start_date = $1, end_date = $2, room_id = $3, updated_at = $4
+				where reservation_id = $5 and restriction_id = $6`
+
+	_, err = tx.ExecContext(ctx, query,
+		u.StartDate,
+		u.EndDate,
+		u.RoomID,
+		time.Now(),
+		u.ID,
+		models.RestrictionReservation,
+	)
+	if err != nil {
+		return translateOverlapError(err)
+	}
+
+	if err = tx.Commit(); err != nil {
+		return translateOverlapError(err)
+	}
+
+	return nil
+}
+
+func (m *postgresDBRepo) DeleteReservation(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `delete from reservations where id = $1`
+
+	_, err := m.DB.ExecContext(ctx, query, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// CancelReservation marks a reservation as cancelled and releases its room restriction
+func (m *postgresDBRepo) CancelReservation(id int, reason string, refundPercent int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	query := `update reservations set cancelled = 1, cancelled_at = $1, cancellation_reason = $2,
+				refund_percent = $3, updated_at = $1
+				where id = $4 and cancelled = 0`
+
+	result, err := tx.ExecContext(ctx, query, time.Now(), reason, refundPercent, id)
+	if err != nil {
+		return err
+	}
+
+	rows, err := result.RowsAffected()
+	if err != nil {
+		return err
+	}
+
+	if rows == 0 {
+		return errors.New("reservation does not exist or is already cancelled")
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from room_restrictions where reservation_id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetCancellationPolicyForRoom gets the cancellation policy that applies to a room
+func (m *postgresDBRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var p models.CancellationPolicy
+
+	query := `select cp.id, cp.name, cp.description, cp.free_cancellation_days, cp.late_refund_percent,
+				cp.created_at, cp.updated_at
+				from rooms r
+				join cancellation_policies cp on (r.cancellation_policy_id = cp.id)
+				where r.id = $1`
+
+	row := m.DB.QueryRowContext(ctx, query, roomID)
+	err := row.Scan(
+		&p.ID,
+		&p.Name,
+		&p.Description,
+		&p.FreeCancellationDays,
+		&p.LateRefundPercent,
+		&p.CreatedAt,
+		&p.UpdatedAt,
+	)
+
+	if err == sql.ErrNoRows {
+		return defaultCancellationPolicy, nil
+	}
+
+	if err != nil {
+		return p, err
+	}
+
+	return p, nil
+}
+
+func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `update reservations set processed = $1 where id = $2`
+
+	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_name, base_rate, weekend_rate, max_adults, max_children, archived,
+				slug, description, amenities, image, created_at, updated_at
+				from rooms where archived = 0 order by room_name`
+
+	return m.queryRooms(ctx, query)
+}
+
+// GetRoomBySlug gets an active room by the slug used in its public url
+func (m *postgresDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_name, base_rate, weekend_rate, max_adults, max_children, archived,
+				slug, description, amenities, image, created_at, updated_at
+				from rooms where slug = $1 and archived = 0`
+
+	rooms, err := m.queryRooms(ctx, query, slug)
+	if err != nil {
+		return models.Room{}, err
+	}
+
+	if len(rooms) == 0 {
+		return models.Room{}, sql.ErrNoRows
+	}
+
+	return rooms[0], nil
+}
+
+// RoomPhotos returns a room's photos in display order
+func (m *postgresDBRepo) RoomPhotos(roomID int) ([]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_id, file_name, caption, sort_order, created_at, updated_at
+				from room_photos where room_id = $1 order by sort_order, id`
+
+	return m.queryRoomPhotos(ctx, query, roomID)
+}
+
+// CoverPhotos returns the first photo of every room that has photos, keyed by room id
+func (m *postgresDBRepo) CoverPhotos() (map[int]models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select distinct on (room_id) id, room_id, file_name, caption, sort_order, created_at, updated_at
+				from room_photos order by room_id, sort_order, id`
+
+	photos, err := m.queryRoomPhotos(ctx, query)
+	if err != nil {
+		return nil, err
+	}
+
+	covers := make(map[int]models.RoomPhoto)
+	for _, p := range photos {
+		covers[p.RoomID] = p
+	}
+
+	return covers, nil
+}
+
+// GetRoomPhotoByID gets a room photo by id
+func (m *postgresDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_id, file_name, caption, sort_order, created_at, updated_at
+				from room_photos where id = $1`
+
+	photos, err := m.queryRoomPhotos(ctx, query, id)
+	if err != nil {
+		return models.RoomPhoto{}, err
+	}
+
+	if len(photos) == 0 {
+		return models.RoomPhoto{}, sql.ErrNoRows
+	}
+
+	return photos[0], nil
+}
+
+// queryRoomPhotos runs a query selecting room photos
+func (m *postgresDBRepo) queryRoomPhotos(ctx context.Context, query string, args ...interface{}) ([]models.RoomPhoto, error) {
+	var photos []models.RoomPhoto
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return photos, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var p models.RoomPhoto
+
+		err := rows.Scan(
+			&p.ID,
+			&p.RoomID,
+			&p.FileName,
+			&p.Caption,
+			&p.SortOrder,
+			&p.CreatedAt,
+			&p.UpdatedAt,
+		)
+		if err != nil {
+			return photos, err
+		}
+
+		photos = append(photos, p)
+	}
+
+	if err = rows.Err(); err != nil {
+		return photos, err
+	}
+
+	return photos, nil
+}
+
+// InsertRoomPhoto adds a photo after the room's other photos and returns its id
+func (m *postgresDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into room_photos (room_id, file_name, caption, sort_order, created_at, updated_at)
+				values ($1, $2, $3,
+					(select coalesce(max(sort_order), 0) + 1 from room_photos where room_id = $1),
+					$4, $5)
+				returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		p.RoomID,
+		p.FileName,
+		p.Caption,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, err
+	}
+
+	return newID, nil
+}
+
+// UpdateRoomPhoto updates a photo's caption and position
+func (m *postgresDBRepo) UpdateRoomPhoto(p models.RoomPhoto) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update room_photos set caption = $1, sort_order = $2, updated_at = $3 where id = $4`
+
+	_, err := m.DB.ExecContext(ctx, stmt, p.Caption, p.SortOrder, time.Now(), p.ID)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRoomPhoto deletes a room photo's record. The caller removes its files from storage
+func (m *postgresDBRepo) DeleteRoomPhoto(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `delete from room_photos where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// AllRoomsIncludingArchived returns every room, archived rooms last
+func (m *postgresDBRepo) AllRoomsIncludingArchived() ([]models.Room, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, room_name, base_rate, weekend_rate, max_adults, max_children, archived,
+				slug, description, amenities, image, created_at, updated_at
+				from rooms order by archived, room_name`
+
+	return m.queryRooms(ctx, query)
+}
+
+// queryRooms runs a query selecting rooms
+func (m *postgresDBRepo) queryRooms(ctx context.Context, query string, args ...interface{}) ([]models.Room, error) {
+	var rooms []models.Room
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
+	if err != nil {
+		return rooms, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var room models.Room
+
+		err := rows.Scan(
+			&room.ID,
+			&room.RoomName,
+			&room.BaseRate,
+			&room.WeekendRate,
+			&room.MaxAdults,
+			&room.MaxChildren,
+			&room.Archived,
+			&room.Slug,
+			&room.Description,
+			&room.Amenities,
+			&room.Image,
+			&room.CreatedAt,
+			&room.UpdatedAt,
+		)
+		if err != nil {
+			return rooms, err
+		}
+
+		rooms = append(rooms, room)
+	}
+
+	if err = rows.Err(); err != nil {
+		return rooms, err
+	}
+
+	return rooms, nil
+}
+
+// InsertRoom adds a room and returns its id
+func (m *postgresDBRepo) InsertRoom(room models.Room) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var newID int
+
+	stmt := `insert into rooms (room_name, base_rate, weekend_rate, max_adults, max_children,
+				slug, description, amenities, image, created_at, updated_at)
+				values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10, $11) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		room.RoomName,
+		room.BaseRate,
+		room.WeekendRate,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.Slug,
+		room.Description,
+		room.Amenities,
+		room.Image,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, translateSlugError(err)
+	}
+
+	return newID, nil
+}
+
+// UpdateRoom updates a room's name, rates, capacity and details
+func (m *postgresDBRepo) UpdateRoom(room models.Room) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	stmt := `update rooms set room_name = $1, base_rate = $2, weekend_rate = $3, max_adults = $4,
+				max_children = $5, slug = $6, description = $7, amenities = $8, image = $9, updated_at = $10
+				where id = $11`
+
+	_, err := m.DB.ExecContext(ctx, stmt,
+		room.RoomName,
+		room.BaseRate,
+		room.WeekendRate,
+		room.MaxAdults,
+		room.MaxChildren,
+		room.Slug,
+		room.Description,
+		room.Amenities,
+		room.Image,
+		time.Now(),
+		room.ID,
+	)
+	if err != nil {
+		return translateSlugError(err)
+	}
+
+	return nil
+}
+
+// translateSlugError turns a violation of the unique index on rooms.slug into ErrSlugTaken
+func translateSlugError(err error) error {
+	var pgErr *pgconn.PgError
+	if errors.As(err, &pgErr) && pgErr.Code == "23505" && pgErr.ConstraintName == "rooms_slug_idx" {
+		return repository.ErrSlugTaken
+	}
+
+	return err
+}
+
+// ArchiveRoom archives (1) or restores (0) a room. Archived rooms can't be found or booked
+func (m *postgresDBRepo) ArchiveRoom(id, archived int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	_, err := m.DB.ExecContext(ctx, `update rooms set archived = $1, updated_at = $2 where id = $3`,
+		archived, time.Now(), id)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// DeleteRoom deletes a room that has never been booked. It returns ErrRoomInUse if the room has any
+// reservations, past or future, or restrictions ending today or later, so the booking history is kept
+func (m *postgresDBRepo) DeleteRoom(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	// lock the room so nothing can be booked in it while we check
+	var roomID int
+	err = tx.QueryRowContext(ctx, `select id from rooms where id = $1 for update`, id).Scan(&roomID)
+	if err != nil {
+		return err
+	}
+
+	var inUse int
+	query := `select
+			(select count(id) from reservations where room_id = $1) +
+			(select count(id) from room_restrictions where room_id = $1 and end_date >= current_date)`
+
+	err = tx.QueryRowContext(ctx, query, id).Scan(&inUse)
+	if err != nil {
+		return err
+	}
+
+	if inUse > 0 {
+		return repository.ErrRoomInUse
+	}
+
+	_, err = tx.ExecContext(ctx, `delete from rooms where id = $1`, id)
+	if err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// GetRoomRestrictionsByDate returns the reservations, blocks and live holds of every room that fall on
+// any day from start up to, but not including, end. Reservations come with their guest
+func (m *postgresDBRepo) GetRoomRestrictionsByDate(start, end time.Time) ([]models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-	rows, err := m.DB.QueryContext(ctx, query)
+	var restrictions []models.RoomRestriction
+
+	query := `select rr.id, coalesce(rr.reservation_id, 0) as reservation_id, rr.restriction_id, rr.room_id,
+				rr.start_date, rr.end_date, rr.expires_at, rr.reason,
+				coalesce(r.first_name, ''), coalesce(r.last_name, ''), coalesce(r.processed, 0)
+				from room_restrictions rr
+				left join reservations r on (r.id = rr.reservation_id)
+				where $1 < rr.end_date and $2 > rr.start_date
+				and (rr.expires_at is null or rr.expires_at > $3)
+				order by rr.room_id, rr.start_date`
+
+	rows, err := m.DB.QueryContext(ctx, query, start, end, time.Now())
 	if err != nil {
-		return reservations, err
+		return nil, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var i models.Reservation
+		var r models.RoomRestriction
+		var expiresAt sql.NullTime
 
 		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
+			&r.ID,
+			&r.ReservationID,
+			&r.RestrictionID,
+			&r.RoomID,
+			&r.StartDate,
+			&r.EndDate,
+			&expiresAt,
+			&r.Reason,
+			&r.Reservation.FirstName,
+			&r.Reservation.LastName,
+			&r.Reservation.Processed,
 		)
 
 		if err != nil {
-			return reservations, err
+			return restrictions, err
 		}
 
-		reservations = append(reservations, i)
+		r.ExpiresAt = expiresAt.Time
+		r.Reservation.ID = r.ReservationID
+		restrictions = append(restrictions, r)
 	}
 
 	if err = rows.Err(); err != nil {
-		return reservations, err
+		return restrictions, err
 	}
 
-	return reservations, nil
+	return restrictions, nil
 }
 
-// AllReservations gets all reservations as a slice of Reservations
-func (m *postgresDBRepo) AllNewReservations() ([]models.Reservation, error) {
+// InsertBlocks blocks each of roomIDs from start until end, the day the block lifts, in a single
+// transaction. If any room is already booked or blocked it blocks none and returns ErrRoomUnavailable
+func (m *postgresDBRepo) InsertBlocks(roomIDs []int, start, end time.Time, reason string) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var reservations []models.Reservation
-
-	query := `select r.id, r.first_name, r.last_name, 
-					 r.email, r.phone, r.start_date, 
-					 r.end_date, r.room_id, r.created_at, r.updated_at,
-					 r.processed, rm.id, rm.room_name
-				from reservations r
-				left join rooms rm on (r.room_id = rm.id)
-				where r.processed = 0
-				order by r.start_date asc`
-
-	rows, err := m.DB.QueryContext(ctx, query)
+	tx, err := m.DB.BeginTx(ctx, nil)
 	if err != nil {
-		return reservations, err
+		return err
 	}
-	defer rows.Close()
-
-	for rows.Next() {
-		var i models.Reservation
+	defer tx.Rollback()
 
-		err := rows.Scan(
-			&i.ID,
-			&i.FirstName,
-			&i.LastName,
-			&i.Email,
-			&i.Phone,
-			&i.StartDate,
-			&i.EndDate,
-			&i.RoomID,
-			&i.CreatedAt,
-			&i.UpdatedAt,
-			&i.Processed,
-			&i.Room.ID,
-			&i.Room.RoomName,
-		)
+	query := `insert into room_restrictions 
+	(start_date, end_date, room_id, restriction_id, reason, created_at, updated_at)
+	values ($1, $2, $3, $4, $5, $6, $7)`
 
+	for _, roomID := range roomIDs {
+		err = deleteExpiredHoldsTx(ctx, tx, roomID)
 		if err != nil {
-			return reservations, err
+			return err
 		}
 
-		reservations = append(reservations, i)
+		_, err = tx.ExecContext(ctx, query,
+			start,
+			end,
+			roomID,
+			models.RestrictionOwnerBlock,
+			reason,
+			time.Now(),
+			time.Now(),
+		)
+		if err != nil {
+			return translateOverlapError(err)
+		}
 	}
 
-	if err = rows.Err(); err != nil {
-		return reservations, err
+	return tx.Commit()
+}
+
+// GetBlockByID gets an owner block by its room restriction id
+func (m *postgresDBRepo) GetBlockByID(id int) (models.RoomRestriction, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var b models.RoomRestriction
+
+	query := `select rr.id, rr.room_id, rr.restriction_id, rr.start_date, rr.end_date, rr.reason,
+				rr.created_at, rr.updated_at, r.id, r.room_name
+				from room_restrictions rr
+				left join rooms r on (r.id = rr.room_id)
+				where rr.id = $1 and rr.restriction_id = $2`
+
+	err := m.DB.QueryRowContext(ctx, query, id, models.RestrictionOwnerBlock).Scan(
+		&b.ID,
+		&b.RoomID,
+		&b.RestrictionID,
+		&b.StartDate,
+		&b.EndDate,
+		&b.Reason,
+		&b.CreatedAt,
+		&b.UpdatedAt,
+		&b.Room.ID,
+		&b.Room.RoomName,
+	)
+	if err != nil {
+		return b, err
 	}
 
-	return reservations, nil
+	return b, nil
 }
 
-func (m *postgresDBRepo) GetReservationByID(id int) (models.Reservation, error) {
+// UpdateBlock moves an owner block's dates and changes its reason. It returns ErrRoomUnavailable if
+// the new dates run into a reservation or another block
+func (m *postgresDBRepo) UpdateBlock(b models.RoomRestriction) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var res models.Reservation
+	tx, err := m.DB.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
 
-	query := `select r.id, r.first_name, r.last_name, r.email, r.phone, r.start_date,
-				r.end_date, r.room_id, r.created_at, r.updated_at, r.processed, rm.id, rm.room_name
-				from reservations r
-				left join rooms rm on (r.room_id = rm.id)
-				where r.id = $1`
+	err = deleteExpiredHoldsTx(ctx, tx, b.RoomID)
+	if err != nil {
+		return err
+	}
 
-	row := m.DB.QueryRowContext(ctx, query, id)
-	err := row.Scan(
-		&res.ID,
-		&res.FirstName,
-		&res.LastName,
-		&res.Email,
-		&res.Phone,
-		&res.StartDate,
-		&res.EndDate,
-		&res.RoomID,
-		&res.CreatedAt,
-		&res.UpdatedAt,
-		&res.Processed,
-		&res.Room.ID,
-		&res.Room.RoomName,
+	stmt := `update room_restrictions set start_date = $1, end_date = $2, reason = $3, updated_at = $4
+				where id = $5 and restriction_id = $6`
+
+	_, err = tx.ExecContext(ctx, stmt,
+		b.StartDate,
+		b.EndDate,
+		b.Reason,
+		time.Now(),
+		b.ID,
+		models.RestrictionOwnerBlock,
 	)
 	if err != nil {
-		return res, err
+		return translateOverlapError(err)
 	}
 
-	return res, nil
+	return tx.Commit()
 }
 
-// UpdateReservation updates a reservation
-func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
+func (m *postgresDBRepo) DeleteBlockByID(room_restriction_id int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update reservations set first_name = $1, last_name = $2, email = $3, phone = $4, updated_at=$5
-				where id = $6`
-
-	_, err := m.DB.ExecContext(ctx, query,
-		u.FirstName,
-		u.LastName,
-		u.Email,
-		u.Phone,
-		time.Now(),
-		u.ID,
-	)
+	query := `delete from room_restrictions where id = $1`
 
+	_, err := m.DB.ExecContext(ctx, query, room_restriction_id)
 	if err != nil {
 		return err
 	}
@@ -409,138 +2610,306 @@ func (m *postgresDBRepo) UpdateReservation(u models.Reservation) error {
 	return nil
 }
 
-func (m *postgresDBRepo) DeleteReservation(id int) error {
+// InsertWaitlistEntry adds a guest to the end of the waitlist
+func (m *postgresDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from reservations where id = $1`
+	var newID int
 
-	_, err := m.DB.ExecContext(ctx, query, id)
+	// an entry without a room is happy with any room
+	roomID := sql.NullInt64{Int64: int64(e.RoomID), Valid: e.RoomID > 0}
+
+	stmt := `insert into waitlist_entries (first_name, last_name, email, start_date, end_date,
+		room_id, adults, children, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7, $8, $9, $10) returning id`
+
+	err := m.DB.QueryRowContext(ctx, stmt,
+		e.FirstName,
+		e.LastName,
+		e.Email,
+		e.StartDate,
+		e.EndDate,
+		roomID,
+		e.Adults,
+		e.Children,
+		time.Now(),
+		time.Now(),
+	).Scan(&newID)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	return newID, nil
 }
 
-func (m *postgresDBRepo) UpdateProcessedForReservation(id, processed int) error {
+// GetWaitlistEntryByID returns a waitlist entry by id
+func (m *postgresDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `update reservations set processed = $1 where id = $2`
-
-	_, err := m.DB.ExecContext(ctx, query, processed, id)
+	var e models.WaitlistEntry
+	var notifiedAt sql.NullTime
+
+	query := `select id, first_name, last_name, email, start_date, end_date, coalesce(room_id, 0),
+				adults, children, notified_at, created_at, updated_at
+				from waitlist_entries where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&e.ID,
+		&e.FirstName,
+		&e.LastName,
+		&e.Email,
+		&e.StartDate,
+		&e.EndDate,
+		&e.RoomID,
+		&e.Adults,
+		&e.Children,
+		&notifiedAt,
+		&e.CreatedAt,
+		&e.UpdatedAt,
+	)
 	if err != nil {
-		return err
+		return e, err
 	}
 
-	return nil
+	e.NotifiedAt = notifiedAt.Time
+
+	return e, nil
 }
 
-func (m *postgresDBRepo) AllRooms() ([]models.Room, error) {
+// WaitlistEntriesForDates returns the waitlist entries, oldest first, that have not been notified yet,
+// are still in the future, overlap the given dates and either want roomID or any room
+func (m *postgresDBRepo) WaitlistEntriesForDates(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var rooms []models.Room
+	query := `select id, first_name, last_name, email, start_date, end_date, coalesce(room_id, 0),
+				adults, children, coalesce(offered_room_id, 0), created_at, updated_at
+				from waitlist_entries
+				where notified_at is null
+				and (room_id is null or room_id = $1)
+				and start_date < $3 and end_date > $2
+				and start_date >= current_date
+				order by created_at, id`
 
-	query := `select id, room_name, created_at, updated_at from rooms order by room_name`
+	return m.queryWaitlistEntries(ctx, query, roomID, start, end)
+}
 
-	rows, err := m.DB.QueryContext(ctx, query)
+// LapsedWaitlistOffers returns the waitlist entries offered a room before notifiedBefore whose offer
+// has not been passed on yet
+func (m *postgresDBRepo) LapsedWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	query := `select id, first_name, last_name, email, start_date, end_date, coalesce(room_id, 0),
+				adults, children, coalesce(offered_room_id, 0), created_at, updated_at
+				from waitlist_entries
+				where offered_room_id is not null
+				and notified_at < $1
+				order by notified_at, id`
+
+	return m.queryWaitlistEntries(ctx, query, notifiedBefore)
+}
+
+// queryWaitlistEntries runs a query selecting waitlist entries
+func (m *postgresDBRepo) queryWaitlistEntries(ctx context.Context, query string, args ...interface{}) ([]models.WaitlistEntry, error) {
+	var entries []models.WaitlistEntry
+
+	rows, err := m.DB.QueryContext(ctx, query, args...)
 	if err != nil {
-		return rooms, err
+		return entries, err
 	}
 	defer rows.Close()
 
 	for rows.Next() {
-		var room models.Room
-
+		var e models.WaitlistEntry
 		err := rows.Scan(
-			&room.ID,
-			&room.RoomName,
-			&room.CreatedAt,
-			&room.UpdatedAt,
+			&e.ID,
+			&e.FirstName,
+			&e.LastName,
+			&e.Email,
+			&e.StartDate,
+			&e.EndDate,
+			&e.RoomID,
+			&e.Adults,
+			&e.Children,
+			&e.OfferedRoomID,
+			&e.CreatedAt,
+			&e.UpdatedAt,
 		)
 		if err != nil {
-			return rooms, err
+			return entries, err
 		}
+		entries = append(entries, e)
+	}
 
-		rooms = append(rooms, room)
+	if err = rows.Err(); err != nil {
+		return entries, err
 	}
 
-	return rooms, nil
+	return entries, nil
 }
 
-func (m *postgresDBRepo) GetRoomRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
+// MarkWaitlistEntryNotified takes an entry off the waitlist once the guest has been offered roomID
+func (m *postgresDBRepo) MarkWaitlistEntryNotified(id, roomID int) error {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	var restrictions []models.RoomRestriction
-
-	query := `select id, coalesce(reservation_id, 0) as reservation_id, restriction_id, room_id, start_date, end_date
-				from room_restrictions where $1 < end_date and $2 >= start_date and room_id = $3`
+	query := `update waitlist_entries set notified_at = $1, offered_room_id = $2, updated_at = $1 where id = $3`
 
-	rows, err := m.DB.QueryContext(ctx, query, start, end, roomID)
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), roomID, id)
 	if err != nil {
-		return nil, err
+		return err
 	}
-	defer rows.Close()
 
-	for rows.Next() {
-		var r models.RoomRestriction
+	return nil
+}
 
-		err := rows.Scan(
-			&r.ID,
-			&r.ReservationID,
-			&r.RestrictionID,
-			&r.RoomID,
-			&r.StartDate,
-			&r.EndDate,
-		)
+// CloseWaitlistOffer marks the room offered to a waitlist entry as passed on, so it is only passed on once
+func (m *postgresDBRepo) CloseWaitlistOffer(id int) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
 
-		if err != nil {
-			return restrictions, err
-		}
+	query := `update waitlist_entries set offered_room_id = null, updated_at = $1 where id = $2`
 
-		restrictions = append(restrictions, r)
+	_, err := m.DB.ExecContext(ctx, query, time.Now(), id)
+	if err != nil {
+		return err
 	}
 
-	return restrictions, nil
+	return nil
 }
 
-func (m *postgresDBRepo) InsertBlockForRoomRestriction(id int, startDate time.Time) error {
+// InsertGuest registers a guest with password, returning ErrEmailTaken if the email is already registered
+func (m *postgresDBRepo) InsertGuest(g models.Guest, password string) (int, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `insert into room_restrictions 
-	(start_date, end_date, room_id, restriction_id, created_at, updated_at)
-	values ($1, $2, $3, $4, $5, $6)`
+	hashedPassword, err := hashPassword(password)
+	if err != nil {
+		return 0, err
+	}
+
+	var newID int
+
+	stmt := `insert into guests (first_name, last_name, email, phone, password, created_at, updated_at)
+		values ($1, $2, $3, $4, $5, $6, $7) returning id`
 
-	_, err := m.DB.ExecContext(ctx, query,
-		startDate,
-		startDate.AddDate(0, 0, 1),
-		id,
-		2,
+	err = m.DB.QueryRowContext(ctx, stmt,
+		g.FirstName,
+		g.LastName,
+		g.Email,
+		g.Phone,
+		hashedPassword,
 		time.Now(),
 		time.Now(),
+	).Scan(&newID)
+	if err != nil {
+		return 0, translateEmailError(err)
+	}
+
+	return newID, nil
+}
+
+// GetGuestByID returns a guest's profile
+func (m *postgresDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var g models.Guest
+
+	query := `select id, first_name, last_name, email, phone, created_at, updated_at
+				from guests where id = $1`
+
+	err := m.DB.QueryRowContext(ctx, query, id).Scan(
+		&g.ID,
+		&g.FirstName,
+		&g.LastName,
+		&g.Email,
+		&g.Phone,
+		&g.CreatedAt,
+		&g.UpdatedAt,
 	)
+	if err != nil {
+		return g, err
+	}
+
+	return g, nil
+}
 
+// AuthenticateGuest checks a guest's email and password and returns their id
+func (m *postgresDBRepo) AuthenticateGuest(email, testPassword string) (int, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
+	defer cancel()
+
+	var id int
+	var hashedPassword string
+
+	row := m.DB.QueryRowContext(ctx, "select id, password from guests where email = $1", email)
+	err := row.Scan(&id, &hashedPassword)
 	if err != nil {
-		return err
+		return 0, err
 	}
 
-	return nil
+	err = bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(testPassword))
+	if err == bcrypt.ErrMismatchedHashAndPassword {
+		return 0, errors.New("incorrect password")
+	} else if err != nil {
+		return 0, err
+	}
+
+	return id, nil
 }
 
-func (m *postgresDBRepo) DeleteBlockByID(room_restriction_id int) error {
+// GuestReservations returns the reservations booked from a guest's account, latest arrival first
+func (m *postgresDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
 	ctx, cancel := context.WithTimeout(context.Background(), 3*time.Second)
 	defer cancel()
 
-	query := `delete from room_restrictions where id = $1`
+	var reservations []models.Reservation
 
-	_, err := m.DB.ExecContext(ctx, query, room_restriction_id)
+	query := `select r.id, r.first_name, r.last_name, r.start_date, r.end_date, r.room_id,
+				r.confirmation_code, r.cancelled, r.total_price, r.adults, r.children, r.guest_id,
+				rm.id, rm.room_name
+				from reservations r
+				left join rooms rm on (r.room_id = rm.id)
+				where r.guest_id = $1
+				order by r.start_date desc`
+
+	rows, err := m.DB.QueryContext(ctx, query, guestID)
 	if err != nil {
-		return err
+		return reservations, err
 	}
+	defer rows.Close()
 
-	return nil
+	for rows.Next() {
+		var res models.Reservation
+		err := rows.Scan(
+			&res.ID,
+			&res.FirstName,
+			&res.LastName,
+			&res.StartDate,
+			&res.EndDate,
+			&res.RoomID,
+			&res.ConfirmationCode,
+			&res.Cancelled,
+			&res.TotalPrice,
+			&res.Adults,
+			&res.Children,
+			&res.GuestID,
+			&res.Room.ID,
+			&res.Room.RoomName,
+		)
+		if err != nil {
+			return reservations, err
+		}
+		reservations = append(reservations, res)
+	}
+
+	if err = rows.Err(); err != nil {
+		return reservations, err
+	}
+
+	return reservations, nil
 }
@@ -0,0 +1,51 @@
+package dbrepo
+
+import (
+	"time"
+
+	"github.com/sindrishtepani/bookings/internal/models"
+)
+
+// isWeekendNight reports whether the night starting on d is charged at the weekend rate
+func isWeekendNight(d time.Time) bool {
+	return d.Weekday() == time.Friday || d.Weekday() == time.Saturday
+}
+
+// priceStay works out the price of every night from start up to end. The first seasonal
+// rate covering a night wins, so rates should be passed in order of precedence.
+func priceStay(room models.Room, rates []models.RoomRate, start, end time.Time) models.StayPrice {
+	var price models.StayPrice
+
+	for d := start; d.Before(end); d = d.AddDate(0, 0, 1) {
+		weekend := isWeekendNight(d)
+
+		night := models.NightPrice{
+			Date:     d,
+			Price:    room.BaseRate,
+			RateName: "Standard",
+		}
+
+		if weekend && room.WeekendRate > 0 {
+			night.Price = room.WeekendRate
+			night.RateName = "Weekend"
+		}
+
+		for _, rate := range rates {
+			if d.Before(rate.StartDate) || d.After(rate.EndDate) {
+				continue
+			}
+
+			night.Price = rate.NightlyRate
+			night.RateName = rate.Name
+			if weekend && rate.WeekendRate > 0 {
+				night.Price = rate.WeekendRate
+			}
+			break
+		}
+
+		price.Nights = append(price.Nights, night)
+		price.Total += night.Price
+	}
+
+	return price
+}
@@ -0,0 +1,92 @@
+package dbrepo
+
+import (
+	"testing"
+	"time"
+
+	"github.com/sindrishtepani/bookings/internal/models"
+)
+
+var priceStayTests = []struct {
+	name          string
+	start         string
+	end           string
+	rates         []models.RoomRate
+	expectedTotal int
+	expectedNames []string
+}{
+	{
+		name:          "weeknights",
+		start:         "2050-01-03",
+		end:           "2050-01-05",
+		expectedTotal: 20000,
+		expectedNames: []string{"Standard", "Standard"},
+	},
+	{
+		name:          "over-a-weekend",
+		start:         "2050-01-06",
+		end:           "2050-01-09",
+		expectedTotal: 10000 + 12000 + 12000,
+		expectedNames: []string{"Standard", "Weekend", "Weekend"},
+	},
+	{
+		name:  "seasonal-rate",
+		start: "2050-01-06",
+		end:   "2050-01-09",
+		rates: []models.RoomRate{
+			{
+				Name:        "Festival",
+				StartDate:   time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC),
+				EndDate:     time.Date(2050, 1, 7, 0, 0, 0, 0, time.UTC),
+				NightlyRate: 20000,
+			},
+		},
+		expectedTotal: 10000 + 20000 + 12000,
+		expectedNames: []string{"Standard", "Festival", "Weekend"},
+	},
+	{
+		name:  "seasonal-weekend-rate",
+		start: "2050-01-07",
+		end:   "2050-01-08",
+		rates: []models.RoomRate{
+			{
+				Name:        "Winter",
+				StartDate:   time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+				EndDate:     time.Date(2050, 1, 31, 0, 0, 0, 0, time.UTC),
+				NightlyRate: 8000,
+				WeekendRate: 9000,
+			},
+		},
+		expectedTotal: 9000,
+		expectedNames: []string{"Winter"},
+	},
+}
+
+func TestPriceStay(t *testing.T) {
+	room := models.Room{
+		BaseRate:    10000,
+		WeekendRate: 12000,
+	}
+
+	for _, e := range priceStayTests {
+		start, _ := time.Parse("2006-01-02", e.start)
+		end, _ := time.Parse("2006-01-02", e.end)
+
+		price := priceStay(room, e.rates, start, end)
+
+		if price.Total != e.expectedTotal {
+			t.Errorf("%s: expected total %d but got %d", e.name, e.expectedTotal, price.Total)
+		}
+
+		if len(price.Nights) != len(e.expectedNames) {
+			t.Errorf("%s: expected %d nights but got %d", e.name, len(e.expectedNames), len(price.Nights))
+			continue
+		}
+
+		for i, night := range price.Nights {
+			if night.RateName != e.expectedNames[i] {
+				t.Errorf("%s: expected night %d at %s rate but got %s", e.name, i, e.expectedNames[i], night.RateName)
+			}
+		}
+	}
+}
@@ -1,15 +1,43 @@
 package dbrepo
 
 import (
+	"database/sql"
 	"errors"
 	"log"
 	"time"
 
+	"github.com/sindrishtepani/bookings/internal/helpers"
 	"github.com/sindrishtepani/bookings/internal/models"
+	"github.com/sindrishtepani/bookings/internal/repository"
 )
 
-func (m *testDBRepo) AllUsers() bool {
-	return true
+func (m *testDBRepo) AllUsers() ([]models.User, error) {
+	users := []models.User{
+		{ID: 1, FirstName: "Sam", LastName: "Wade", Email: "me@here.ca", Password: "hash", AccessLevel: int(models.RoleOwner), Active: 1},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Email: "jane@here.ca", AccessLevel: int(models.RoleFrontDesk), Active: 1,
+			LockedUntil: time.Now().Add(10 * time.Minute)},
+	}
+
+	return users, nil
+}
+
+func (m *testDBRepo) InsertUser(u models.User) (int, error) {
+	if u.Email == "taken@here.ca" {
+		return 0, repository.ErrEmailTaken
+	}
+	if u.Email == "fail@here.ca" {
+		return 0, errors.New("some error")
+	}
+
+	return 3, nil
+}
+
+func (m *testDBRepo) SetUserPassword(id int, password string) error {
+	if id > 2 {
+		return errors.New("some error")
+	}
+
+	return nil
 }
 
 func (m *testDBRepo) InsertReservation(res models.Reservation) (int, error) {
@@ -27,16 +55,65 @@ func (m *testDBRepo) InsertRoomRestriction(r models.RoomRestriction) error {
 	return nil
 }
 
+// BookRoom inserts a reservation and its room restriction in a single transaction
+func (m *testDBRepo) BookRoom(res models.Reservation) (int, error) {
+	if res.RoomID == 2 || res.RoomID == 99 {
+		return 0, errors.New("roomID == failure case")
+	}
+
+	if res.RoomID == 3 || res.LastName == "Taken" {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) BookRooms(group models.ReservationGroup) (int, error) {
+	switch group.Email {
+	case "taken@here.com":
+		return 0, repository.ErrRoomUnavailable
+	case "fail@here.com":
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) GetReservationGroupByID(id int) (models.ReservationGroup, error) {
+	var group models.ReservationGroup
+	if id > 2 {
+		return group, errors.New("some error")
+	}
+
+	group.ID = id
+	group.FirstName = "John"
+	group.LastName = "Smith"
+	group.Email = "john@smith.com"
+	group.TotalPrice = 20000
+	group.Reservations = []models.Reservation{
+		{ID: 1, RoomID: 1, GroupID: id, ConfirmationCode: "ABCDEFGHJK", TotalPrice: 10000,
+			Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		{ID: 2, RoomID: 2, GroupID: id, ConfirmationCode: "BCDEFGHJKL", TotalPrice: 10000,
+			Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
+	}
+
+	return group, nil
+}
+
 // HasAvailabilityByDatesByRoomID returns true if availability exists and false if it doesn't
 func (m *testDBRepo) HasAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error) {
 	if start.After(end) {
 		return false, errors.New("failing bc start date after end date")
 	}
+	// room 3 is always taken
+	if roomID == 3 {
+		return false, nil
+	}
 	return true, nil
 }
 
 // SearchAvailabilityForAllRooms returns a slice of available rooms, if any, for a given date range
-func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error) {
+func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error) {
 	var rooms []models.Room
 
 	// if the start date is after 2049-12-31, then return empty slice,
@@ -61,6 +138,20 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 		return rooms, nil
 	}
 
+	// a search for any size of room finds two rooms that sleep four each
+	if adults == 0 && children == 0 {
+		rooms = append(rooms,
+			models.Room{ID: 1, RoomName: "General's Quarters", MaxAdults: 2, MaxChildren: 2},
+			models.Room{ID: 2, RoomName: "Major's Suite", MaxAdults: 2, MaxChildren: 2},
+		)
+		return rooms, nil
+	}
+
+	// no room sleeps more than six guests
+	if adults+children > 6 {
+		return rooms, nil
+	}
+
 	// otherwise, put an entry into the slice, indicating that some room is
 	// available for search dates
 	room := models.Room{
@@ -71,23 +162,356 @@ func (m *testDBRepo) SearchAvailabilityForAllRooms(start, end time.Time) ([]mode
 	return rooms, nil
 }
 
+func (m *testDBRepo) StayRulesForDates(start, end time.Time) ([]models.StayRule, error) {
+	var rules []models.StayRule
+
+	if start.Year() == 2061 {
+		return rules, errors.New("some error")
+	}
+
+	rules = append(rules, m.festivalRule())
+
+	return rules, nil
+}
+
+// festivalRule is a stay rule for room 1 over the first week of July 2045
+func (m *testDBRepo) festivalRule() models.StayRule {
+	return models.StayRule{
+		ID:                1,
+		RoomID:            1,
+		Name:              "Festival",
+		StartDate:         time.Date(2045, 7, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:           time.Date(2045, 7, 7, 0, 0, 0, 0, time.UTC),
+		MinNights:         3,
+		ClosedToDeparture: 1 << time.Sunday,
+		Room:              models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+}
+
+func (m *testDBRepo) AllStayRules() ([]models.StayRule, error) {
+	return []models.StayRule{m.festivalRule()}, nil
+}
+
+func (m *testDBRepo) InsertStayRule(rule models.StayRule) error {
+	if rule.RoomID == 99 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) DeleteStayRule(id int) error {
+	return nil
+}
+
 // GetRoomByID gets a room type by id
 func (m *testDBRepo) GetRoomByID(id int) (models.Room, error) {
 	var room models.Room
-	if id > 2 {
+	// 3, 98 and 99 exist so bookings reach the failures the other fakes keep for them, and 97 is archived
+	if id > 2 && id != 3 && id != 97 && id != 98 && id != 99 {
 		return room, errors.New("some error")
 	}
 
+	room.ID = id
+	room.MaxAdults = 2
+	room.MaxChildren = 2
+	if id == 97 {
+		room.Archived = 1
+	}
+
 	return room, nil
 }
 
+func (m *testDBRepo) GetRoomBySlug(slug string) (models.Room, error) {
+	switch slug {
+	case "generals-quarters":
+		return models.Room{
+			ID:          1,
+			RoomName:    "General's Quarters",
+			Slug:        slug,
+			BaseRate:    10000,
+			MaxAdults:   2,
+			MaxChildren: 1,
+			Description: "A room with a view",
+			Amenities:   "Ocean view\nQueen bed",
+			Image:       "generals-quarters.png",
+		}, nil
+	case "majors-suite":
+		return models.Room{
+			ID:          2,
+			RoomName:    "Major's Suite",
+			Slug:        slug,
+			BaseRate:    15000,
+			WeekendRate: 12000,
+			MaxAdults:   4,
+			MaxChildren: 2,
+		}, nil
+	case "fail":
+		return models.Room{}, errors.New("some error")
+	}
+
+	return models.Room{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) GetPriceForStay(roomID int, start, end time.Time) (models.StayPrice, error) {
+	var price models.StayPrice
+
+	if roomID == 98 {
+		return price, errors.New("some error")
+	}
+
+	room := models.Room{
+		ID:       roomID,
+		BaseRate: 10000,
+	}
+
+	return priceStay(room, nil, start, end), nil
+}
+
+func (m *testDBRepo) AllRoomRates() ([]models.RoomRate, error) {
+	var rates []models.RoomRate
+
+	return rates, nil
+}
+
+func (m *testDBRepo) InsertRoomRate(rate models.RoomRate) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomRate(id int) error {
+	return nil
+}
+
 func (m *testDBRepo) GetUserByID(id int) (models.User, error) {
 	var u models.User
+	if id > 3 {
+		return u, errors.New("some error")
+	}
+
+	u.ID = id
+	u.FirstName = "Sam"
+	u.LastName = "Wade"
+	u.Email = "me@here.ca"
+	u.Password = "hash"
+	u.AccessLevel = int(models.RoleOwner)
+	u.Active = 1
+
+	if id == 2 {
+		u.FirstName = "Jane"
+		u.LastName = "Doe"
+		u.Email = "jane@here.ca"
+		u.Password = ""
+		u.AccessLevel = int(models.RoleFrontDesk)
+	}
+
+	// a manager with two-factor authentication turned on
+	if id == 3 {
+		u.FirstName = "Tom"
+		u.LastName = "Lee"
+		u.Email = "totp@here.ca"
+		u.AccessLevel = int(models.RoleManager)
+		u.TOTPSecret = testTOTPSecret
+		u.TOTPEnabled = 1
+	}
 
 	return u, nil
 }
 
+// testTOTPSecret is the two-factor secret of the test manager, user 3
+const testTOTPSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func (m *testDBRepo) EnableTOTP(userID int, secret string, recoveryCodeHashes []string) error {
+	if userID > 2 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) DisableTOTP(userID int) error {
+	if userID > 2 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) UseTOTPStep(userID int, step int64) (bool, error) {
+	return true, nil
+}
+
+func (m *testDBRepo) ReplaceRecoveryCodes(userID int, codeHashes []string) error {
+	return nil
+}
+
+func (m *testDBRepo) UseRecoveryCode(userID int, codeHash string) (bool, error) {
+	return codeHash == helpers.HashToken("ABCDEFGHJK"), nil
+}
+
+func (m *testDBRepo) CountRecoveryCodes(userID int) (int, error) {
+	return 10, nil
+}
+
+func (m *testDBRepo) RecentLoginFailures(email, ip string, since time.Time) (models.LoginFailures, error) {
+	var f models.LoginFailures
+
+	switch {
+	case email == "broken@here.ca":
+		return f, errors.New("some error")
+	case email == "slow@here.ca":
+		// still backing off from the last failure
+		f.Account = 6
+		f.Last = time.Now()
+	case email == "jane@here.ca":
+		// the next failure locks the account
+		f.Account = 10
+		f.Last = time.Now().Add(-10 * time.Minute)
+	case ip == "203.0.113.9":
+		f.IP = 30
+		f.Last = time.Now()
+	}
+
+	return f, nil
+}
+
+func (m *testDBRepo) InsertLoginFailure(email, ip string) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteLoginFailuresBefore(t time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) ClearLoginFailures(email string) error {
+	return nil
+}
+
+func (m *testDBRepo) LockUser(id int, until time.Time) error {
+	if id > 3 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) UnlockUser(id int) error {
+	if id > 3 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+// testAPITokens are a write token for user 1 and a read token for user 2
+var testAPITokens = []models.APIToken{
+	{ID: 1, UserID: 1, Name: "Channel manager", Scope: models.APIScopeWrite, ExpiresAt: time.Now().AddDate(0, 0, 30),
+		User: models.User{ID: 1, FirstName: "Sam", LastName: "Wade"}},
+	{ID: 2, UserID: 2, Name: "Reports", Scope: models.APIScopeRead, ExpiresAt: time.Now().AddDate(0, 0, 30),
+		LastUsedAt: time.Now(), User: models.User{ID: 2, FirstName: "Jane", LastName: "Doe"}},
+}
+
+func (m *testDBRepo) InsertAPIToken(t models.APIToken, tokenHash string) (int, error) {
+	if t.Name == "fail" {
+		return 0, errors.New("some error")
+	}
+
+	return 3, nil
+}
+
+func (m *testDBRepo) GetAPITokenByID(id int) (models.APIToken, error) {
+	if id < 1 || id > len(testAPITokens) {
+		return models.APIToken{}, sql.ErrNoRows
+	}
+
+	return testAPITokens[id-1], nil
+}
+
+func (m *testDBRepo) AllAPITokens() ([]models.APIToken, error) {
+	return testAPITokens, nil
+}
+
+func (m *testDBRepo) UserAPITokens(userID int) ([]models.APIToken, error) {
+	if userID > 3 {
+		return nil, errors.New("some error")
+	}
+
+	var tokens []models.APIToken
+	for _, t := range testAPITokens {
+		if t.UserID == userID {
+			tokens = append(tokens, t)
+		}
+	}
+
+	return tokens, nil
+}
+
+func (m *testDBRepo) UseAPIToken(tokenHash string) (models.APIToken, error) {
+	switch tokenHash {
+	case helpers.HashToken("write-token"):
+		return testAPITokens[0], nil
+	case helpers.HashToken("read-token"):
+		return testAPITokens[1], nil
+	}
+
+	return models.APIToken{}, repository.ErrInvalidToken
+}
+
+func (m *testDBRepo) DeleteAPIToken(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetUserByEmail(email string) (models.User, error) {
+	switch email {
+	case "fail@here.ca":
+		return models.User{}, errors.New("some error")
+	case "me@here.ca":
+		return m.GetUserByID(1)
+	case "jane@here.ca":
+		return m.GetUserByID(2)
+	case "locked@here.ca":
+		u, err := m.GetUserByID(2)
+		u.Email = email
+		u.LockedUntil = time.Now().Add(10 * time.Minute)
+		return u, err
+	}
+
+	return models.User{}, sql.ErrNoRows
+}
+
+func (m *testDBRepo) InsertPasswordResetToken(userID int, tokenHash string, expires time.Time) error {
+	return nil
+}
+
+func (m *testDBRepo) GetUserByResetToken(tokenHash string) (models.User, error) {
+	if tokenHash != helpers.HashToken("valid-token") {
+		return models.User{}, repository.ErrInvalidToken
+	}
+
+	return m.GetUserByID(1)
+}
+
+func (m *testDBRepo) ResetPassword(tokenHash, password string) (int, error) {
+	if tokenHash != helpers.HashToken("valid-token") {
+		return 0, repository.ErrInvalidToken
+	}
+	if password == "failing password" {
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
 func (m *testDBRepo) UpdateUser(u models.User) error {
+	if u.FirstName == "fail" {
+		return errors.New("some error")
+	}
+	if u.Email == "taken@here.ca" {
+		return repository.ErrEmailTaken
+	}
+	if u.ID == 1 && (u.AccessLevel != int(models.RoleOwner) || u.Active == 0) {
+		return repository.ErrLastOwner
+	}
+
 	return nil
 }
 
@@ -95,20 +519,24 @@ func (m *testDBRepo) Authenticate(email, testPassword string) (int, string, erro
 	if email == "me@here.ca" {
 		return 1, "", nil
 	}
+	if email == "totp@here.ca" {
+		return 3, "hash", nil
+	}
 
 	return 1, "", errors.New("didn't pass me@here.ca")
 }
 
-func (m *testDBRepo) AllReservations() ([]models.Reservation, error) {
-	var reseravtions []models.Reservation
-
-	return reseravtions, nil
-}
+func (m *testDBRepo) SearchReservations(f models.ReservationFilter) ([]models.Reservation, int, error) {
+	if f.Search == "fail" {
+		return nil, 0, errors.New("some error")
+	}
 
-func (m *testDBRepo) AllNewReservations() ([]models.Reservation, error) {
-	var reseravtions []models.Reservation
+	reservations := []models.Reservation{
+		{ID: 1, FirstName: "John", LastName: "Smith", Room: models.Room{ID: 1, RoomName: "General's Quarters"}},
+		{ID: 2, FirstName: "Jane", LastName: "Doe", Processed: 1, Room: models.Room{ID: 2, RoomName: "Major's Suite"}},
+	}
 
-	return reseravtions, nil
+	return reservations, 60, nil
 }
 
 func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
@@ -117,7 +545,38 @@ func (m *testDBRepo) GetReservationByID(id int) (models.Reservation, error) {
 	return reseravtion, nil
 }
 
+func (m *testDBRepo) GetReservationByCode(code string) (models.Reservation, error) {
+	var reservation models.Reservation
+
+	if code == "ZZZZZZZZZZ" {
+		return reservation, errors.New("no reservation for that code")
+	}
+
+	reservation.ID = 1
+	reservation.ConfirmationCode = code
+	reservation.Email = "john@smith.com"
+	reservation.GuestID = 1
+	reservation.StartDate = time.Now().AddDate(0, 1, 0)
+	reservation.EndDate = time.Now().AddDate(0, 1, 2)
+
+	// a stay that has already started can't be cancelled
+	if code == "PASTSTAYXX" {
+		reservation.StartDate = time.Now().AddDate(0, 0, -2)
+		reservation.EndDate = time.Now().AddDate(0, 0, 1)
+	}
+
+	return reservation, nil
+}
+
 func (m *testDBRepo) UpdateReservation(u models.Reservation) error {
+	if u.FirstName == "fail" {
+		return errors.New("some error")
+	}
+
+	if u.RoomID == 2 {
+		return repository.ErrRoomUnavailable
+	}
+
 	return nil
 }
 
@@ -125,6 +584,18 @@ func (m *testDBRepo) DeleteReservation(id int) error {
 	return nil
 }
 
+func (m *testDBRepo) CancelReservation(id int, reason string, refundPercent int) error {
+	return nil
+}
+
+func (m *testDBRepo) GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error) {
+	return models.CancellationPolicy{
+		Name:                 "Moderate",
+		FreeCancellationDays: 7,
+		LateRefundPercent:    50,
+	}, nil
+}
+
 func (m *testDBRepo) UpdateProcessedForReservation(id, processed int) error {
 	return nil
 }
@@ -135,16 +606,333 @@ func (m *testDBRepo) AllRooms() ([]models.Room, error) {
 	return rooms, nil
 }
 
-func (m *testDBRepo) GetRoomRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error) {
-	var restrictions []models.RoomRestriction
+func (m *testDBRepo) AllRoomsIncludingArchived() ([]models.Room, error) {
+	rooms := []models.Room{
+		{ID: 1, RoomName: "General's Quarters", MaxAdults: 2},
+		{ID: 2, RoomName: "Major's Suite", MaxAdults: 4, Archived: 1},
+	}
+
+	return rooms, nil
+}
+
+func (m *testDBRepo) InsertRoom(room models.Room) (int, error) {
+	if room.RoomName == "Failing Room" {
+		return 0, errors.New("some error")
+	}
+
+	if room.Slug == "taken" {
+		return 0, repository.ErrSlugTaken
+	}
+
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateRoom(room models.Room) error {
+	if room.RoomName == "Failing Room" {
+		return errors.New("some error")
+	}
+
+	if room.Slug == "taken" {
+		return repository.ErrSlugTaken
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) ArchiveRoom(id, archived int) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoom(id int) error {
+	if id == 1 {
+		return repository.ErrRoomInUse
+	}
+
+	if id > 2 {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) GetRoomRestrictionsByDate(start, end time.Time) ([]models.RoomRestriction, error) {
+	if start.Year() == 1999 {
+		return nil, errors.New("some error")
+	}
+
+	// a stay in room 1 and a block in room 2 running over the start of the range, and a hold after them
+	restrictions := []models.RoomRestriction{
+		{
+			ID:            1,
+			RoomID:        1,
+			ReservationID: 1,
+			RestrictionID: models.RestrictionReservation,
+			StartDate:     start.AddDate(0, 0, 1),
+			EndDate:       start.AddDate(0, 0, 4),
+			Reservation:   models.Reservation{ID: 1, FirstName: "John", LastName: "Smith"},
+		},
+		{
+			ID:            2,
+			RoomID:        2,
+			RestrictionID: models.RestrictionOwnerBlock,
+			StartDate:     start.AddDate(0, 0, -2),
+			EndDate:       start.AddDate(0, 0, 2),
+			Reason:        "Painting",
+		},
+		{
+			ID:            3,
+			RoomID:        2,
+			RestrictionID: models.RestrictionHold,
+			StartDate:     start.AddDate(0, 0, 3),
+			EndDate:       start.AddDate(0, 0, 5),
+			ExpiresAt:     time.Now().Add(10 * time.Minute),
+		},
+	}
 
 	return restrictions, nil
 }
 
-func (m *testDBRepo) InsertBlockForRoomRestriction(id int, startDate time.Time) error {
+func (m *testDBRepo) InsertBlocks(roomIDs []int, start, end time.Time, reason string) error {
+	for _, id := range roomIDs {
+		if id == 3 {
+			return repository.ErrRoomUnavailable
+		}
+
+		if id == 99 {
+			return errors.New("some error")
+		}
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) GetBlockByID(id int) (models.RoomRestriction, error) {
+	if id > 2 {
+		return models.RoomRestriction{}, sql.ErrNoRows
+	}
+
+	b := models.RoomRestriction{
+		ID:            id,
+		RoomID:        1,
+		RestrictionID: models.RestrictionOwnerBlock,
+		StartDate:     time.Date(2050, 1, 1, 0, 0, 0, 0, time.UTC),
+		EndDate:       time.Date(2050, 1, 4, 0, 0, 0, 0, time.UTC),
+		Reason:        "Painting",
+		Room:          models.Room{ID: 1, RoomName: "General's Quarters"},
+	}
+
+	return b, nil
+}
+
+func (m *testDBRepo) UpdateBlock(b models.RoomRestriction) error {
+	if b.Reason == "fail" {
+		return errors.New("some error")
+	}
+
+	if b.Reason == "taken" {
+		return repository.ErrRoomUnavailable
+	}
+
 	return nil
 }
 
 func (m *testDBRepo) DeleteBlockByID(room_restriction_id int) error {
 	return nil
 }
+
+func (m *testDBRepo) InsertWaitlistEntry(e models.WaitlistEntry) (int, error) {
+	if e.Email == "fail@here.com" {
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) GetWaitlistEntryByID(id int) (models.WaitlistEntry, error) {
+	var e models.WaitlistEntry
+	if id > 2 {
+		return e, errors.New("some error")
+	}
+
+	e.ID = id
+	e.FirstName = "John"
+	e.LastName = "Smith"
+	e.Email = "john@smith.com"
+	e.StartDate = time.Now().AddDate(0, 1, 0)
+	e.EndDate = time.Now().AddDate(0, 1, 2)
+	e.Adults = 2
+
+	return e, nil
+}
+
+func (m *testDBRepo) WaitlistEntriesForDates(roomID int, start, end time.Time) ([]models.WaitlistEntry, error) {
+	e, _ := m.GetWaitlistEntryByID(1)
+
+	return []models.WaitlistEntry{e}, nil
+}
+
+func (m *testDBRepo) MarkWaitlistEntryNotified(id, roomID int) error {
+	return nil
+}
+
+func (m *testDBRepo) LapsedWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error) {
+	e, _ := m.GetWaitlistEntryByID(2)
+	e.OfferedRoomID = 1
+
+	return []models.WaitlistEntry{e}, nil
+}
+
+func (m *testDBRepo) CloseWaitlistOffer(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) HoldRoom(roomID int, start, end time.Time, ttl time.Duration) (int, error) {
+	if roomID == 3 {
+		return 0, repository.ErrRoomUnavailable
+	}
+
+	if roomID == 99 {
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) ReleaseHold(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) DeleteExpiredHolds() (int64, error) {
+	return 0, nil
+}
+
+func (m *testDBRepo) RoomPhotos(roomID int) ([]models.RoomPhoto, error) {
+	if roomID > 2 {
+		return nil, errors.New("some error")
+	}
+
+	photos := []models.RoomPhoto{
+		{ID: 1, RoomID: roomID, FileName: "abc.jpg", Caption: "The view", SortOrder: 1},
+	}
+
+	return photos, nil
+}
+
+func (m *testDBRepo) CoverPhotos() (map[int]models.RoomPhoto, error) {
+	covers := map[int]models.RoomPhoto{
+		1: {ID: 1, RoomID: 1, FileName: "abc.jpg", Caption: "The view", SortOrder: 1},
+	}
+
+	return covers, nil
+}
+
+func (m *testDBRepo) GetRoomPhotoByID(id int) (models.RoomPhoto, error) {
+	if id > 2 {
+		return models.RoomPhoto{}, sql.ErrNoRows
+	}
+
+	return models.RoomPhoto{ID: id, RoomID: 1, FileName: "abc.jpg", SortOrder: id}, nil
+}
+
+func (m *testDBRepo) InsertRoomPhoto(p models.RoomPhoto) (int, error) {
+	if p.RoomID == 2 {
+		return 0, errors.New("some error")
+	}
+
+	return 3, nil
+}
+
+func (m *testDBRepo) UpdateRoomPhoto(p models.RoomPhoto) error {
+	if p.Caption == "fail" {
+		return errors.New("some error")
+	}
+
+	return nil
+}
+
+func (m *testDBRepo) DeleteRoomPhoto(id int) error {
+	return nil
+}
+
+func (m *testDBRepo) DashboardStats(today time.Time) (models.DashboardStats, error) {
+	stats := models.DashboardStats{
+		Arrivals:        2,
+		Departures:      1,
+		InHouse:         3,
+		NewReservations: 4,
+		AvgLengthOfStay: 2.5,
+		AvgLeadTime:     14,
+		RevenueNext30:   120000,
+	}
+
+	for i := 0; i < 30; i++ {
+		stats.Occupancy = append(stats.Occupancy, models.DailyOccupancy{
+			Date:     today.AddDate(0, 0, i),
+			Occupied: i % 3,
+			Rooms:    2,
+		})
+	}
+
+	for i := 11; i >= 0; i-- {
+		stats.Revenue = append(stats.Revenue, models.MonthlyRevenue{
+			Month:   today.AddDate(0, -i, 0),
+			Revenue: 50000 * i,
+		})
+	}
+
+	return stats, nil
+}
+
+func (m *testDBRepo) InsertGuest(g models.Guest, password string) (int, error) {
+	if g.Email == "taken@here.ca" {
+		return 0, repository.ErrEmailTaken
+	}
+	if g.Email == "fail@here.ca" {
+		return 0, errors.New("some error")
+	}
+
+	return 1, nil
+}
+
+func (m *testDBRepo) GetGuestByID(id int) (models.Guest, error) {
+	if id > 2 {
+		return models.Guest{}, errors.New("some error")
+	}
+
+	return models.Guest{ID: id, FirstName: "John", LastName: "Smith", Email: "john@smith.com", Phone: "555-555-5555"}, nil
+}
+
+func (m *testDBRepo) AuthenticateGuest(email, testPassword string) (int, error) {
+	if email == "john@smith.com" && testPassword == "password" {
+		return 1, nil
+	}
+
+	return 0, errors.New("incorrect password")
+}
+
+func (m *testDBRepo) GuestReservations(guestID int) ([]models.Reservation, error) {
+	if guestID == 2 {
+		return nil, errors.New("some error")
+	}
+
+	reservations := []models.Reservation{
+		{
+			ID:               2,
+			StartDate:        time.Now().AddDate(0, 1, 0),
+			EndDate:          time.Now().AddDate(0, 1, 2),
+			ConfirmationCode: "UPCOMING22",
+			GuestID:          guestID,
+			Room:             models.Room{ID: 1, RoomName: "General's Quarters"},
+		},
+		{
+			ID:               1,
+			StartDate:        time.Now().AddDate(0, -2, 0),
+			EndDate:          time.Now().AddDate(0, -2, 3),
+			ConfirmationCode: "PASTSTAY11",
+			GuestID:          guestID,
+			Room:             models.Room{ID: 2, RoomName: "Major's Suite"},
+		},
+	}
+
+	return reservations, nil
+}
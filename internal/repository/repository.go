@@ -1,28 +1,117 @@
 package repository
 
 import (
+	"errors"
 	"time"
 
 	"github.com/sindrishtepani/bookings/internal/models"
 )
 
+// ErrRoomUnavailable is returned when a room is already booked or blocked for the requested dates
+var ErrRoomUnavailable = errors.New("room is not available for the requested dates")
+
+// ErrRoomInUse is returned when deleting a room that has reservations or blocks ahead of it
+var ErrRoomInUse = errors.New("room has reservations or upcoming blocks")
+
+// ErrSlugTaken is returned when saving a room with a slug another room already uses
+var ErrSlugTaken = errors.New("slug is already used by another room")
+
+// ErrEmailTaken is returned when saving a user or guest with an email another one already has
+var ErrEmailTaken = errors.New("email is already used by another user")
+
+// ErrLastOwner is returned by changes that would leave no active owner to manage the users
+var ErrLastOwner = errors.New("there must be at least one active owner")
+
+// ErrInvalidToken is returned for a password reset or API token that doesn't exist, has expired or was used
+var ErrInvalidToken = errors.New("token is invalid, expired or already used")
+
 type DataseRepo interface {
-	AllUsers() bool
+	AllUsers() ([]models.User, error)
+	InsertUser(u models.User) (int, error)
+	SetUserPassword(id int, password string) error
+	InsertPasswordResetToken(userID int, tokenHash string, expires time.Time) error
+	GetUserByResetToken(tokenHash string) (models.User, error)
+	ResetPassword(tokenHash, password string) (int, error)
+
+	EnableTOTP(userID int, secret string, recoveryCodeHashes []string) error
+	DisableTOTP(userID int) error
+	UseTOTPStep(userID int, step int64) (bool, error)
+	ReplaceRecoveryCodes(userID int, codeHashes []string) error
+	UseRecoveryCode(userID int, codeHash string) (bool, error)
+	CountRecoveryCodes(userID int) (int, error)
+
+	RecentLoginFailures(email, ip string, since time.Time) (models.LoginFailures, error)
+	InsertLoginFailure(email, ip string) error
+	DeleteLoginFailuresBefore(t time.Time) error
+	ClearLoginFailures(email string) error
+	LockUser(id int, until time.Time) error
+	UnlockUser(id int) error
+
+	InsertAPIToken(t models.APIToken, tokenHash string) (int, error)
+	GetAPITokenByID(id int) (models.APIToken, error)
+	AllAPITokens() ([]models.APIToken, error)
+	UserAPITokens(userID int) ([]models.APIToken, error)
+	UseAPIToken(tokenHash string) (models.APIToken, error)
+	DeleteAPIToken(id int) error
+
+	InsertGuest(g models.Guest, password string) (int, error)
+	GetGuestByID(id int) (models.Guest, error)
+	AuthenticateGuest(email, testPassword string) (int, error)
+	GuestReservations(guestID int) ([]models.Reservation, error)
 	InsertReservation(res models.Reservation) (int, error)
 	InsertRoomRestriction(r models.RoomRestriction) error
+	BookRoom(res models.Reservation) (int, error)
+	BookRooms(group models.ReservationGroup) (int, error)
+	GetReservationGroupByID(id int) (models.ReservationGroup, error)
 	HasAvailabilityByDatesByRoomID(start, end time.Time, roomID int) (bool, error)
-	SearchAvailabilityForAllRooms(start, end time.Time) ([]models.Room, error)
+	SearchAvailabilityForAllRooms(start, end time.Time, adults, children int) ([]models.Room, error)
 	GetRoomByID(id int) (models.Room, error)
+	GetRoomBySlug(slug string) (models.Room, error)
+	RoomPhotos(roomID int) ([]models.RoomPhoto, error)
+	CoverPhotos() (map[int]models.RoomPhoto, error)
+	GetRoomPhotoByID(id int) (models.RoomPhoto, error)
+	InsertRoomPhoto(p models.RoomPhoto) (int, error)
+	UpdateRoomPhoto(p models.RoomPhoto) error
+	DeleteRoomPhoto(id int) error
+	GetPriceForStay(roomID int, start, end time.Time) (models.StayPrice, error)
+	AllRoomRates() ([]models.RoomRate, error)
+	InsertRoomRate(rate models.RoomRate) error
+	DeleteRoomRate(id int) error
+	StayRulesForDates(start, end time.Time) ([]models.StayRule, error)
+	AllStayRules() ([]models.StayRule, error)
+	InsertStayRule(rule models.StayRule) error
+	DeleteStayRule(id int) error
+	GetUserByID(id int) (models.User, error)
+	GetUserByEmail(email string) (models.User, error)
 	UpdateUser(u models.User) error
 	Authenticate(email, testPassword string) (int, string, error)
-	AllReservations() ([]models.Reservation, error)
-	AllNewReservations() ([]models.Reservation, error)
+	SearchReservations(f models.ReservationFilter) ([]models.Reservation, int, error)
+	DashboardStats(today time.Time) (models.DashboardStats, error)
 	GetReservationByID(id int) (models.Reservation, error)
+	GetReservationByCode(code string) (models.Reservation, error)
 	UpdateReservation(u models.Reservation) error
 	DeleteReservation(id int) error
+	CancelReservation(id int, reason string, refundPercent int) error
+	GetCancellationPolicyForRoom(roomID int) (models.CancellationPolicy, error)
 	UpdateProcessedForReservation(id, processed int) error
 	AllRooms() ([]models.Room, error)
-	GetRoomRestrictionsForRoomByDate(roomID int, start, end time.Time) ([]models.RoomRestriction, error)
-	InsertBlockForRoomRestriction(id int, startDate time.Time) error
+	AllRoomsIncludingArchived() ([]models.Room, error)
+	InsertRoom(room models.Room) (int, error)
+	UpdateRoom(room models.Room) error
+	ArchiveRoom(id, archived int) error
+	DeleteRoom(id int) error
+	GetRoomRestrictionsByDate(start, end time.Time) ([]models.RoomRestriction, error)
+	InsertBlocks(roomIDs []int, start, end time.Time, reason string) error
+	GetBlockByID(id int) (models.RoomRestriction, error)
+	UpdateBlock(b models.RoomRestriction) error
 	DeleteBlockByID(room_restriction_id int) error
+	HoldRoom(roomID int, start, end time.Time, ttl time.Duration) (int, error)
+	ReleaseHold(id int) error
+	DeleteExpiredHolds() (int64, error)
+	InsertWaitlistEntry(e models.WaitlistEntry) (int, error)
+	GetWaitlistEntryByID(id int) (models.WaitlistEntry, error)
+	WaitlistEntriesForDates(roomID int, start, end time.Time) ([]models.WaitlistEntry, error)
+	MarkWaitlistEntryNotified(id, roomID int) error
+	LapsedWaitlistOffers(notifiedBefore time.Time) ([]models.WaitlistEntry, error)
+	CloseWaitlistOffer(id int) error
 }
@@ -0,0 +1,110 @@
+package totp
+
+import (
+	"encoding/base32"
+	"strings"
+	"testing"
+	"time"
+)
+
+// rfcSecret is the SHA1 seed used by the RFC 6238 test vectors
+var rfcSecret = base32.StdEncoding.WithPadding(base32.NoPadding).EncodeToString([]byte("12345678901234567890"))
+
+func TestCodeAt(t *testing.T) {
+	// the last six digits of the RFC 6238 appendix B SHA1 vectors
+	tests := []struct {
+		unix     int64
+		expected string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1111111111, "050471"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+
+	for _, e := range tests {
+		code, err := CodeAt(rfcSecret, Step(time.Unix(e.unix, 0)))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if code != e.expected {
+			t.Errorf("at %d: expected %s but got %s", e.unix, e.expected, code)
+		}
+	}
+}
+
+func TestValidate(t *testing.T) {
+	now := time.Unix(1234567890, 0)
+
+	code, _ := CodeAt(rfcSecret, Step(now))
+	if step, ok := Validate(rfcSecret, code, now); !ok || step != Step(now) {
+		t.Error("expected the current code to be valid")
+	}
+
+	// a code from the step before still works, for clocks that are a little out
+	previous, _ := CodeAt(rfcSecret, Step(now)-1)
+	if _, ok := Validate(rfcSecret, previous, now); !ok {
+		t.Error("expected the previous code to be valid")
+	}
+
+	old, _ := CodeAt(rfcSecret, Step(now)-3)
+	if _, ok := Validate(rfcSecret, old, now); ok {
+		t.Error("expected a code from a minute and a half ago to be invalid")
+	}
+
+	if _, ok := Validate(rfcSecret, "12345", now); ok {
+		t.Error("expected a short code to be invalid")
+	}
+
+	if _, ok := Validate("not base32!", "123456", now); ok {
+		t.Error("expected a bad secret to fail")
+	}
+}
+
+func TestNewSecret(t *testing.T) {
+	secret, err := NewSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := CodeAt(secret, 1); err != nil {
+		t.Errorf("expected a usable secret but got %s", err)
+	}
+}
+
+func TestURI(t *testing.T) {
+	uri := URI("Bookings", "me@here.ca", "ABCDEF")
+
+	if !strings.HasPrefix(uri, "otpauth://totp/Bookings:me@here.ca?") {
+		t.Errorf("unexpected uri %s", uri)
+	}
+
+	if !strings.Contains(uri, "secret=ABCDEF") || !strings.Contains(uri, "issuer=Bookings") {
+		t.Errorf("expected the secret and issuer in %s", uri)
+	}
+}
+
+func TestNewRecoveryCodes(t *testing.T) {
+	codes, err := NewRecoveryCodes(10)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	seen := make(map[string]bool)
+	for _, c := range codes {
+		if len(c) != 11 || c[5] != '-' {
+			t.Errorf("unexpected code %s", c)
+		}
+		seen[c] = true
+	}
+
+	if len(seen) != 10 {
+		t.Error("expected ten different codes")
+	}
+
+	if NormalizeRecoveryCode("abcde-fghjk ") != "ABCDEFGHJK" {
+		t.Error("expected codes to be normalized")
+	}
+}
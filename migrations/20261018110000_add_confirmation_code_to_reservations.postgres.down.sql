@@ -0,0 +1,3 @@
+drop index if exists reservations_confirmation_code_idx;
+
+alter table reservations drop column if exists confirmation_code;
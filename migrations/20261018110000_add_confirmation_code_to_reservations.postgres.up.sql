@@ -0,0 +1,7 @@
+alter table reservations add column confirmation_code varchar(16) not null default '';
+
+update reservations
+	set confirmation_code = upper(substr(md5(random()::text || id::text), 1, 10))
+	where confirmation_code = '';
+
+create unique index reservations_confirmation_code_idx on reservations (confirmation_code);
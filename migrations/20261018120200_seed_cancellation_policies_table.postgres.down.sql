@@ -0,0 +1,2 @@
+update rooms set cancellation_policy_id = null;
+delete from cancellation_policies;
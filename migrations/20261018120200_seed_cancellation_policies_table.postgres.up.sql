@@ -0,0 +1,8 @@
+INSERT INTO public.cancellation_policies (name,description,free_cancellation_days,late_refund_percent,created_at,updated_at) VALUES
+	 ('Flexible','Free cancellation until the day before arrival. No refund after that.',1,0,'2026-10-18 00:00:00.000','2026-10-18 00:00:00.000'),
+	 ('Moderate','Free cancellation until 7 days before arrival. 50% refund after that.',7,50,'2026-10-18 00:00:00.000','2026-10-18 00:00:00.000');
+
+update rooms set cancellation_policy_id = (select id from cancellation_policies where name = 'Flexible')
+	where room_name = 'General''s Quarters';
+update rooms set cancellation_policy_id = (select id from cancellation_policies where name = 'Moderate')
+	where room_name = 'Major''s Suite';
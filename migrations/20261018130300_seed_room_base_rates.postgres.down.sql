@@ -0,0 +1 @@
+update rooms set base_rate = 0, weekend_rate = 0;
@@ -0,0 +1,2 @@
+update rooms set base_rate = 12000, weekend_rate = 14500 where room_name = 'General''s Quarters';
+update rooms set base_rate = 15000, weekend_rate = 18000 where room_name = 'Major''s Suite';
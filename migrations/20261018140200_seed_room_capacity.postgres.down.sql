@@ -0,0 +1 @@
+update rooms set max_adults = 2, max_children = 0;
@@ -0,0 +1,2 @@
+update rooms set max_adults = 2, max_children = 1 where room_name = 'General''s Quarters';
+update rooms set max_adults = 4, max_children = 2 where room_name = 'Major''s Suite';
@@ -0,0 +1,4 @@
+insert into restrictions (id, restrictions_name, created_at, updated_at) values
+	(3, 'Hold', now(), now());
+
+select setval('restrictions_id_seq', (select max(id) from restrictions));
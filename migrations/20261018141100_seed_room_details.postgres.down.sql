@@ -0,0 +1,2 @@
+drop index if exists rooms_slug_idx;
+update rooms set slug = '', description = '', amenities = '', image = '';
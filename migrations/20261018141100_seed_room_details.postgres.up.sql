@@ -0,0 +1,10 @@
+update rooms set slug = 'room-' || id;
+update rooms set slug = 'generals-quarters', image = 'generals-quarters.png',
+    description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation you will remember.',
+    amenities = E'Ocean view\nQueen bed\nPrivate bathroom\nFree Wi-Fi'
+    where room_name = 'General''s Quarters';
+update rooms set slug = 'majors-suite', image = 'marjors-suite.png',
+    description = 'Your home away from home, set on the majestic waters of the Atlantic Ocean, this will be a vacation you will remember.',
+    amenities = E'Ocean view\nKing bed\nSofa bed\nKitchenette\nFree Wi-Fi'
+    where room_name = 'Major''s Suite';
+create unique index rooms_slug_idx on rooms (slug);
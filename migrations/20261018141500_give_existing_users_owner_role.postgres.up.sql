@@ -0,0 +1,2 @@
+-- everyone could do everything in the admin before roles were enforced, so existing users keep that
+update users set access_level = 3;
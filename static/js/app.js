@@ -85,9 +85,6 @@ function Prompt() {
 }
 
 function BookNowModal() {
-  const ROOM_GENERALS_QUARTERS = "1";
-  const ROOM_MAJORS_SUITE = "2";
-
   let showForm = function (c) {
     const { roomID = "", CSRFToken = "" } = c;
 
@@ -108,6 +105,24 @@ function BookNowModal() {
           </div>
         </div>
       </div>
+      <div class="row mt-3">
+        <div class="col">
+          <select class="form-control" name="adults" id="adults">
+            <option value="1">1 adult</option>
+            <option value="2">2 adults</option>
+            <option value="3">3 adults</option>
+            <option value="4">4 adults</option>
+          </select>
+        </div>
+        <div class="col">
+          <select class="form-control" name="children" id="children">
+            <option value="0">No children</option>
+            <option value="1">1 child</option>
+            <option value="2">2 children</option>
+            <option value="3">3 children</option>
+          </select>
+        </div>
+      </div>
     </form
     `;
 
@@ -143,19 +158,32 @@ function BookNowModal() {
                     icon: "success",
                     msg:
                       "<p>Room is available!</p>" +
-                      '<p><a href="/book-room?id=' +
+                      '<form method="post" action="/book-room">' +
+                      '<input type="hidden" name="csrf_token" value="' +
+                      CSRFToken +
+                      '">' +
+                      '<input type="hidden" name="id" value="' +
                       data.room_id +
-                      "&s=" +
+                      '">' +
+                      '<input type="hidden" name="s" value="' +
                       data.start_date +
-                      "&e=" +
+                      '">' +
+                      '<input type="hidden" name="e" value="' +
                       data.end_date +
-                      '" class="btn btn-primary">' +
-                      "Book now!</a></p>",
+                      '">' +
+                      '<input type="hidden" name="a" value="' +
+                      data.adults +
+                      '">' +
+                      '<input type="hidden" name="c" value="' +
+                      data.children +
+                      '">' +
+                      '<p><input type="submit" class="btn btn-primary" value="Book now!"></p>' +
+                      "</form>",
                     showConfirmButton: false,
                   });
                 } else {
                   attention.error({
-                    msg: "No availability",
+                    msg: data.message || "No availability",
                   });
                 }
               });
@@ -166,7 +194,5 @@ function BookNowModal() {
 
   return {
     showForm: showForm,
-    ROOM_GENERALS_QUARTERS: ROOM_GENERALS_QUARTERS,
-    ROOM_MAJORS_SUITE: ROOM_MAJORS_SUITE,
   };
 }